@@ -3,11 +3,18 @@ package main
 import (
     "context"
     "log"
+    "net"
     "net/http"
+    "net/smtp"
     "os"
+    "time"
 
+    "github.com/iben12/counter-app/internal/alerts"
     "github.com/iben12/counter-app/internal/db"
     "github.com/iben12/counter-app/internal/handlers"
+    "github.com/iben12/counter-app/internal/models"
+    "github.com/iben12/counter-app/internal/webhooks"
+    "github.com/jackc/pgx/v5/pgxpool"
 )
 
 func main() {
@@ -29,7 +36,32 @@ func main() {
         log.Fatalf("migration failed: %v", err)
     }
 
-    r := handlers.NewRouter(pool)
+    cfg := handlers.DefaultConfig()
+    cfg.MaxBackdate = durationEnv("MAX_BACKDATE", cfg.MaxBackdate)
+    cfg.IdempotencyTTL = durationEnv("IDEMPOTENCY_TTL", cfg.IdempotencyTTL)
+    cfg.UndoWindow = durationEnv("UNDO_WINDOW", cfg.UndoWindow)
+    cfg.StreamKeepAlive = durationEnv("STREAM_KEEPALIVE", cfg.StreamKeepAlive)
+
+    go purgeIdempotencyKeys(ctx, pool)
+
+    dispatcher := webhooks.NewDispatcher(pool)
+    dispatcher.PollInterval = durationEnv("WEBHOOK_POLL_INTERVAL", dispatcher.PollInterval)
+    dispatcher.RolloverInterval = durationEnv("WEBHOOK_ROLLOVER_INTERVAL", dispatcher.RolloverInterval)
+    dispatcher.Client.Timeout = durationEnv("WEBHOOK_TIMEOUT", dispatcher.Client.Timeout)
+    go dispatcher.Run(ctx)
+
+    notifiers := map[string]alerts.Notifier{
+        models.ChannelLog:     alerts.LogNotifier{Logger: log.Default()},
+        models.ChannelWebhook: alerts.WebhookNotifier{Client: &http.Client{Timeout: dispatcher.Client.Timeout}},
+    }
+    if email := emailNotifier(); email != nil {
+        notifiers[models.ChannelEmail] = email
+    }
+    scheduler := alerts.NewScheduler(pool, notifiers)
+    scheduler.Interval = durationEnv("ALERT_INTERVAL", scheduler.Interval)
+    go scheduler.Run(ctx)
+
+    r := handlers.NewRouterWithConfig(pool, cfg)
 
     addr := os.Getenv("ADDR")
     if addr == "" {
@@ -41,3 +73,56 @@ func main() {
         log.Fatalf("server error: %v", err)
     }
 }
+
+// durationEnv reads a time.ParseDuration value (e.g. "72h") from the environment,
+// falling back to def when the variable is unset.
+func durationEnv(name string, def time.Duration) time.Duration {
+    v := os.Getenv(name)
+    if v == "" {
+        return def
+    }
+    d, err := time.ParseDuration(v)
+    if err != nil {
+        log.Fatalf("invalid %s: %v", name, err)
+    }
+    return d
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys once an hour until ctx
+// is cancelled.
+func purgeIdempotencyKeys(ctx context.Context, pool *pgxpool.Pool) {
+    ticker := time.NewTicker(time.Hour)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        if _, err := models.PurgeExpiredIdempotencyKeys(ctx, pool); err != nil {
+            log.Printf("failed to purge idempotency keys: %v", err)
+        }
+    }
+}
+
+// emailNotifier returns the notifier for the SMTP server at SMTP_ADDR, or nil
+// when email is not configured. SMTP_USERNAME and SMTP_PASSWORD enable PLAIN
+// authentication.
+func emailNotifier() alerts.Notifier {
+    addr := os.Getenv("SMTP_ADDR")
+    if addr == "" {
+        return nil
+    }
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        log.Fatalf("invalid SMTP_ADDR: %v", err)
+    }
+    n := alerts.EmailNotifier{Addr: addr, From: os.Getenv("SMTP_FROM")}
+    if n.From == "" {
+        n.From = "counter-app@" + host
+    }
+    if user := os.Getenv("SMTP_USERNAME"); user != "" {
+        n.Auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+    }
+    return n
+}
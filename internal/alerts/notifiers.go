@@ -0,0 +1,160 @@
+// Package alerts evaluates the counters' alert rules on a schedule and sends
+// the notifications of firing and resolved alerts to their channels.
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/iben12/counter-app/internal/models"
+)
+
+// Notifier sends a notification to a channel of its type. Implementations are
+// registered with a Scheduler by channel type, so further channel types only
+// need a Notifier of their own.
+type Notifier interface {
+	Notify(ctx context.Context, ch models.AlertChannel, n models.AlertNotification) error
+}
+
+// LogNotifier writes notifications to Logger.
+type LogNotifier struct {
+	Logger *log.Logger
+}
+
+// Notify logs n.
+func (l LogNotifier) Notify(ctx context.Context, ch models.AlertChannel, n models.AlertNotification) error {
+	l.Logger.Printf("alert %s: %s (counter %q, value %d)", n.Event, subject(n), n.CounterName, n.Value)
+	return nil
+}
+
+// WebhookNotifier posts notifications as JSON to the channel's URL. Any 2xx
+// response counts as sent.
+type WebhookNotifier struct {
+	Client *http.Client
+}
+
+// Notify posts n to ch.URL.
+func (wn WebhookNotifier) Notify(ctx context.Context, ch models.AlertChannel, n models.AlertNotification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wn.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// EmailNotifier sends notifications as plain text emails through the SMTP
+// server at Addr (host:port). STARTTLS is used when the server offers it, and
+// Auth, when set, after that.
+type EmailNotifier struct {
+	Addr    string
+	From    string
+	Auth    smtp.Auth
+	Timeout time.Duration
+}
+
+// Notify emails n to the recipients of ch.
+func (e EmailNotifier) Notify(ctx context.Context, ch models.AlertChannel, n models.AlertNotification) error {
+	host, _, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return err
+	}
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", e.Addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.Auth != nil {
+		if err := c.Auth(e.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range ch.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.message(ch.To, n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message formats the email of n.
+func (e EmailNotifier) message(to []string, n models.AlertNotification) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: [%s] %s\r\n", strings.ToUpper(n.Event), subject(n))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "Counter: %s (%d)\r\n", n.CounterName, n.CounterID)
+	fmt.Fprintf(&b, "Alert: %s\r\n", n.Alert.Message)
+	fmt.Fprintf(&b, "State: %s\r\n", n.Event)
+	fmt.Fprintf(&b, "Value: %d\r\n", n.Value)
+	fmt.Fprintf(&b, "Fired at: %s\r\n", n.Alert.FiredAt)
+	if n.Alert.ResolvedAt != nil {
+		fmt.Fprintf(&b, "Resolved at: %s\r\n", *n.Alert.ResolvedAt)
+	}
+	return b.Bytes()
+}
+
+// subject summarizes n in one line: the rule's name, or its kind, and the
+// alert's message.
+func subject(n models.AlertNotification) string {
+	name := n.RuleName
+	if name == "" {
+		name = n.RuleKind
+	}
+	return fmt.Sprintf("%s on %s: %s", name, n.CounterName, n.Alert.Message)
+}
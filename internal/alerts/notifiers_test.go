@@ -0,0 +1,162 @@
+package alerts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iben12/counter-app/internal/models"
+)
+
+// notification is a firing notification for the notifier tests.
+var notification = models.AlertNotification{
+	Event:       models.AlertFiring,
+	Alert:       models.Alert{ID: 7, RuleID: 3, CounterID: 1, State: models.AlertFiring, Value: 120, Message: "value 120 >= 100 in the current period", FiredAt: "2025-11-14T09:30:00Z"},
+	RuleName:    "too many",
+	RuleKind:    models.AlertThreshold,
+	CounterID:   1,
+	CounterName: "coffee",
+	Value:       120,
+}
+
+// TestLogNotifier tests that notifications are written to the logger.
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	n := LogNotifier{Logger: log.New(&buf, "", 0)}
+	if err := n.Notify(context.Background(), models.AlertChannel{Type: models.ChannelLog}, notification); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+	if got := buf.String(); !strings.Contains(got, "alert firing") || !strings.Contains(got, "too many on coffee") {
+		t.Errorf("unexpected log line: %q", got)
+	}
+}
+
+// TestWebhookNotifier tests that notifications are posted as JSON and that
+// error responses fail them.
+func TestWebhookNotifier(t *testing.T) {
+	var got models.AlertNotification
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n := WebhookNotifier{Client: srv.Client()}
+	ch := models.AlertChannel{Type: models.ChannelWebhook, URL: srv.URL}
+	if err := n.Notify(context.Background(), ch, notification); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+	if got.Alert.ID != 7 || got.Event != models.AlertFiring || got.Value != 120 {
+		t.Errorf("unexpected notification: %+v", got)
+	}
+	status = http.StatusInternalServerError
+	if err := n.Notify(context.Background(), ch, notification); err == nil {
+		t.Error("expected an error for a 500 response")
+	}
+}
+
+// smtpStub is a minimal SMTP server that accepts one message per connection
+// and records its envelope and data.
+type smtpStub struct {
+	ln   net.Listener
+	msgs chan smtpMessage
+}
+
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &smtpStub{ln: ln, msgs: make(chan smtpMessage, 1)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost stub")
+	var msg smtpMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			msg.data = data.String()
+			s.msgs <- msg
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// TestEmailNotifier tests sending a notification to a local SMTP stub.
+func TestEmailNotifier(t *testing.T) {
+	stub := newSMTPStub(t)
+	defer stub.ln.Close()
+
+	n := EmailNotifier{Addr: stub.ln.Addr().String(), From: "alerts@example.com"}
+	ch := models.AlertChannel{Type: models.ChannelEmail, To: []string{"ops@example.com", "me@example.com"}}
+	if err := n.Notify(context.Background(), ch, notification); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+	msg := <-stub.msgs
+	if msg.from != "alerts@example.com" || len(msg.to) != 2 || msg.to[0] != "ops@example.com" {
+		t.Errorf("unexpected envelope: %+v", msg)
+	}
+	if !strings.Contains(msg.data, "Subject: [FIRING] too many on coffee") || !strings.Contains(msg.data, "Value: 120") {
+		t.Errorf("unexpected message:\n%s", msg.data)
+	}
+}
@@ -0,0 +1,103 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/iben12/counter-app/internal/models"
+	"github.com/iben12/counter-app/internal/retry"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Scheduler evaluates the alert rules of all counters every Interval and
+// sends the queued notifications with the Notifier of their channel type.
+// Several schedulers may share a database; each notification is claimed by
+// one of them at a time.
+type Scheduler struct {
+	Pool *pgxpool.Pool
+	// Notifiers maps channel types to the notifier sending to them.
+	// Notifications to other types fail.
+	Notifiers map[string]Notifier
+	// Interval is how often rules are evaluated and notifications sent.
+	Interval time.Duration
+	// BatchSize is how many notifications are claimed at once.
+	BatchSize int
+	// Backoff spaces out the tries of a notification.
+	retry.Backoff
+	// Lease is how long a claimed batch is held back from other schedulers.
+	// It must exceed the time its notifiers may take.
+	Lease time.Duration
+}
+
+// NewScheduler returns a scheduler for pool with the default settings:
+// running every 30 seconds and trying a notification 5 times over about 15
+// minutes.
+func NewScheduler(pool *pgxpool.Pool, notifiers map[string]Notifier) *Scheduler {
+	return &Scheduler{
+		Pool:      pool,
+		Notifiers: notifiers,
+		Interval:  30 * time.Second,
+		BatchSize: 20,
+		Backoff:   retry.Backoff{MaxAttempts: 5, BaseDelay: time.Minute, MaxDelay: 30 * time.Minute},
+		Lease:     10 * time.Minute,
+	}
+}
+
+// Run evaluates and notifies every Interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Tick(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("alert scheduler failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick evaluates the alert rules of all counters once and sends the due
+// notifications.
+func (s *Scheduler) Tick(ctx context.Context) error {
+	if err := models.EvaluateAlerts(ctx, s.Pool); err != nil {
+		return err
+	}
+	return s.Send(ctx)
+}
+
+// Send sends all due notifications.
+func (s *Scheduler) Send(ctx context.Context) error {
+	for {
+		pending, err := models.ClaimAlertNotifications(ctx, s.Pool, s.BatchSize, s.Lease)
+		if err != nil {
+			return err
+		}
+		for _, p := range pending {
+			var sendErr error
+			if n, ok := s.Notifiers[p.Channel.Type]; ok {
+				sendErr = n.Notify(ctx, p.Channel, p.Notification)
+			} else {
+				sendErr = fmt.Errorf("no notifier for channel type %s", p.Channel.Type)
+			}
+			if ctx.Err() != nil {
+				// Shutting down; the notification is retried once its lease ends
+				return ctx.Err()
+			}
+			var retryAt time.Time
+			if sendErr != nil {
+				retryAt = s.RetryAt(time.Now(), p.Attempts+1)
+			}
+			if err := models.RecordAlertNotification(ctx, s.Pool, p.ID, sendErr, retryAt); err != nil {
+				return err
+			}
+		}
+		if len(pending) < s.BatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/iben12/counter-app/internal/models"
+	"github.com/iben12/counter-app/internal/testdb"
+)
+
+// TestScheduler tests that a firing and a resolved alert are each notified
+// once, and that a channel without a notifier fails.
+func TestScheduler(t *testing.T) {
+	pool, cleanup := testdb.Setup(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var received []models.AlertNotification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n models.AlertNotification
+		_ = json.NewDecoder(r.Body).Decode(&n)
+		mu.Lock()
+		received = append(received, n)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	counter, err := models.CreateCounter(ctx, pool, "scheduler-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	threshold := int64(3)
+	if _, err := models.CreateAlertRule(ctx, pool, counter.ID, models.AlertRuleSettings{
+		Kind:      models.AlertThreshold,
+		Threshold: &threshold,
+		Channels: []models.AlertChannel{
+			{Type: models.ChannelWebhook, URL: srv.URL},
+			{Type: models.ChannelEmail, To: []string{"ops@example.com"}},
+		},
+	}); err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+
+	s := NewScheduler(pool, map[string]Notifier{models.ChannelWebhook: WebhookNotifier{Client: srv.Client()}})
+	s.MaxAttempts = 1
+	steps := []struct {
+		name  string
+		delta int64
+	}{
+		{"fire", 3},
+		{"still firing", 1},
+		{"resolve", -2},
+	}
+	for _, step := range steps {
+		if _, err := models.IncrementCurrentCount(ctx, pool, counter.ID, step.delta); err != nil {
+			t.Fatalf("%s: failed to change count: %v", step.name, err)
+		}
+		if err := s.Tick(ctx); err != nil {
+			t.Fatalf("%s: tick failed: %v", step.name, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0].Event != models.AlertFiring || received[1].Event != models.AlertResolved {
+		t.Fatalf("expected a firing and a resolved notification, got %+v", received)
+	}
+	if received[0].Value != 3 || received[1].Value != 2 || received[0].Alert.ID != received[1].Alert.ID {
+		t.Errorf("unexpected notifications: %+v", received)
+	}
+
+	var failed int
+	if err := pool.QueryRow(ctx,
+		"SELECT count(*) FROM alert_notifications WHERE status = 'failed' AND last_error <> ''").Scan(&failed); err != nil {
+		t.Fatalf("failed to count notifications: %v", err)
+	}
+	if failed != 2 {
+		t.Errorf("expected the 2 email notifications to fail without a notifier, got %d", failed)
+	}
+}
@@ -3,33 +3,84 @@ package db
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
-// ParseFrequency parses a frequency string (e.g., "1h", "2d", "3w") and returns
-// the number of units and the unit type (h, d, w).
+// ParseFrequency parses a frequency string (e.g., "15m", "1h", "2d", "1b", "3w", "1M", "1q", "1y")
+// and returns the number of units and the unit type: m (minutes), h (hours), d (days),
+// b (business days), w (weeks), M (calendar months), q (quarters) or y (years). N must be positive.
 func ParseFrequency(freq string) (int, string, error) {
-	re := regexp.MustCompile(`^(\d+)([hdw])$`)
+	re := regexp.MustCompile(`^(\d+)([mhdbwMqy])$`)
 	matches := re.FindStringSubmatch(freq)
 	if matches == nil {
-		return 0, "", fmt.Errorf("invalid frequency format: %s (expected format: Nh, Nd, or Nw)", freq)
+		return 0, "", fmt.Errorf("invalid frequency format: %s (expected format: Nm, Nh, Nd, Nb, Nw, NM, Nq or Ny)", freq)
+	}
+	n, err := strconv.Atoi(matches[1])
+	if err != nil || n <= 0 {
+		return 0, "", fmt.Errorf("invalid frequency: %s (N must be a positive integer)", freq)
 	}
-	n, _ := strconv.Atoi(matches[1])
 	unit := matches[2]
 	return n, unit, nil
 }
 
-// NextExpiryTime calculates the next calendar-aligned expiry time based on the frequency and timezone.
-// For "1h": top of the next hour (in the given timezone)
+// PeriodOptions controls where weekly and multi-unit periods are aligned.
+type PeriodOptions struct {
+	// WeekStart is the day weekly periods begin on.
+	WeekStart time.Weekday
+	// Anchor, when non-zero, is the calendar date that N-day, N-week and N-month
+	// periods are counted from instead of the Unix epoch. Only its date is used.
+	Anchor time.Time
+	// Holidays lists distinct dates, as UTC midnight in ascending order, that
+	// business-day periods skip like weekends.
+	Holidays []time.Time
+}
+
+// DefaultPeriodOptions aligns weeks to Monday and counts periods from the epoch.
+var DefaultPeriodOptions = PeriodOptions{WeekStart: time.Monday}
+
+// ParseWeekday parses an English weekday name (e.g. "monday", "Sun") case-insensitively.
+func ParseWeekday(s string) (time.Weekday, error) {
+	name := strings.ToLower(s)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		full := strings.ToLower(d.String())
+		if name == full || name == full[:3] {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday: %s", s)
+}
+
+// NextExpiryTime calculates the next calendar-aligned expiry time based on the frequency and timezone,
+// using DefaultPeriodOptions.
+func NextExpiryTime(freq string, now time.Time, timezone string) (time.Time, error) {
+	return NextExpiryTimeWithOptions(freq, now, timezone, DefaultPeriodOptions)
+}
+
+// NextExpiryTimeWithOptions calculates the next calendar-aligned expiry time based on the frequency and timezone.
+// For "15m": next 15-minute boundary from midnight (in the given timezone)
+// For "1h": top of the next hour (in the given timezone); the hour repeated when DST ends is a period of its own
 // For "2h": next 2-hour boundary from midnight (in the given timezone)
 // For "1d": midnight of the next day (in the given timezone)
 // For "2d": next 2-day boundary from epoch (in the given timezone)
-// For "1w": midnight of the next Sunday (in the given timezone)
+// For "1b": midnight of the next business day, skipping weekends and holidays
+// For "2b": next 2-business-day boundary counted from the epoch
+// For "1w": midnight of the next week start day (in the given timezone)
 // For "2w": next 2-week boundary from epoch (in the given timezone)
+// For "1M": midnight of the 1st of the next month (in the given timezone)
+// For "1q": midnight of the 1st of the next quarter (Jan, Apr, Jul, Oct)
+// For "1y": midnight of the next Jan 1st (in the given timezone)
+// For "2M", "2q", "2y": next N-month boundary counted from January 1970
+//
+// With an anchor, N-day and N-business-day periods are counted from the anchor date, N-week periods
+// from the week start day on or before it, and N-month periods (and quarters and
+// years) from the anchor's month. Without one, weeks are counted from the first
+// week start day of 1970.
 //
 // The calculation is performed in the given timezone, then converted back to UTC.
-func NextExpiryTime(freq string, now time.Time, timezone string) (time.Time, error) {
+func NextExpiryTimeWithOptions(freq string, now time.Time, timezone string, opts PeriodOptions) (time.Time, error) {
 	n, unit, err := ParseFrequency(freq)
 	if err != nil {
 		return time.Time{}, err
@@ -40,42 +91,313 @@ func NextExpiryTime(freq string, now time.Time, timezone string) (time.Time, err
 	if err != nil {
 		return time.Time{}, fmt.Errorf("invalid timezone: %s", timezone)
 	}
+	return nextExpiryIn(n, unit, now, loc, opts)
+}
 
-	// Convert to the counter's timezone for calculation
+// nextExpiryIn is NextExpiryTimeWithOptions for a parsed frequency of n units
+// in a loaded location.
+func nextExpiryIn(n int, unit string, now time.Time, loc *time.Location, opts PeriodOptions) (time.Time, error) {
+	// Convert to the counter's timezone for calculation. Boundaries of a day or
+	// longer are built from the wall-clock date (see wallClock), so days that are
+	// 23 or 25 hours long because of DST still break at local midnight. Minute and
+	// hour boundaries follow the absolute time (see nextClockBoundary), so they
+	// stay on the local hour without merging the hour a clock repeats.
 	nowInTZ := now.In(loc)
+	y, m, d := nowInTZ.Date()
 
 	switch unit {
+	case "m":
+		// Next N-minute boundary from midnight (in timezone)
+		return nextClockBoundary(now, loc, n).UTC(), nil
+
 	case "h":
 		// Next N-hour boundary from midnight (in timezone)
-		hoursSinceMidnight := nowInTZ.Hour()
-		nextBoundaryHour := ((hoursSinceMidnight / n) + 1) * n
-		if nextBoundaryHour >= 24 {
-			// Roll over to next day at midnight
-			midnightTomorrow := nowInTZ.AddDate(0, 0, 1).Truncate(24 * time.Hour)
-			return midnightTomorrow, nil
-		}
-		midnightToday := nowInTZ.Truncate(24 * time.Hour)
-		boundaryTZ := midnightToday.Add(time.Duration(nextBoundaryHour) * time.Hour)
-		return boundaryTZ.UTC(), nil
+		return nextClockBoundary(now, loc, n*60).UTC(), nil
 
 	case "d":
-		// Next N-day boundary from midnight of day 0 (Unix epoch Jan 1, 1970) in timezone
-		epoch := time.Date(1970, 1, 1, 0, 0, 0, 0, loc)
-		daysSinceEpoch := int(nowInTZ.Sub(epoch).Hours() / 24)
-		nextBoundaryDay := ((daysSinceEpoch / n) + 1) * n
-		boundaryTZ := epoch.AddDate(0, 0, nextBoundaryDay).Truncate(24 * time.Hour)
-		return boundaryTZ.UTC(), nil
+		// Next N-day boundary counted in calendar days from the anchor (in timezone)
+		anchorDay := 0
+		if !opts.Anchor.IsZero() {
+			anchorDay = civilDays(opts.Anchor.Date())
+		}
+		nextBoundaryDay := (floorDiv(civilDays(y, m, d)-anchorDay, n) + 1) * n
+		return wallClock(1970, 1, 1+anchorDay+nextBoundaryDay, 0, 0, loc).UTC(), nil
+
+	case "b":
+		// Next N-business-day boundary (in timezone). A weekend or holiday belongs to
+		// the period of the business day before it.
+		anchorDay := 0
+		if !opts.Anchor.IsZero() {
+			anchorDay = civilDays(opts.Anchor.Date())
+		}
+		today := civilDays(y, m, d)
+		next := today + 1
+		if n > 1 {
+			// Index of the last business day on or before today, counted from the anchor
+			index := opts.businessDays(anchorDay, today) - 1
+			if index >= 0 {
+				remaining := (floorDiv(index, n)+1)*n - index
+				for ; ; next++ {
+					if opts.isBusinessDay(next) {
+						if remaining--; remaining == 0 {
+							break
+						}
+					}
+				}
+				return wallClock(1970, 1, 1+next, 0, 0, loc).UTC(), nil
+			}
+			// Before the anchor's first business day, the period runs up to it
+			next = anchorDay
+		}
+		for !opts.isBusinessDay(next) {
+			next++
+		}
+		return wallClock(1970, 1, 1+next, 0, 0, loc).UTC(), nil
 
 	case "w":
-		// Next N-week boundary: Sunday midnight N weeks from epoch (in timezone)
-		// Epoch Jan 1, 1970 was a Thursday, so first Monday is Jan 4, 1970
-		epoch := time.Date(1970, 1, 5, 0, 0, 0, 0, loc) // First Monday
-		weeksSinceEpoch := int(nowInTZ.Sub(epoch).Hours() / (24 * 7))
-		nextBoundaryWeek := ((weeksSinceEpoch / n) + 1) * n
-		boundaryTZ := epoch.AddDate(0, 0, nextBoundaryWeek*7).Truncate(24 * time.Hour)
-		return boundaryTZ.UTC(), nil
+		// Next N-week boundary: week start midnight N weeks from the anchor week (in timezone).
+		// Jan 1, 1970 was a Thursday, so without an anchor the first week starts on
+		// the first week start day on or after it (Monday Jan 5 by default).
+		var firstWeekDay int
+		if opts.Anchor.IsZero() {
+			firstWeekDay = (int(opts.WeekStart) - int(time.Thursday) + 7) % 7
+		} else {
+			anchorDay := civilDays(opts.Anchor.Date())
+			firstWeekDay = anchorDay - (int(opts.Anchor.Weekday())-int(opts.WeekStart)+7)%7
+		}
+		weeksSinceAnchor := floorDiv(civilDays(y, m, d)-firstWeekDay, 7)
+		nextBoundaryWeek := (floorDiv(weeksSinceAnchor, n) + 1) * n
+		return wallClock(1970, 1, 1+firstWeekDay+nextBoundaryWeek*7, 0, 0, loc).UTC(), nil
+
+	case "M", "q", "y":
+		// Next N-month boundary counted from the anchor month, January 1970 by default
+		// (in timezone). The month is normalized by the calendar, so months of
+		// different lengths need no special casing.
+		months := n
+		if unit == "q" {
+			months = n * 3
+		} else if unit == "y" {
+			months = n * 12
+		}
+		anchorYear, anchorMonth := 1970, time.January
+		if !opts.Anchor.IsZero() {
+			anchorYear, anchorMonth = opts.Anchor.Year(), opts.Anchor.Month()
+		}
+		monthsSinceAnchor := (y-anchorYear)*12 + int(m) - int(anchorMonth)
+		nextBoundaryMonth := (floorDiv(monthsSinceAnchor, months) + 1) * months
+		return wallClock(anchorYear, anchorMonth+time.Month(nextBoundaryMonth), 1, 0, 0, loc).UTC(), nil
 
 	default:
 		return time.Time{}, fmt.Errorf("unknown unit: %s", unit)
 	}
 }
+
+// periodIndex numbers the periods of n units of a day or longer: the period
+// containing now has the number one below that of the period beginning at the
+// boundary nextExpiryIn returns.
+func periodIndex(n int, unit string, now time.Time, loc *time.Location, opts PeriodOptions) int {
+	y, m, d := now.In(loc).Date()
+	today := civilDays(y, m, d)
+	anchorDay := 0
+	if !opts.Anchor.IsZero() {
+		anchorDay = civilDays(opts.Anchor.Date())
+	}
+	switch unit {
+	case "d":
+		return floorDiv(today-anchorDay, n)
+
+	case "b":
+		// Index of the last business day on or before today, counted from the anchor
+		index := opts.businessDays(anchorDay, today) - opts.businessDays(today+1, anchorDay-1) - 1
+		if n > 1 && index < 0 {
+			// Before the anchor's first business day, the period runs up to it
+			return -1
+		}
+		return floorDiv(index, n)
+
+	case "w":
+		var firstWeekDay int
+		if opts.Anchor.IsZero() {
+			firstWeekDay = (int(opts.WeekStart) - int(time.Thursday) + 7) % 7
+		} else {
+			firstWeekDay = anchorDay - (int(opts.Anchor.Weekday())-int(opts.WeekStart)+7)%7
+		}
+		return floorDiv(floorDiv(today-firstWeekDay, 7), n)
+
+	default:
+		months := n
+		if unit == "q" {
+			months = n * 3
+		} else if unit == "y" {
+			months = n * 12
+		}
+		anchorYear, anchorMonth := 1970, time.January
+		if !opts.Anchor.IsZero() {
+			anchorYear, anchorMonth = opts.Anchor.Year(), opts.Anchor.Month()
+		}
+		return floorDiv((y-anchorYear)*12+int(m)-int(anchorMonth), months)
+	}
+}
+
+// countClockPeriods counts the boundaries nextClockBoundary finds after from
+// and at or before to. While the zone offset stays the same the boundaries are
+// the wall-clock multiples of step, so they are counted by division, and only
+// those next to an offset change are stepped through.
+func countClockPeriods(from, to time.Time, loc *time.Location, step int) int {
+	const day = 24 * 60 * 60
+	stepSeconds := step * 60
+	perDay := (day + stepSeconds - 1) / stepSeconds
+	// index numbers the boundaries at or before a wall time, midnight included
+	index := func(wall int) int {
+		days := floorDiv(wall, day)
+		return days*perDay + floorDiv(wall-days*day, stepSeconds)
+	}
+
+	n := 0
+	t := from
+	for {
+		cur := t.In(loc)
+		_, offset := cur.Zone()
+		limit := to
+		if _, end := cur.ZoneBounds(); !end.IsZero() && end.Before(limit) {
+			limit = end
+		}
+		// Skip to the last boundary but one before limit
+		first := index(int(t.Unix()) + offset)
+		if k := index(int(limit.Unix())+offset-1) - first; k > 1 {
+			j := first + k - 1
+			days := floorDiv(j, perDay)
+			t = time.Unix(int64(days*day+(j-days*perDay)*stepSeconds-offset), 0)
+			n += k - 1
+		}
+		next := nextClockBoundary(t, loc, step)
+		if next.After(to) {
+			return n
+		}
+		n++
+		t = next
+	}
+}
+
+// isBusinessDay reports whether the civil day (days since Jan 1, 1970) is neither
+// a weekend day nor a holiday.
+func (opts PeriodOptions) isBusinessDay(day int) bool {
+	if isWeekend(day) {
+		return false
+	}
+	i := opts.holidayIndex(day)
+	return i == len(opts.Holidays) || holidayDay(opts.Holidays[i]) != day
+}
+
+// businessDays counts the business days from the civil day from through to,
+// both included. Weekdays are counted per week, so the cost does not grow with
+// the length of the range, only with the holidays in it.
+func (opts PeriodOptions) businessDays(from, to int) int {
+	if to < from {
+		return 0
+	}
+	count := weekdaysBefore(to+1) - weekdaysBefore(from)
+	for i := opts.holidayIndex(from); i < len(opts.Holidays); i++ {
+		day := holidayDay(opts.Holidays[i])
+		if day > to {
+			break
+		}
+		if !isWeekend(day) {
+			count--
+		}
+	}
+	return count
+}
+
+// holidayIndex returns the index of the first holiday on or after the civil day.
+func (opts PeriodOptions) holidayIndex(day int) int {
+	return sort.Search(len(opts.Holidays), func(i int) bool { return holidayDay(opts.Holidays[i]) >= day })
+}
+
+// holidayDay returns the civil day of a holiday date.
+func holidayDay(date time.Time) int {
+	return civilDays(date.Date())
+}
+
+// isWeekend reports whether the civil day is a Saturday or Sunday.
+func isWeekend(day int) bool {
+	// Jan 1, 1970 was a Thursday
+	wd := time.Weekday((day%7 + 7 + int(time.Thursday)) % 7)
+	return wd == time.Saturday || wd == time.Sunday
+}
+
+// weekdaysBefore counts the weekdays from Jan 1, 1970 up to the civil day,
+// excluding it; the count is negative for days before the epoch.
+func weekdaysBefore(day int) int {
+	// Weekdays among the first n days of a week that starts on a Thursday
+	partial := [7]int{0, 1, 2, 2, 2, 3, 4}
+	weeks := floorDiv(day, 7)
+	return weeks*5 + partial[day-weeks*7]
+}
+
+// floorDiv divides rounding towards negative infinity, so boundaries before the
+// epoch align the same way as those after it.
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// nextClockBoundary returns the first instant after now at which the wall clock
+// in loc shows a multiple of step minutes since midnight, or midnight itself.
+// It follows the absolute time one zone offset at a time: when the clock is set
+// back onto a boundary, such as 01:00 in the hour repeated at the end of DST,
+// a new period starts there, and a boundary skipped by a jump forward falls on
+// the instant of the jump.
+func nextClockBoundary(now time.Time, loc *time.Location, step int) time.Time {
+	const day = 24 * 60 * 60
+	stepSeconds := step * 60
+	cur := now.In(loc)
+	for {
+		_, offset := cur.Zone()
+		wall := int(cur.Unix()) + offset
+		midnight := floorDiv(wall, day) * day
+		next := min(midnight+(floorDiv(wall-midnight, stepSeconds)+1)*stepSeconds, midnight+day)
+		at := time.Unix(int64(next-offset), 0).In(loc)
+		_, end := cur.ZoneBounds()
+		if end.IsZero() || !at.After(end) {
+			return at
+		}
+		// The offset changes before the boundary is reached
+		end = end.In(loc)
+		_, endOffset := end.Zone()
+		endWall := int(end.Unix()) + endOffset
+		endMidnight := floorDiv(endWall, day) * day
+		if endWall >= next || (endWall-endMidnight)%stepSeconds == 0 {
+			return end
+		}
+		cur = end
+	}
+}
+
+// wallClock returns the instant at which the wall clock in loc shows the given
+// date and time. Out-of-range values are normalized as by time.Date. A time that
+// is skipped by a DST jump resolves to the instant of the jump, since time.Date
+// does not guarantee which side of the gap it picks.
+func wallClock(year int, month time.Month, day, hour, min int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, min, 0, 0, loc)
+	want := time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	if got.Equal(want) {
+		return t
+	}
+	start, end := t.ZoneBounds()
+	if got.Before(want) {
+		// Resolved into the zone before the jump; the gap begins where it ends
+		return end
+	}
+	return start
+}
+
+// civilDays returns the number of calendar days from Jan 1, 1970 to the given
+// date, independent of any timezone offset or DST shift.
+func civilDays(year int, month time.Month, day int) int {
+	return int(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
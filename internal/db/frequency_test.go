@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"slices"
 	"testing"
 	"time"
 )
@@ -17,8 +18,14 @@ func TestParseFrequency(t *testing.T) {
 		{"2d", 2, "d", false},
 		{"3w", 3, "w", false},
 		{"10h", 10, "h", false},
+		{"15m", 15, "m", false},
+		{"1M", 1, "M", false},
+		{"2q", 2, "q", false},
+		{"1y", 1, "y", false},
+		{"5b", 5, "b", false},
 		{"invalid", 0, "", true},
-		{"1m", 0, "", true},
+		{"1x", 0, "", true},
+		{"0d", 0, "", true},
 		{"1", 0, "", true},
 	}
 	for _, tt := range tests {
@@ -86,6 +93,198 @@ func TestNextExpiryTime(t *testing.T) {
 	}
 }
 
+func TestNextExpiryTimeCalendarUnits(t *testing.T) {
+	budapest, err := time.LoadLocation("Europe/Budapest")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		freq     string
+		now      time.Time
+		timezone string
+		want     time.Time
+	}{
+		{"15m at 14:30", "15m", time.Date(2025, 11, 14, 14, 30, 0, 0, time.UTC), "UTC", time.Date(2025, 11, 14, 14, 45, 0, 0, time.UTC)},
+		{"45m at 23:50", "45m", time.Date(2025, 11, 14, 23, 50, 0, 0, time.UTC), "UTC", time.Date(2025, 11, 15, 0, 0, 0, 0, time.UTC)},
+		{"1M on Jan 31", "1M", time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC), "UTC", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"1M on leap Feb 29", "1M", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), "UTC", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"1M in December", "1M", time.Date(2025, 12, 31, 23, 59, 0, 0, time.UTC), "UTC", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"2M in November", "2M", time.Date(2025, 11, 14, 14, 30, 0, 0, time.UTC), "UTC", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"1q in May", "1q", time.Date(2025, 5, 20, 0, 0, 0, 0, time.UTC), "UTC", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"1q on quarter start", "1q", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), "UTC", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"1y", "1y", time.Date(2025, 11, 14, 14, 30, 0, 0, time.UTC), "UTC", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"2y", "2y", time.Date(2025, 11, 14, 14, 30, 0, 0, time.UTC), "UTC", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// 23:30 UTC on Jan 31 is already Feb 1 in Budapest, so the period ends on Mar 1 local time
+		{"1M in Budapest across month end", "1M", time.Date(2025, 1, 31, 23, 30, 0, 0, time.UTC), "Europe/Budapest", time.Date(2025, 3, 1, 0, 0, 0, 0, budapest)},
+		{"1y in Budapest", "1y", time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), "Europe/Budapest", time.Date(2026, 1, 1, 0, 0, 0, 0, budapest)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextExpiryTime(tt.freq, tt.now, tt.timezone)
+			if err != nil {
+				t.Fatalf("NextExpiryTime() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("NextExpiryTime() = %v, want %v", got, tt.want.UTC())
+			}
+		})
+	}
+}
+
+func TestNextExpiryTimeDST(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		timezone string
+		freq     string
+		now      time.Time
+		want     time.Time
+	}{
+		// Europe/Budapest: 2025-03-30 02:00 CET -> 03:00 CEST, 2025-10-26 03:00 CEST -> 02:00 CET
+		{"Budapest spring 1h over skipped hour", "Europe/Budapest", "1h", utc(2025, 3, 30, 0, 30), utc(2025, 3, 30, 1, 0)},
+		{"Budapest spring 6h", "Europe/Budapest", "6h", utc(2025, 3, 30, 0, 30), utc(2025, 3, 30, 4, 0)},
+		{"Budapest spring 1d from just after midnight", "Europe/Budapest", "1d", utc(2025, 3, 29, 23, 30), utc(2025, 3, 30, 22, 0)},
+		{"Budapest spring 1d", "Europe/Budapest", "1d", utc(2025, 3, 30, 10, 0), utc(2025, 3, 30, 22, 0)},
+		{"Budapest spring 1w", "Europe/Budapest", "1w", utc(2025, 3, 30, 10, 0), utc(2025, 3, 30, 22, 0)},
+		{"Budapest fall 1h in first 02:30", "Europe/Budapest", "1h", utc(2025, 10, 26, 0, 30), utc(2025, 10, 26, 1, 0)},
+		{"Budapest fall 1h in second 02:30", "Europe/Budapest", "1h", utc(2025, 10, 26, 1, 30), utc(2025, 10, 26, 2, 0)},
+		{"Budapest fall 2h onto repeated boundary", "Europe/Budapest", "2h", utc(2025, 10, 26, 0, 30), utc(2025, 10, 26, 1, 0)},
+		{"Budapest fall 2h after repeated boundary", "Europe/Budapest", "2h", utc(2025, 10, 26, 1, 30), utc(2025, 10, 26, 3, 0)},
+		{"Budapest fall 1d", "Europe/Budapest", "1d", utc(2025, 10, 26, 12, 0), utc(2025, 10, 26, 23, 0)},
+		{"Budapest fall 1d from just after midnight", "Europe/Budapest", "1d", utc(2025, 10, 25, 22, 30), utc(2025, 10, 26, 23, 0)},
+
+		// America/New_York: 2025-03-09 02:00 EST -> 03:00 EDT, 2025-11-02 02:00 EDT -> 01:00 EST
+		{"New York spring 1h over skipped hour", "America/New_York", "1h", utc(2025, 3, 9, 6, 30), utc(2025, 3, 9, 7, 0)},
+		{"New York spring 4h", "America/New_York", "4h", utc(2025, 3, 9, 8, 0), utc(2025, 3, 9, 12, 0)},
+		{"New York spring 1d", "America/New_York", "1d", utc(2025, 3, 9, 5, 30), utc(2025, 3, 10, 4, 0)},
+		{"New York fall 1h first 01:30", "America/New_York", "1h", utc(2025, 11, 2, 5, 30), utc(2025, 11, 2, 6, 0)},
+		{"New York fall 15m first 01:50", "America/New_York", "15m", utc(2025, 11, 2, 5, 50), utc(2025, 11, 2, 6, 0)},
+		{"New York fall 1h second 01:30", "America/New_York", "1h", utc(2025, 11, 2, 6, 30), utc(2025, 11, 2, 7, 0)},
+		{"New York fall 1d", "America/New_York", "1d", utc(2025, 11, 2, 12, 0), utc(2025, 11, 3, 5, 0)},
+		{"New York fall 1w", "America/New_York", "1w", utc(2025, 11, 2, 12, 0), utc(2025, 11, 3, 5, 0)},
+		{"New York fall 2d", "America/New_York", "2d", utc(2025, 11, 2, 12, 0), utc(2025, 11, 4, 5, 0)},
+
+		// Australia/Lord_Howe shifts by 30 minutes: 2025-04-06 02:00 -> 01:30, 2025-10-05 02:00 -> 02:30
+		{"Lord Howe fall 1h first 01:45", "Australia/Lord_Howe", "1h", utc(2025, 4, 5, 14, 45), utc(2025, 4, 5, 15, 0)},
+		{"Lord Howe fall 1h second 01:40", "Australia/Lord_Howe", "1h", utc(2025, 4, 5, 15, 10), utc(2025, 4, 5, 15, 30)},
+		{"Lord Howe fall 1d", "Australia/Lord_Howe", "1d", utc(2025, 4, 6, 0, 0), utc(2025, 4, 6, 13, 30)},
+		{"Lord Howe spring 1h over skipped half hour", "Australia/Lord_Howe", "1h", utc(2025, 10, 4, 15, 15), utc(2025, 10, 4, 15, 30)},
+		{"Lord Howe spring 1h after jump", "Australia/Lord_Howe", "1h", utc(2025, 10, 4, 15, 40), utc(2025, 10, 4, 16, 0)},
+		{"Lord Howe spring 1d", "Australia/Lord_Howe", "1d", utc(2025, 10, 4, 14, 0), utc(2025, 10, 5, 13, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextExpiryTime(tt.freq, tt.now, tt.timezone)
+			if err != nil {
+				t.Fatalf("NextExpiryTime() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("NextExpiryTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextExpiryTimeWithOptions(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	friday := time.Date(2025, 11, 14, 14, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		freq string
+		now  time.Time
+		opts PeriodOptions
+		want time.Time
+	}{
+		{"1w starting Sunday", "1w", friday, PeriodOptions{WeekStart: time.Sunday}, date(2025, 11, 16)},
+		{"1w starting Saturday on a Saturday", "1w", date(2025, 11, 15), PeriodOptions{WeekStart: time.Saturday}, date(2025, 11, 22)},
+		{"2w default keeps epoch Monday alignment", "2w", friday, DefaultPeriodOptions, date(2025, 11, 24)},
+		{"2w sprint mid-sprint", "2w", date(2025, 1, 15), PeriodOptions{WeekStart: time.Monday, Anchor: date(2025, 1, 6)}, date(2025, 1, 20)},
+		{"2w sprint on boundary", "2w", date(2025, 1, 20), PeriodOptions{WeekStart: time.Monday, Anchor: date(2025, 1, 6)}, date(2025, 2, 3)},
+		{"2w sprint before anchor", "2w", date(2024, 12, 30), PeriodOptions{WeekStart: time.Monday, Anchor: date(2025, 1, 6)}, date(2025, 1, 6)},
+		{"2w anchor snaps back to week start", "2w", date(2025, 1, 15), PeriodOptions{WeekStart: time.Monday, Anchor: date(2025, 1, 8)}, date(2025, 1, 20)},
+		{"3d from anchor", "3d", date(2025, 1, 12), PeriodOptions{WeekStart: time.Monday, Anchor: date(2025, 1, 10)}, date(2025, 1, 13)},
+		{"1q fiscal quarter from February", "1q", date(2025, 4, 15), PeriodOptions{WeekStart: time.Monday, Anchor: date(2025, 2, 1)}, date(2025, 5, 1)},
+		{"1y fiscal year from July", "1y", date(2025, 11, 14), PeriodOptions{WeekStart: time.Monday, Anchor: date(2020, 7, 1)}, date(2026, 7, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextExpiryTimeWithOptions(tt.freq, tt.now, "UTC", tt.opts)
+			if err != nil {
+				t.Fatalf("NextExpiryTimeWithOptions() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("NextExpiryTimeWithOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextExpiryTimeBusinessDays(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	holidays := []time.Time{date(2025, 12, 24), date(2025, 12, 25), date(2025, 12, 26)}
+	christmas := PeriodOptions{WeekStart: time.Monday, Holidays: holidays}
+	sprint := PeriodOptions{WeekStart: time.Monday, Anchor: date(2025, 11, 3)}
+	budapest, err := time.LoadLocation("Europe/Budapest")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		freq     string
+		now      time.Time
+		timezone string
+		opts     PeriodOptions
+		want     time.Time
+	}{
+		{"1b on a Friday", "1b", time.Date(2025, 11, 14, 14, 30, 0, 0, time.UTC), "UTC", DefaultPeriodOptions, date(2025, 11, 17)},
+		{"1b on a Saturday", "1b", date(2025, 11, 15), "UTC", DefaultPeriodOptions, date(2025, 11, 17)},
+		{"1b midweek", "1b", date(2025, 11, 18), "UTC", DefaultPeriodOptions, date(2025, 11, 19)},
+		{"1b before holidays", "1b", date(2025, 12, 23), "UTC", christmas, date(2025, 12, 29)},
+		{"1b on a holiday", "1b", date(2025, 12, 25), "UTC", christmas, date(2025, 12, 29)},
+		{"1b local Saturday in Budapest", "1b", time.Date(2025, 11, 14, 23, 30, 0, 0, time.UTC), "Europe/Budapest", DefaultPeriodOptions, time.Date(2025, 11, 17, 0, 0, 0, 0, budapest)},
+		{"2b from anchor", "2b", date(2025, 11, 5), "UTC", sprint, date(2025, 11, 7)},
+		{"2b on boundary", "2b", date(2025, 11, 7), "UTC", sprint, date(2025, 11, 11)},
+		{"2b over weekend", "2b", date(2025, 11, 8), "UTC", sprint, date(2025, 11, 11)},
+		{"2b before anchor", "2b", date(2025, 11, 1), "UTC", sprint, date(2025, 11, 3)},
+		{"2b over holidays", "2b", date(2025, 12, 23), "UTC",
+			PeriodOptions{WeekStart: time.Monday, Anchor: date(2025, 12, 22), Holidays: holidays}, date(2025, 12, 29)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextExpiryTimeWithOptions(tt.freq, tt.now, tt.timezone, tt.opts)
+			if err != nil {
+				t.Fatalf("NextExpiryTimeWithOptions() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("NextExpiryTimeWithOptions() = %v, want %v", got, tt.want.UTC())
+			}
+		})
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	for in, want := range map[string]time.Weekday{"monday": time.Monday, "Sunday": time.Sunday, "sat": time.Saturday} {
+		got, err := ParseWeekday(in)
+		if err != nil || got != want {
+			t.Errorf("ParseWeekday(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseWeekday("someday"); err == nil {
+		t.Error("expected error for unknown weekday")
+	}
+}
+
 // Quick manual test for debugging
 func TestNextExpiryTimeManual(t *testing.T) {
 	now := time.Date(2025, 11, 14, 14, 30, 0, 0, time.UTC)
@@ -96,3 +295,38 @@ func TestNextExpiryTimeManual(t *testing.T) {
 		fmt.Printf("Freq %s -> Expiry: %s\n", freq, exp.Format("2006-01-02 15:04 (Monday)"))
 	}
 }
+
+// TestBusinessDays tests the per-week count of business days against checking
+// every day of the range.
+func TestBusinessDays(t *testing.T) {
+	holidays := []time.Time{
+		time.Date(1969, 12, 25, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 12, 25, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 12, 27, 0, 0, 0, 0, time.UTC), // a Saturday
+		time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	opts := PeriodOptions{Holidays: holidays}
+	count := func(from, to int) int {
+		n := 0
+		for day := from; day <= to; day++ {
+			date := time.Unix(int64(day)*86400, 0).UTC()
+			if wd := date.Weekday(); wd != time.Saturday && wd != time.Sunday && !slices.ContainsFunc(holidays, date.Equal) {
+				n++
+			}
+		}
+		return n
+	}
+
+	christmas := civilDays(2025, 12, 25)
+	ranges := [][2]int{{christmas - 10, christmas + 10}, {christmas, christmas}, {-400, christmas + 30}}
+	for from := -10; from < 10; from++ {
+		for to := from - 1; to < from+15; to++ {
+			ranges = append(ranges, [2]int{from, to})
+		}
+	}
+	for _, r := range ranges {
+		if got, want := opts.businessDays(r[0], r[1]), count(r[0], r[1]); got != want {
+			t.Errorf("businessDays(%d, %d) = %d, want %d", r[0], r[1], got, want)
+		}
+	}
+}
@@ -0,0 +1 @@
+ALTER TABLE counts DROP CONSTRAINT IF EXISTS counts_counter_id_expiry_key;
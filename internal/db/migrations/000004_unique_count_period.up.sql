@@ -0,0 +1,15 @@
+-- Fold duplicate period rows left behind by concurrent rollovers into the oldest one
+UPDATE counts c SET value = d.total
+FROM (
+    SELECT MIN(id) AS id, SUM(value) AS total
+    FROM counts
+    GROUP BY counter_id, expiry
+    HAVING COUNT(*) > 1
+) d
+WHERE c.id = d.id;
+
+DELETE FROM counts c
+USING counts o
+WHERE c.counter_id = o.counter_id AND c.expiry = o.expiry AND c.id > o.id;
+
+ALTER TABLE counts ADD CONSTRAINT counts_counter_id_expiry_key UNIQUE (counter_id, expiry);
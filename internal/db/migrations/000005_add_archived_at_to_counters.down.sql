@@ -0,0 +1 @@
+ALTER TABLE counters DROP COLUMN archived_at;
@@ -0,0 +1 @@
+ALTER TABLE counters ADD COLUMN archived_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+ALTER TABLE counters DROP COLUMN anchor;
+ALTER TABLE counters DROP COLUMN week_start;
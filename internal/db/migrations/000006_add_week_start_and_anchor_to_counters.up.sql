@@ -0,0 +1,3 @@
+-- week_start uses Go's time.Weekday numbering (0 = Sunday); 1 keeps the previous Monday alignment
+ALTER TABLE counters ADD COLUMN week_start SMALLINT NOT NULL DEFAULT 1 CHECK (week_start BETWEEN 0 AND 6);
+ALTER TABLE counters ADD COLUMN anchor DATE;
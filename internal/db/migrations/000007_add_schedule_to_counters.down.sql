@@ -0,0 +1,2 @@
+ALTER TABLE counters DROP COLUMN schedule;
+ALTER TABLE counters DROP COLUMN schedule_type;
@@ -0,0 +1,3 @@
+-- schedule_type selects the db.Scheduler; schedule holds its cron or RRULE expression
+ALTER TABLE counters ADD COLUMN schedule_type TEXT NOT NULL DEFAULT 'frequency';
+ALTER TABLE counters ADD COLUMN schedule TEXT NOT NULL DEFAULT '';
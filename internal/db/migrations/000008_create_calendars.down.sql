@@ -0,0 +1,3 @@
+ALTER TABLE counters DROP COLUMN calendar_id;
+DROP TABLE IF EXISTS calendar_holidays;
+DROP TABLE IF EXISTS calendars;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS calendars (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS calendar_holidays (
+    calendar_id INTEGER NOT NULL REFERENCES calendars(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (calendar_id, day)
+);
+
+ALTER TABLE counters ADD COLUMN calendar_id INTEGER REFERENCES calendars(id) ON DELETE SET NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS events (
+    id BIGSERIAL PRIMARY KEY,
+    counter_id INTEGER NOT NULL REFERENCES counters(id) ON DELETE CASCADE,
+    count_id INTEGER NOT NULL REFERENCES counts(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    delta BIGINT NOT NULL,
+    previous_value BIGINT NOT NULL,
+    value BIGINT NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    actor TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_counter_id_created_at ON events(counter_id, created_at, id);
@@ -0,0 +1,3 @@
+-- occurred_at is when the change happened on the client; created_at is when it was recorded
+ALTER TABLE events ADD COLUMN occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+UPDATE events SET occurred_at = created_at;
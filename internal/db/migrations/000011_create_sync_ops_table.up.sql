@@ -0,0 +1,7 @@
+-- sync_ops remembers the client-generated IDs of applied /sync operations so replays are no-ops
+CREATE TABLE IF NOT EXISTS sync_ops (
+    op_id TEXT PRIMARY KEY,
+    counter_id INTEGER NOT NULL REFERENCES counters(id) ON DELETE CASCADE,
+    event_id BIGINT REFERENCES events(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
@@ -0,0 +1,2 @@
+ALTER TABLE events DROP COLUMN reverts_event_id;
+ALTER TABLE events DROP COLUMN undone_at;
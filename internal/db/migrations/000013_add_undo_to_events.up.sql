@@ -0,0 +1,4 @@
+-- undone_at marks an operation reverted by a later undo (or an undo reverted by a redo);
+-- reverts_event_id links undo and redo events to the event they revert
+ALTER TABLE events ADD COLUMN undone_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE events ADD COLUMN reverts_event_id BIGINT REFERENCES events(id) ON DELETE SET NULL;
@@ -0,0 +1,5 @@
+ALTER TABLE counters DROP CONSTRAINT counters_bounds_check;
+ALTER TABLE counters DROP COLUMN bound_mode;
+ALTER TABLE counters DROP COLUMN goal;
+ALTER TABLE counters DROP COLUMN max_value;
+ALTER TABLE counters DROP COLUMN min_value;
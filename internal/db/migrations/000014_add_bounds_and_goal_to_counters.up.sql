@@ -0,0 +1,6 @@
+-- A NULL bound is open; min_value defaults to 0 to keep the previous clamp at zero
+ALTER TABLE counters ADD COLUMN min_value BIGINT DEFAULT 0;
+ALTER TABLE counters ADD COLUMN max_value BIGINT;
+ALTER TABLE counters ADD COLUMN goal BIGINT CHECK (goal > 0);
+ALTER TABLE counters ADD COLUMN bound_mode TEXT NOT NULL DEFAULT 'clamp' CHECK (bound_mode IN ('clamp', 'reject'));
+ALTER TABLE counters ADD CONSTRAINT counters_bounds_check CHECK (min_value IS NULL OR max_value IS NULL OR min_value <= max_value);
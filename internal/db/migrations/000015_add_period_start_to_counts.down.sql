@@ -0,0 +1 @@
+ALTER TABLE counts DROP COLUMN period_start;
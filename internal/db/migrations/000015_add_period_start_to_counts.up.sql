@@ -0,0 +1,27 @@
+ALTER TABLE counts ADD COLUMN period_start TIMESTAMPTZ;
+
+-- Fixed frequencies step back one period in the counter's wall clock; the
+-- previous row's expiry bounds that from below. Rows of other schedules start
+-- at the previous row's expiry, or when they were created if they are the first.
+UPDATE counts SET period_start = s.period_start
+FROM (
+    SELECT counts.id, COALESCE(GREATEST(
+        lag(counts.expiry) OVER (PARTITION BY counts.counter_id ORDER BY counts.expiry),
+        CASE WHEN counters.schedule_type = 'frequency' AND right(counters.frequency, 1) <> 'b' THEN
+            (counts.expiry AT TIME ZONE counters.timezone
+             - left(counters.frequency, -1)::INTEGER * CASE right(counters.frequency, 1)
+                WHEN 'm' THEN interval '1 minute'
+                WHEN 'h' THEN interval '1 hour'
+                WHEN 'd' THEN interval '1 day'
+                WHEN 'w' THEN interval '1 week'
+                WHEN 'M' THEN interval '1 month'
+                WHEN 'q' THEN interval '3 months'
+                WHEN 'y' THEN interval '1 year'
+             END) AT TIME ZONE counters.timezone
+        END
+    ), LEAST(counts.created_at, counts.expiry)) AS period_start
+    FROM counts JOIN counters ON counters.id = counts.counter_id
+) s
+WHERE counts.id = s.id;
+
+ALTER TABLE counts ALTER COLUMN period_start SET NOT NULL;
@@ -0,0 +1,4 @@
+DROP TRIGGER counts_counter_totals ON counts;
+DROP FUNCTION counts_update_counter_totals();
+ALTER TABLE counters DROP COLUMN period_count;
+ALTER TABLE counters DROP COLUMN lifetime_total;
@@ -0,0 +1,31 @@
+ALTER TABLE counters ADD COLUMN lifetime_total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE counters ADD COLUMN period_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE counters SET lifetime_total = t.total, period_count = t.periods
+FROM (SELECT counter_id, sum(value) AS total, count(*) AS periods FROM counts GROUP BY counter_id) t
+WHERE counters.id = t.counter_id;
+
+-- Keeps the totals in step with counts in the transaction that changes them.
+-- Writers lock the counter row before touching its counts, so taking the
+-- counter lock here does not invert the lock order.
+CREATE FUNCTION counts_update_counter_totals() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE counters SET lifetime_total = lifetime_total + NEW.value, period_count = period_count + 1
+        WHERE id = NEW.counter_id;
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF NEW.value <> OLD.value THEN
+            UPDATE counters SET lifetime_total = lifetime_total + NEW.value - OLD.value
+            WHERE id = NEW.counter_id;
+        END IF;
+    ELSE
+        UPDATE counters SET lifetime_total = lifetime_total - OLD.value, period_count = period_count - 1
+        WHERE id = OLD.counter_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER counts_counter_totals
+AFTER INSERT OR DELETE OR UPDATE OF value ON counts
+FOR EACH ROW EXECUTE FUNCTION counts_update_counter_totals();
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS window_counts;
+DROP TABLE IF EXISTS counter_windows;
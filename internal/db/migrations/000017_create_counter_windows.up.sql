@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS counter_windows (
+    id SERIAL PRIMARY KEY,
+    counter_id INTEGER NOT NULL REFERENCES counters(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    frequency TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    UNIQUE (counter_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS window_counts (
+    id SERIAL PRIMARY KEY,
+    window_id INTEGER NOT NULL REFERENCES counter_windows(id) ON DELETE CASCADE,
+    value BIGINT NOT NULL DEFAULT 0,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    expiry TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    UNIQUE (window_id, expiry)
+);
@@ -0,0 +1,12 @@
+-- Announces every new event log entry on the counter_events channel once its
+-- transaction commits, so all server instances can push it to their streams.
+CREATE FUNCTION events_notify() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('counter_events', json_build_object('id', NEW.id, 'counter_id', NEW.counter_id)::TEXT);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER events_notify
+AFTER INSERT ON events
+FOR EACH ROW EXECUTE FUNCTION events_notify();
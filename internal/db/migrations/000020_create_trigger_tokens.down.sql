@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS trigger_token_uses;
+DROP TABLE IF EXISTS trigger_tokens;
@@ -0,0 +1,30 @@
+-- Only a SHA-256 hash of each token is stored; the token itself is shown once
+-- when it is created or rotated.
+CREATE TABLE IF NOT EXISTS trigger_tokens (
+    id SERIAL PRIMARY KEY,
+    counter_id INTEGER NOT NULL REFERENCES counters(id) ON DELETE CASCADE,
+    name TEXT NOT NULL DEFAULT '',
+    token_hash TEXT NOT NULL UNIQUE,
+    action TEXT NOT NULL DEFAULT 'increment' CHECK (action IN ('increment', 'decrement', 'set')),
+    amount BIGINT NOT NULL DEFAULT 1,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    rotated_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_trigger_tokens_counter_id ON trigger_tokens(counter_id);
+
+CREATE TABLE IF NOT EXISTS trigger_token_uses (
+    id BIGSERIAL PRIMARY KEY,
+    token_id INTEGER NOT NULL REFERENCES trigger_tokens(id) ON DELETE CASCADE,
+    used_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    method TEXT NOT NULL DEFAULT '',
+    remote_addr TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    succeeded BOOLEAN NOT NULL,
+    value BIGINT,
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_trigger_token_uses_token_id ON trigger_token_uses(token_id, used_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS alert_notifications;
+DROP TABLE IF EXISTS alerts;
+DROP TABLE IF EXISTS alert_rules;
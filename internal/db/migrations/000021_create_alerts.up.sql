@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id SERIAL PRIMARY KEY,
+    counter_id INTEGER NOT NULL REFERENCES counters(id) ON DELETE CASCADE,
+    name TEXT NOT NULL DEFAULT '',
+    kind TEXT NOT NULL CHECK (kind IN ('threshold', 'below_goal', 'inactivity')),
+    operator TEXT NOT NULL DEFAULT '',
+    threshold BIGINT,
+    duration TEXT NOT NULL DEFAULT '',
+    channels JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_rules_counter_id ON alert_rules(counter_id);
+
+CREATE TABLE IF NOT EXISTS alerts (
+    id BIGSERIAL PRIMARY KEY,
+    rule_id INTEGER NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    counter_id INTEGER NOT NULL REFERENCES counters(id) ON DELETE CASCADE,
+    state TEXT NOT NULL DEFAULT 'firing' CHECK (state IN ('firing', 'resolved')),
+    value BIGINT NOT NULL,
+    message TEXT NOT NULL,
+    fired_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+-- A rule has at most one firing alert, so a breach that lasts is reported once
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_firing ON alerts(rule_id) WHERE state = 'firing';
+CREATE INDEX IF NOT EXISTS idx_alerts_counter_id ON alerts(counter_id, fired_at);
+
+-- Notifications are queued with the state change and sent by the alert scheduler
+CREATE TABLE IF NOT EXISTS alert_notifications (
+    id BIGSERIAL PRIMARY KEY,
+    alert_id BIGINT NOT NULL REFERENCES alerts(id) ON DELETE CASCADE,
+    channel JSONB NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    sent_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_notifications_pending
+    ON alert_notifications (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,2 @@
+-- The backfilled anchors are kept, since the counters' schedules now depend on them.
+SELECT 1;
@@ -0,0 +1,7 @@
+-- RRULE schedules without a DTSTART are generated from the counter's anchor.
+-- Counters that lost theirs are anchored to the day they were created.
+UPDATE counters
+SET anchor = (created_at AT TIME ZONE timezone)::date
+WHERE schedule_type = 'rrule'
+  AND anchor IS NULL
+  AND upper(ltrim(schedule)) NOT LIKE 'DTSTART%';
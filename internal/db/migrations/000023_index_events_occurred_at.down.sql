@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_events_counter_id_occurred_at;
@@ -0,0 +1,2 @@
+-- The event log's from/to filter applies to occurred_at
+CREATE INDEX IF NOT EXISTS idx_events_counter_id_occurred_at ON events(counter_id, occurred_at);
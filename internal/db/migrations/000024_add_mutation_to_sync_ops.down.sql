@@ -0,0 +1,3 @@
+ALTER TABLE sync_ops DROP COLUMN value;
+ALTER TABLE sync_ops DROP COLUMN delta;
+ALTER TABLE sync_ops DROP COLUMN kind;
@@ -0,0 +1,5 @@
+-- The mutation of each sync op, so a reused op_id with a different operation is detected.
+-- Ops recorded before have NULL here and are not compared.
+ALTER TABLE sync_ops ADD COLUMN kind TEXT;
+ALTER TABLE sync_ops ADD COLUMN delta BIGINT;
+ALTER TABLE sync_ops ADD COLUMN value BIGINT;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_events_xact;
+ALTER TABLE events DROP COLUMN xact_horizon;
+ALTER TABLE events DROP COLUMN xact;
@@ -0,0 +1,8 @@
+-- The transaction that recorded each event and the oldest transaction still
+-- running at the time. IDs are taken before commit, so an event of a running
+-- transaction can commit after events with higher IDs; a stream resuming after
+-- an event replays the events of the transactions that were running then.
+-- Events recorded before share the migration's transaction and are not replayed.
+ALTER TABLE events ADD COLUMN xact xid8 NOT NULL DEFAULT pg_current_xact_id();
+ALTER TABLE events ADD COLUMN xact_horizon xid8 NOT NULL DEFAULT pg_snapshot_xmin(pg_current_snapshot());
+CREATE INDEX IF NOT EXISTS idx_events_xact ON events(xact);
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/teambition/rrule-go"
+)
+
+// Scheduler computes where a counter's periods end.
+type Scheduler interface {
+	// Next returns the first period boundary strictly after now.
+	Next(now time.Time) (time.Time, error)
+}
+
+// Schedule types understood by NewScheduler.
+const (
+	ScheduleFrequency = "frequency"
+	ScheduleCron      = "cron"
+	ScheduleRRule     = "rrule"
+)
+
+// ErrNoPeriodStart is returned by PeriodStart when a schedule has no boundary
+// at or before the given time, such as an RRULE before its first occurrence.
+var ErrNoPeriodStart = errors.New("schedule has no period boundary before the given time")
+
+// maxPeriodSearch bounds how far back PeriodStart looks for a boundary.
+const maxPeriodSearch = 100 * 366 * 24 * time.Hour
+
+// SchedulerFactory builds a Scheduler from a schedule expression evaluated in loc.
+type SchedulerFactory func(expr string, loc *time.Location, opts PeriodOptions) (Scheduler, error)
+
+var schedulerFactories = map[string]SchedulerFactory{
+	ScheduleFrequency: newFrequencyScheduler,
+	ScheduleCron:      newCronScheduler,
+	ScheduleRRule:     newRRuleScheduler,
+}
+
+// RegisterScheduler makes a schedule type available to NewScheduler. It is not
+// safe to call concurrently with NewScheduler and is meant for init functions.
+func RegisterScheduler(kind string, factory SchedulerFactory) {
+	schedulerFactories[kind] = factory
+}
+
+// NewScheduler returns the Scheduler for a schedule type and expression in the
+// given timezone. For ScheduleFrequency the expression is a frequency string
+// such as "1d"; for ScheduleCron a standard 5-field cron expression or a
+// descriptor such as "@daily"; for ScheduleRRule an iCalendar RRULE, optionally
+// preceded by a DTSTART line.
+func NewScheduler(kind string, expr string, timezone string, opts PeriodOptions) (Scheduler, error) {
+	factory, ok := schedulerFactories[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown schedule type: %s", kind)
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %s", timezone)
+	}
+	return factory(expr, loc, opts)
+}
+
+// frequencyScheduler adapts NextExpiryTimeWithOptions to the Scheduler
+// interface. The frequency is parsed and the location loaded once, as period
+// walks call Next many times.
+type frequencyScheduler struct {
+	n    int
+	unit string
+	loc  *time.Location
+	opts PeriodOptions
+}
+
+func newFrequencyScheduler(expr string, loc *time.Location, opts PeriodOptions) (Scheduler, error) {
+	n, unit, err := ParseFrequency(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &frequencyScheduler{n: n, unit: unit, loc: loc, opts: opts}, nil
+}
+
+func (s *frequencyScheduler) Next(now time.Time) (time.Time, error) {
+	return nextExpiryIn(s.n, s.unit, now, s.loc, s.opts)
+}
+
+func (s *frequencyScheduler) countPeriods(from, to time.Time) (int, error) {
+	switch s.unit {
+	case "m":
+		return countClockPeriods(from, to, s.loc, s.n), nil
+	case "h":
+		return countClockPeriods(from, to, s.loc, s.n*60), nil
+	}
+	return periodIndex(s.n, s.unit, to, s.loc, s.opts) - periodIndex(s.n, s.unit, from, s.loc, s.opts), nil
+}
+
+// cronScheduler ends a period at every time matched by a cron expression.
+type cronScheduler struct {
+	schedule cron.Schedule
+	loc      *time.Location
+}
+
+func newCronScheduler(expr string, loc *time.Location, _ PeriodOptions) (Scheduler, error) {
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression: %v", err)
+	}
+	return &cronScheduler{schedule: schedule, loc: loc}, nil
+}
+
+func (s *cronScheduler) Next(now time.Time) (time.Time, error) {
+	next := s.schedule.Next(now.In(s.loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("cron schedule has no further occurrences")
+	}
+	return next.UTC(), nil
+}
+
+// rruleScheduler ends a period at every occurrence of an iCalendar recurrence rule.
+type rruleScheduler struct {
+	set *rrule.Set
+}
+
+// newRRuleScheduler parses an RRULE. Occurrences are generated from the rule's
+// DTSTART, or from midnight of the anchor date when the rule does not specify
+// it. A rule with neither is rejected, since walking its occurrences from the
+// epoch would take millions of steps for minutely and hourly rules.
+func newRRuleScheduler(expr string, loc *time.Location, opts PeriodOptions) (Scheduler, error) {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(expr), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(strings.ToUpper(line), "FREQ=") {
+			line = "RRULE:" + line
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("empty RRULE")
+	}
+	set, err := rrule.StrSliceToRRuleSetInLoc(lines, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RRULE: %v", err)
+	}
+	if set.GetRRule() == nil {
+		return nil, errors.New("invalid RRULE: missing RRULE line")
+	}
+	if set.GetDTStart().IsZero() {
+		if opts.Anchor.IsZero() {
+			return nil, errors.New("RRULE without DTSTART needs an anchor date")
+		}
+		set.DTStart(wallClock(opts.Anchor.Year(), opts.Anchor.Month(), opts.Anchor.Day(), 0, 0, loc))
+	}
+	return &rruleScheduler{set: set}, nil
+}
+
+func (s *rruleScheduler) Next(now time.Time) (time.Time, error) {
+	next := s.set.After(now, false)
+	if next.IsZero() {
+		return time.Time{}, errors.New("RRULE has no further occurrences")
+	}
+	return next.UTC(), nil
+}
+
+func (s *rruleScheduler) countPeriods(from, to time.Time) (int, error) {
+	n := 0
+	for _, t := range s.set.Between(from, to, true) {
+		if t.After(from) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// HasDTStart reports whether an RRULE expression carries its own DTSTART line.
+func HasDTStart(expr string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(expr)), "DTSTART")
+}
+
+// periodCounter is implemented by schedulers that count their boundaries
+// without stepping through them one Next at a time.
+type periodCounter interface {
+	countPeriods(from, to time.Time) (int, error)
+}
+
+// CountPeriods returns the number of boundaries of s after from and at or
+// before to. Frequency and RRULE schedules count them in one go; others are
+// stepped through.
+func CountPeriods(s Scheduler, from, to time.Time) (int, error) {
+	if !to.After(from) {
+		return 0, nil
+	}
+	if c, ok := s.(periodCounter); ok {
+		return c.countPeriods(from, to)
+	}
+	n := 0
+	for t := from; ; n++ {
+		next, err := s.Next(t)
+		if err != nil {
+			return 0, err
+		}
+		if next.After(to) {
+			return n, nil
+		}
+		t = next
+	}
+}
+
+// PeriodStart returns the start of the period containing at: the last boundary
+// of s at or before at. Schedulers only look forward, so it steps back in
+// doubling intervals until a boundary lies in between, then walks forward.
+func PeriodStart(s Scheduler, at time.Time) (time.Time, error) {
+	step := time.Minute
+	start, err := s.Next(at.Add(-step))
+	for err == nil && start.After(at) {
+		if step > maxPeriodSearch {
+			return time.Time{}, ErrNoPeriodStart
+		}
+		step *= 2
+		start, err = s.Next(at.Add(-step))
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	for {
+		next, err := s.Next(start)
+		if err != nil || next.After(at) {
+			return start, nil
+		}
+		start = next
+	}
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedulerNext(t *testing.T) {
+	friday := time.Date(2025, 11, 14, 14, 30, 0, 0, time.UTC)
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		kind     string
+		expr     string
+		timezone string
+		opts     PeriodOptions
+		now      time.Time
+		want     time.Time
+	}{
+		{"frequency 1d", ScheduleFrequency, "1d", "UTC", DefaultPeriodOptions, friday, time.Date(2025, 11, 15, 0, 0, 0, 0, time.UTC)},
+		{"cron weekdays 09:00 on Friday afternoon", ScheduleCron, "0 9 * * 1-5", "UTC", DefaultPeriodOptions, friday, time.Date(2025, 11, 17, 9, 0, 0, 0, time.UTC)},
+		{"cron weekdays 09:00 in New York", ScheduleCron, "0 9 * * 1-5", "America/New_York", DefaultPeriodOptions, friday, time.Date(2025, 11, 17, 9, 0, 0, 0, newYork)},
+		{"cron descriptor", ScheduleCron, "@monthly", "UTC", DefaultPeriodOptions, friday, time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"rrule first Monday of the month", ScheduleRRule, "RRULE:FREQ=MONTHLY;BYDAY=1MO", "UTC",
+			PeriodOptions{WeekStart: time.Monday, Anchor: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}, friday, time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"rrule without prefix", ScheduleRRule, "FREQ=WEEKLY;BYDAY=WE;BYHOUR=18", "UTC",
+			PeriodOptions{WeekStart: time.Monday, Anchor: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}, friday, time.Date(2025, 11, 19, 18, 0, 0, 0, time.UTC)},
+		{"rrule with DTSTART", ScheduleRRule, "DTSTART;TZID=America/New_York:20250106T090000\nRRULE:FREQ=WEEKLY;INTERVAL=2", "UTC",
+			DefaultPeriodOptions, friday, time.Date(2025, 11, 24, 9, 0, 0, 0, newYork)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewScheduler(tt.kind, tt.expr, tt.timezone, tt.opts)
+			if err != nil {
+				t.Fatalf("NewScheduler() error = %v", err)
+			}
+			got, err := s.Next(tt.now)
+			if err != nil {
+				t.Fatalf("Next() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want.UTC())
+			}
+		})
+	}
+}
+
+func TestNewSchedulerInvalid(t *testing.T) {
+	tests := []struct {
+		kind string
+		expr string
+	}{
+		{ScheduleFrequency, "1x"},
+		{ScheduleCron, "0 25 * * *"},
+		{ScheduleRRule, "FREQ=SOMETIMES"},
+		{ScheduleRRule, ""},
+		{ScheduleRRule, "FREQ=HOURLY"},
+		{"lunar", "1d"},
+	}
+	for _, tt := range tests {
+		if _, err := NewScheduler(tt.kind, tt.expr, "UTC", DefaultPeriodOptions); err == nil {
+			t.Errorf("NewScheduler(%q, %q) expected error", tt.kind, tt.expr)
+		}
+	}
+}
+
+func TestRRuleSchedulerExhausted(t *testing.T) {
+	s, err := NewScheduler(ScheduleRRule, "DTSTART:20250101T000000Z\nRRULE:FREQ=DAILY;COUNT=3", "UTC", DefaultPeriodOptions)
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	if _, err := s.Next(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("expected error once the rule has no further occurrences")
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	friday := time.Date(2025, 11, 14, 14, 30, 0, 0, time.UTC)
+	budapest, err := time.LoadLocation("Europe/Budapest")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		kind     string
+		expr     string
+		timezone string
+		at       time.Time
+		want     time.Time
+	}{
+		{"frequency 1d", ScheduleFrequency, "1d", "UTC", friday, time.Date(2025, 11, 14, 0, 0, 0, 0, time.UTC)},
+		{"frequency 1d in Budapest", ScheduleFrequency, "1d", "Europe/Budapest", friday, time.Date(2025, 11, 14, 0, 0, 0, 0, budapest)},
+		{"frequency 1y", ScheduleFrequency, "1y", "UTC", friday, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"frequency 15m", ScheduleFrequency, "15m", "UTC", friday.Add(7 * time.Minute), friday},
+		{"on a boundary", ScheduleFrequency, "1d", "UTC", time.Date(2025, 11, 14, 0, 0, 0, 0, time.UTC), time.Date(2025, 11, 14, 0, 0, 0, 0, time.UTC)},
+		{"cron weekdays 09:00 on Saturday", ScheduleCron, "0 9 * * 1-5", "UTC", friday.Add(24 * time.Hour), time.Date(2025, 11, 14, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewScheduler(tt.kind, tt.expr, tt.timezone, DefaultPeriodOptions)
+			if err != nil {
+				t.Fatalf("NewScheduler() error = %v", err)
+			}
+			got, err := PeriodStart(s, tt.at)
+			if err != nil {
+				t.Fatalf("PeriodStart() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("PeriodStart() = %v, want %v", got, tt.want.UTC())
+			}
+		})
+	}
+
+	s, err := NewScheduler(ScheduleRRule, "DTSTART:20250101T000000Z\nRRULE:FREQ=DAILY", "UTC", DefaultPeriodOptions)
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	if _, err := PeriodStart(s, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)); !errors.Is(err, ErrNoPeriodStart) {
+		t.Errorf("expected ErrNoPeriodStart before the first occurrence, got %v", err)
+	}
+}
+
+func TestCountPeriods(t *testing.T) {
+	from := time.Date(2025, 2, 20, 13, 37, 12, 500, time.UTC)
+	anchored := PeriodOptions{WeekStart: time.Sunday, Anchor: time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC),
+		Holidays: []time.Time{time.Date(2025, 3, 14, 0, 0, 0, 0, time.UTC), time.Date(2025, 4, 21, 0, 0, 0, 0, time.UTC)}}
+
+	tests := []struct {
+		name     string
+		kind     string
+		expr     string
+		timezone string
+		opts     PeriodOptions
+	}{
+		{"frequency 7m across DST", ScheduleFrequency, "7m", "Europe/Budapest", DefaultPeriodOptions},
+		{"frequency 90m across DST", ScheduleFrequency, "90m", "America/New_York", DefaultPeriodOptions},
+		{"frequency 1h with a quarter-hour offset", ScheduleFrequency, "1h", "Asia/Kathmandu", DefaultPeriodOptions},
+		{"frequency 5h on Lord Howe Island", ScheduleFrequency, "5h", "Australia/Lord_Howe", DefaultPeriodOptions},
+		{"frequency 3d", ScheduleFrequency, "3d", "Europe/Budapest", DefaultPeriodOptions},
+		{"frequency 3d anchored", ScheduleFrequency, "3d", "UTC", anchored},
+		{"frequency 1b", ScheduleFrequency, "1b", "UTC", anchored},
+		{"frequency 2b anchored", ScheduleFrequency, "2b", "Europe/Budapest", anchored},
+		{"frequency 2w anchored", ScheduleFrequency, "2w", "UTC", anchored},
+		{"frequency 1w", ScheduleFrequency, "1w", "America/New_York", DefaultPeriodOptions},
+		{"frequency 1M", ScheduleFrequency, "1M", "UTC", DefaultPeriodOptions},
+		{"frequency 1q anchored", ScheduleFrequency, "1q", "UTC", anchored},
+		{"cron", ScheduleCron, "*/20 9-17 * * 1-5", "Europe/Budapest", DefaultPeriodOptions},
+		{"rrule", ScheduleRRule, "DTSTART:20250101T090000Z\nRRULE:FREQ=DAILY;BYHOUR=9,15", "UTC", DefaultPeriodOptions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewScheduler(tt.kind, tt.expr, tt.timezone, tt.opts)
+			if err != nil {
+				t.Fatalf("NewScheduler() error = %v", err)
+			}
+			// Compare with stepping through every boundary, from and to both
+			// on and between boundaries
+			want := 0
+			next, err := s.Next(from)
+			if err != nil {
+				t.Fatalf("Next() error = %v", err)
+			}
+			for _, to := range []time.Time{from, from.Add(97 * time.Minute), from.AddDate(0, 0, 40), from.AddDate(0, 9, 3)} {
+				for !next.After(to) {
+					want++
+					if next, err = s.Next(next); err != nil {
+						t.Fatalf("Next() error = %v", err)
+					}
+				}
+				got, err := CountPeriods(s, from, to)
+				if err != nil {
+					t.Fatalf("CountPeriods() error = %v", err)
+				}
+				if got != want {
+					t.Errorf("CountPeriods(%v) = %d, want %d", to, got, want)
+				}
+				start, err := PeriodStart(s, to)
+				if errors.Is(err, ErrNoPeriodStart) {
+					continue
+				}
+				if err != nil {
+					t.Fatalf("PeriodStart() error = %v", err)
+				}
+				if got, _ := CountPeriods(s, start, to); got != 0 {
+					t.Errorf("CountPeriods(%v, %v) = %d, want 0", start, to, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/iben12/counter-app/internal/models"
+)
+
+func (s *Server) aggregate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	bucket := q.Get("bucket")
+	if bucket == "" {
+		http.Error(w, "bucket required", http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(q, "from")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q, "to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var fns []string
+	if v := q.Get("fn"); v != "" {
+		fns = strings.Split(v, ",")
+	}
+	agg, err := models.Aggregate(r.Context(), s.db, id, models.AggregateQuery{
+		Bucket: bucket,
+		From:   from,
+		To:     to,
+		Fns:    fns,
+		Fill:   q.Get("fill") == "true",
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, agg)
+}
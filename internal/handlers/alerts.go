@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/iben12/counter-app/internal/models"
+)
+
+func (s *Server) listAlertRules(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	rules, err := models.GetAlertRules(r.Context(), s.db, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+type createAlertRuleReq struct {
+	Name      string                `json:"name"`
+	Kind      string                `json:"kind"`
+	Operator  string                `json:"operator,omitempty"`
+	Threshold *int64                `json:"threshold,omitempty"`
+	Duration  string                `json:"duration,omitempty"`
+	Channels  []models.AlertChannel `json:"channels"`
+}
+
+func (s *Server) createAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req createAlertRuleReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Kind == "" {
+		http.Error(w, "kind required", http.StatusBadRequest)
+		return
+	}
+	rule, err := models.CreateAlertRule(r.Context(), s.db, id, models.AlertRuleSettings{
+		Name:      req.Name,
+		Kind:      req.Kind,
+		Operator:  req.Operator,
+		Threshold: req.Threshold,
+		Duration:  req.Duration,
+		Channels:  req.Channels,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+// ruleIDs parses the counter and rule IDs of an alert rule's path.
+func ruleIDs(r *http.Request) (counterID, ruleID int64, err error) {
+	if counterID, err = pathID(r, "id"); err != nil {
+		return 0, 0, err
+	}
+	ruleID, err = pathID(r, "rule_id")
+	return counterID, ruleID, err
+}
+
+func (s *Server) getAlertRule(w http.ResponseWriter, r *http.Request) {
+	counterID, ruleID, err := ruleIDs(r)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	rule, err := models.GetAlertRule(r.Context(), s.db, counterID, ruleID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (s *Server) deleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	counterID, ruleID, err := ruleIDs(r)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := models.DeleteAlertRule(r.Context(), s.db, counterID, ruleID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listAlerts lists a counter's alerts, newest first. The state parameter
+// selects firing or resolved ones.
+func (s *Server) listAlerts(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	page, err := parsePageParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	alerts, next, err := models.GetAlerts(r.Context(), s.db, id, models.AlertQuery{
+		State:  q.Get("state"),
+		Limit:  page.Limit,
+		Cursor: page.Cursor,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, alerts)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/iben12/counter-app/internal/models"
+)
+
+func (s *Server) listCalendars(w http.ResponseWriter, r *http.Request) {
+	cs, err := models.GetAllCalendars(r.Context(), s.db)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cs)
+}
+
+type createCalendarReq struct {
+	Name     string           `json:"name"`
+	Holidays []models.Holiday `json:"holidays,omitempty"`
+}
+
+func (s *Server) createCalendar(w http.ResponseWriter, r *http.Request) {
+	var req createCalendarReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+	c, err := models.CreateCalendar(r.Context(), s.db, req.Name, req.Holidays)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func (s *Server) getCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	c, err := models.GetCalendarByID(r.Context(), s.db, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+type updateCalendarReq struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) updateCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req updateCalendarReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	c, err := models.RenameCalendar(r.Context(), s.db, id, req.Name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (s *Server) deleteCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := models.DeleteCalendar(r.Context(), s.db, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addHolidays adds holidays from a JSON array of {"day","name"} objects, or
+// from an iCalendar file when the body is sent as text/calendar.
+func (s *Server) addHolidays(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var holidays []models.Holiday
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/calendar" {
+		holidays, err = models.ParseICalHolidays(r.Body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&holidays); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	out, err := models.AddHolidays(r.Context(), s.db, id, holidays)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) removeHoliday(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	out, err := models.RemoveHoliday(r.Context(), s.db, id, mux.Vars(r)["day"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
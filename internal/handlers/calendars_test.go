@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iben12/counter-app/internal/models"
+)
+
+// TestCalendarLifecycle tests creating a calendar, importing holidays from JSON and
+// iCal, attaching it to a business-day counter and removing a holiday.
+func TestCalendarLifecycle(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	router := NewRouter(pool)
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("POST", "/calendars", "application/json", `{"name":"test-holidays","holidays":[{"day":"2025-12-25","name":"Christmas"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var cal models.Calendar
+	if err := json.Unmarshal(rec.Body.Bytes(), &cal); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(cal.Holidays) != 1 {
+		t.Fatalf("expected 1 holiday, got %v", cal.Holidays)
+	}
+
+	ics := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20260101\r\nSUMMARY:New Year\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	rec = do("POST", fmt.Sprintf("/calendars/%d/holidays", cal.ID), "text/calendar; charset=utf-8", ics)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 importing iCal, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var holidays []models.Holiday
+	_ = json.Unmarshal(rec.Body.Bytes(), &holidays)
+	if len(holidays) != 2 || holidays[1].Day != "2026-01-01" || holidays[1].Name != "New Year" {
+		t.Errorf("expected the imported New Year holiday, got %v", holidays)
+	}
+
+	rec = do("POST", "/counters", "application/json", fmt.Sprintf(`{"name":"test-business-days","frequency":"1b","calendar_id":%d}`, cal.ID))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201 creating counter, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var counter models.Counter
+	_ = json.Unmarshal(rec.Body.Bytes(), &counter)
+	if counter.CalendarID == nil || *counter.CalendarID != cal.ID {
+		t.Errorf("expected calendar_id %d, got %v", cal.ID, counter.CalendarID)
+	}
+
+	rec = do("DELETE", fmt.Sprintf("/calendars/%d/holidays/2025-12-25", cal.ID), "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 removing holiday, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = do("DELETE", fmt.Sprintf("/calendars/%d/holidays/2025-12-25", cal.ID), "", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 removing a missing holiday, got %d", rec.Code)
+	}
+
+	rec = do("POST", "/calendars", "application/json", `{"name":"test-holidays"}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for a duplicate name, got %d", rec.Code)
+	}
+
+	rec = do("DELETE", fmt.Sprintf("/calendars/%d", cal.ID), "", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rec.Code)
+	}
+	rec = do("GET", fmt.Sprintf("/counters/%d", counter.ID), "", "")
+	_ = json.Unmarshal(rec.Body.Bytes(), &counter)
+	if counter.CalendarID != nil {
+		t.Errorf("expected calendar_id to be cleared, got %d", *counter.CalendarID)
+	}
+}
+
+// TestCreateCounterUnknownCalendar tests that referencing a missing calendar is rejected.
+func TestCreateCounterUnknownCalendar(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	router := NewRouter(pool)
+	body := []byte(`{"name":"test-missing-calendar","frequency":"1b","calendar_id":999999}`)
+	req, _ := http.NewRequest("POST", "/counters", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
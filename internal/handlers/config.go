@@ -0,0 +1,27 @@
+package handlers
+
+import "time"
+
+// Config holds the tunable limits of the HTTP API.
+type Config struct {
+	// MaxBackdate is how far in the past the "at" timestamp of a count change may lie.
+	MaxBackdate time.Duration
+	// IdempotencyTTL is how long the response to a request with an
+	// Idempotency-Key is kept for replay.
+	IdempotencyTTL time.Duration
+	// UndoWindow is how old an operation may be and still be undone or redone.
+	UndoWindow time.Duration
+	// StreamKeepAlive is how often an idle event stream sends a comment line,
+	// so proxies and clients do not time it out.
+	StreamKeepAlive time.Duration
+}
+
+// DefaultConfig returns the limits used by NewRouter.
+func DefaultConfig() Config {
+	return Config{
+		MaxBackdate:     7 * 24 * time.Hour,
+		IdempotencyTTL:  24 * time.Hour,
+		UndoWindow:      10 * time.Minute,
+		StreamKeepAlive: 15 * time.Second,
+	}
+}
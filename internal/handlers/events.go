@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/iben12/counter-app/internal/models"
+)
+
+// pageParams holds the query parameters of a paginated listing: limit, cursor,
+// the from and to times and the sort order, which the models validate.
+type pageParams struct {
+	Limit  int
+	Cursor *models.Cursor
+	From   time.Time
+	To     time.Time
+	Order  string
+}
+
+// parsePageParams reads the limit, cursor, from, to and order query parameters.
+func parsePageParams(q url.Values) (pageParams, error) {
+	p := pageParams{Order: q.Get("order")}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("invalid limit: %s", v)
+		}
+		p.Limit = n
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := models.ParseCursor(v)
+		if err != nil {
+			return p, err
+		}
+		p.Cursor = c
+	}
+	var err error
+	if p.From, err = parseTimeParam(q, "from"); err != nil {
+		return p, err
+	}
+	if p.To, err = parseTimeParam(q, "to"); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// parseTimeParam reads an optional RFC 3339 timestamp query parameter.
+func parseTimeParam(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s (expected RFC 3339)", name, v)
+	}
+	return t, nil
+}
+
+// setNextLink advertises the next page in a Link header, keeping the other
+// query parameters of the request.
+func setNextLink(w http.ResponseWriter, r *http.Request, next *models.Cursor) {
+	if next == nil {
+		return
+	}
+	q := r.URL.Query()
+	q.Set("cursor", next.String())
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.String()))
+}
+
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	page, err := parsePageParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, next, err := models.GetEvents(r.Context(), s.db, id, models.EventQuery{
+		From:   page.From,
+		To:     page.To,
+		Order:  page.Order,
+		Limit:  page.Limit,
+		Cursor: page.Cursor,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, events)
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/iben12/counter-app/internal/models"
@@ -11,28 +16,132 @@ import (
 )
 
 type Server struct {
-	db *pgxpool.Pool
+	db     *pgxpool.Pool
+	cfg    Config
+	broker *models.Broker
 }
 
+// NewRouter returns the API router with DefaultConfig.
 func NewRouter(pool *pgxpool.Pool) http.Handler {
-	s := &Server{db: pool}
+	return NewRouterWithConfig(pool, DefaultConfig())
+}
+
+// NewRouterWithConfig returns the API router with the given limits.
+func NewRouterWithConfig(pool *pgxpool.Pool, cfg Config) http.Handler {
+	s := &Server{db: pool, cfg: cfg, broker: models.NewBroker(pool)}
 	r := mux.NewRouter()
+	r.Use(s.idempotency)
 	r.HandleFunc("/health", s.health).Methods("GET")
 
 	r.HandleFunc("/counters", s.listCounters).Methods("GET")
 	r.HandleFunc("/counters", s.createCounter).Methods("POST")
 	r.HandleFunc("/counters/{id}", s.getCounter).Methods("GET")
+	r.HandleFunc("/counters/{id}", s.updateCounter).Methods("PATCH")
+	r.HandleFunc("/counters/{id}", s.deleteCounter).Methods("DELETE")
 	r.HandleFunc("/counters/{id}/frequency", s.updateCounterFrequency).Methods("POST")
+	r.HandleFunc("/counters/{id}/archive", s.archiveCounter).Methods("POST")
+	r.HandleFunc("/counters/{id}/unarchive", s.unarchiveCounter).Methods("POST")
 
 	// Count endpoints
 	r.HandleFunc("/counters/{id}/count", s.getCurrentCount).Methods("GET")
 	r.HandleFunc("/counters/{id}/count/increment", s.incrementCount).Methods("POST")
 	r.HandleFunc("/counters/{id}/count/decrement", s.decrementCount).Methods("POST")
+	r.HandleFunc("/counters/{id}/count", s.setCount).Methods("PUT")
+	r.HandleFunc("/counters/{id}/count/reset", s.resetCount).Methods("POST")
+	r.HandleFunc("/counters/{id}/count/undo", s.undoCount).Methods("POST")
+	r.HandleFunc("/counters/{id}/count/redo", s.redoCount).Methods("POST")
 	r.HandleFunc("/counters/{id}/counts", s.getCountHistory).Methods("GET")
+	r.HandleFunc("/counters/{id}/events", s.listEvents).Methods("GET")
+	r.HandleFunc("/counters/{id}/stats", s.getStats).Methods("GET")
+	r.HandleFunc("/counters/{id}/aggregate", s.aggregate).Methods("GET")
+
+	// Additional rollup windows of a counter
+	r.HandleFunc("/counters/{id}/windows", s.listWindows).Methods("GET")
+	r.HandleFunc("/counters/{id}/windows", s.createWindow).Methods("POST")
+	r.HandleFunc("/counters/{id}/windows/{name}", s.deleteWindow).Methods("DELETE")
+	r.HandleFunc("/counters/{id}/windows/{name}/count", s.getWindowCount).Methods("GET")
+	r.HandleFunc("/counters/{id}/windows/{name}/counts", s.getWindowCounts).Methods("GET")
+
+	// Trigger tokens change a counter from a bare URL
+	r.HandleFunc("/counters/{id}/tokens", s.listTokens).Methods("GET")
+	r.HandleFunc("/counters/{id}/tokens", s.createToken).Methods("POST")
+	r.HandleFunc("/counters/{id}/tokens/{token_id}", s.revokeToken).Methods("DELETE")
+	r.HandleFunc("/counters/{id}/tokens/{token_id}/rotate", s.rotateToken).Methods("POST")
+	r.HandleFunc("/counters/{id}/tokens/{token_id}/uses", s.listTokenUses).Methods("GET")
+	r.HandleFunc("/t/{token}", s.trigger).Methods("GET", "POST")
+
+	// Alert rules of a counter and the alerts they raised
+	r.HandleFunc("/counters/{id}/alert-rules", s.listAlertRules).Methods("GET")
+	r.HandleFunc("/counters/{id}/alert-rules", s.createAlertRule).Methods("POST")
+	r.HandleFunc("/counters/{id}/alert-rules/{rule_id}", s.getAlertRule).Methods("GET")
+	r.HandleFunc("/counters/{id}/alert-rules/{rule_id}", s.deleteAlertRule).Methods("DELETE")
+	r.HandleFunc("/counters/{id}/alerts", s.listAlerts).Methods("GET")
+
+	// Server-Sent Events streams of counter changes
+	r.HandleFunc("/stream", s.streamAll).Methods("GET")
+	r.HandleFunc("/counters/{id}/stream", s.streamCounter).Methods("GET")
+
+	// Live counters and mutations over a single WebSocket connection
+	r.HandleFunc("/ws", s.serveWebSocket).Methods("GET")
+
+	// Offline clients replay batches of operations here
+	r.HandleFunc("/sync", s.sync).Methods("POST")
+
+	// Outgoing webhooks and their delivery log
+	r.HandleFunc("/webhooks", s.listWebhooks).Methods("GET")
+	r.HandleFunc("/webhooks", s.createWebhook).Methods("POST")
+	r.HandleFunc("/webhooks/{id}", s.getWebhook).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", s.updateWebhook).Methods("PATCH")
+	r.HandleFunc("/webhooks/{id}", s.deleteWebhook).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/deliveries", s.listWebhookDeliveries).Methods("GET")
+
+	// Holiday calendar endpoints
+	r.HandleFunc("/calendars", s.listCalendars).Methods("GET")
+	r.HandleFunc("/calendars", s.createCalendar).Methods("POST")
+	r.HandleFunc("/calendars/{id}", s.getCalendar).Methods("GET")
+	r.HandleFunc("/calendars/{id}", s.updateCalendar).Methods("PATCH")
+	r.HandleFunc("/calendars/{id}", s.deleteCalendar).Methods("DELETE")
+	r.HandleFunc("/calendars/{id}/holidays", s.addHolidays).Methods("POST")
+	r.HandleFunc("/calendars/{id}/holidays/{day}", s.removeHoliday).Methods("DELETE")
 
 	return r
 }
 
+// pathID parses the named mux path variable as a counter-style int64 ID.
+func pathID(r *http.Request, name string) (int64, error) {
+	return strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+}
+
+// writeJSON encodes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError maps model errors to HTTP status codes.
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
+// errorStatus returns the HTTP status code of a model error.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrInvalidInput):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrDuplicateName), errors.Is(err, models.ErrDuplicateCalendarName),
+		errors.Is(err, models.ErrDuplicateWindowName), errors.Is(err, models.ErrTokenRevoked),
+		errors.Is(err, models.ErrIdempotencyKeyReused), errors.Is(err, models.ErrIdempotencyKeyInProgress),
+		errors.Is(err, models.ErrUndoUnavailable), errors.Is(err, models.ErrOutOfRange),
+		errors.Is(err, models.ErrSyncOpReused):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -40,20 +149,43 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) listCounters(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	cs, err := models.GetAllCounters(ctx, s.db)
+	q := r.URL.Query()
+	page, err := parsePageParams(q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(cs)
+	cs, next, err := models.GetCounters(r.Context(), s.db, models.CounterQuery{
+		IncludeArchived: q.Get("include_archived") == "true",
+		From:            page.From,
+		To:              page.To,
+		Order:           page.Order,
+		Limit:           page.Limit,
+		Cursor:          page.Cursor,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, cs)
 }
 
+// createReq is the body of a create request. Min, Max and Goal tell null apart
+// from a missing field, so "min": null lifts the default lower bound of zero.
 type createReq struct {
-	Name      string `json:"name"`
-	Frequency string `json:"frequency,omitempty"`
-	Timezone  string `json:"timezone,omitempty"`
+	Name         string             `json:"name"`
+	Frequency    string             `json:"frequency,omitempty"`
+	Timezone     string             `json:"timezone,omitempty"`
+	WeekStart    string             `json:"week_start,omitempty"`
+	Anchor       string             `json:"anchor,omitempty"`
+	ScheduleType string             `json:"schedule_type,omitempty"`
+	Schedule     string             `json:"schedule,omitempty"`
+	CalendarID   *int64             `json:"calendar_id,omitempty"`
+	Min          models.NullableInt `json:"min"`
+	Max          models.NullableInt `json:"max"`
+	Goal         models.NullableInt `json:"goal"`
+	BoundMode    string             `json:"bound_mode,omitempty"`
 }
 
 func (s *Server) createCounter(w http.ResponseWriter, r *http.Request) {
@@ -66,9 +198,21 @@ func (s *Server) createCounter(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "name required", http.StatusBadRequest)
 		return
 	}
-	c, err := models.CreateCounter(r.Context(), s.db, req.Name, req.Frequency, req.Timezone)
+	c, err := models.CreateCounterWithSettings(r.Context(), s.db, req.Name, models.CounterSettings{
+		Frequency:    req.Frequency,
+		Timezone:     req.Timezone,
+		WeekStart:    req.WeekStart,
+		Anchor:       req.Anchor,
+		ScheduleType: req.ScheduleType,
+		Schedule:     req.Schedule,
+		CalendarID:   req.CalendarID,
+		Min:          req.Min,
+		Max:          req.Max,
+		Goal:         req.Goal,
+		BoundMode:    req.BoundMode,
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -116,15 +260,133 @@ func (s *Server) updateCounterFrequency(w http.ResponseWriter, r *http.Request)
 	}
 	c, err := models.UpdateCounterFrequency(r.Context(), s.db, id, req.Frequency)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(c)
 }
 
+type updateCounterReq struct {
+	Name         *string            `json:"name,omitempty"`
+	Frequency    *string            `json:"frequency,omitempty"`
+	Timezone     *string            `json:"timezone,omitempty"`
+	WeekStart    *string            `json:"week_start,omitempty"`
+	Anchor       *string            `json:"anchor,omitempty"`
+	ScheduleType *string            `json:"schedule_type,omitempty"`
+	Schedule     *string            `json:"schedule,omitempty"`
+	CalendarID   *int64             `json:"calendar_id,omitempty"`
+	Min          models.NullableInt `json:"min"`
+	Max          models.NullableInt `json:"max"`
+	Goal         models.NullableInt `json:"goal"`
+	BoundMode    *string            `json:"bound_mode,omitempty"`
+}
+
+func (s *Server) updateCounter(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req updateCounterReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	c, err := models.UpdateCounter(r.Context(), s.db, id, models.CounterUpdate{
+		Name:         req.Name,
+		Frequency:    req.Frequency,
+		Timezone:     req.Timezone,
+		WeekStart:    req.WeekStart,
+		Anchor:       req.Anchor,
+		ScheduleType: req.ScheduleType,
+		Schedule:     req.Schedule,
+		CalendarID:   req.CalendarID,
+		Min:          req.Min,
+		Max:          req.Max,
+		Goal:         req.Goal,
+		BoundMode:    req.BoundMode,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (s *Server) deleteCounter(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := models.DeleteCounter(r.Context(), s.db, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) archiveCounter(w http.ResponseWriter, r *http.Request) {
+	s.setArchived(w, r, true)
+}
+
+func (s *Server) unarchiveCounter(w http.ResponseWriter, r *http.Request) {
+	s.setArchived(w, r, false)
+}
+
+func (s *Server) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	c, err := models.SetCounterArchived(r.Context(), s.db, id, archived)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
 type incCountReq struct {
-	Delta int64 `json:"delta"`
+	Delta int64      `json:"delta"`
+	Note  string     `json:"note,omitempty"`
+	Actor string     `json:"actor,omitempty"`
+	At    *time.Time `json:"at,omitempty"`
+}
+
+// decodeIncCountReq reads an increment or decrement body. A missing body or
+// delta means a delta of 1.
+func decodeIncCountReq(r *http.Request) (incCountReq, error) {
+	var req incCountReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return req, err
+	}
+	if req.Delta == 0 {
+		req.Delta = 1
+	}
+	return req, nil
+}
+
+// mutation builds the model mutation of an increment or decrement request,
+// checking that a backdated change lies within the configured window.
+func (s *Server) mutation(req incCountReq, delta int64) (models.Mutation, error) {
+	m := newMutation(req, delta)
+	if req.At != nil && time.Since(*req.At) > s.cfg.MaxBackdate {
+		return m, fmt.Errorf("%w: at is further back than %s", models.ErrInvalidInput, s.cfg.MaxBackdate)
+	}
+	return m, nil
+}
+
+// newMutation converts a change request to a mutation without checking the
+// backdating window.
+func newMutation(req incCountReq, delta int64) models.Mutation {
+	m := models.Mutation{Delta: delta, Note: req.Note, Actor: req.Actor}
+	if req.At != nil {
+		m.At = *req.At
+	}
+	return m
 }
 
 func (s *Server) getCurrentCount(w http.ResponseWriter, r *http.Request) {
@@ -137,7 +399,7 @@ func (s *Server) getCurrentCount(w http.ResponseWriter, r *http.Request) {
 	}
 	cnt, err := models.GetOrCreateCurrentCount(r.Context(), s.db, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -145,6 +407,15 @@ func (s *Server) getCurrentCount(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) incrementCount(w http.ResponseWriter, r *http.Request) {
+	s.changeCount(w, r, 1)
+}
+
+func (s *Server) decrementCount(w http.ResponseWriter, r *http.Request) {
+	// Use negative delta to decrement
+	s.changeCount(w, r, -1)
+}
+
+func (s *Server) changeCount(w http.ResponseWriter, r *http.Request, sign int64) {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -152,47 +423,107 @@ func (s *Server) incrementCount(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	var req incCountReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		// default delta to 1 if no body
-		req.Delta = 1
+	req, err := decodeIncCountReq(r)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
 	}
-	if req.Delta == 0 {
-		req.Delta = 1
+	m, err := s.mutation(req, sign*req.Delta)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
-	cnt, err := models.IncrementCurrentCount(r.Context(), s.db, id, req.Delta)
+	cnt, err := models.ApplyMutation(r.Context(), s.db, id, m)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(cnt)
 }
 
-func (s *Server) decrementCount(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	idStr := vars["id"]
-	id, err := strconv.ParseInt(idStr, 10, 64)
+type setCountReq struct {
+	Value *int64     `json:"value"`
+	Note  string     `json:"note,omitempty"`
+	Actor string     `json:"actor,omitempty"`
+	At    *time.Time `json:"at,omitempty"`
+}
+
+func (s *Server) setCount(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
 	if err != nil {
 		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	var req incCountReq
+	var req setCountReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		// default delta to 1 if no body
-		req.Delta = 1
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
 	}
-	if req.Delta == 0 {
-		req.Delta = 1
+	if req.Value == nil {
+		http.Error(w, "value required", http.StatusBadRequest)
+		return
 	}
-	// Use negative delta to decrement
-	cnt, err := models.IncrementCurrentCount(r.Context(), s.db, id, -req.Delta)
+	m, err := s.mutation(incCountReq{Note: req.Note, Actor: req.Actor, At: req.At}, 0)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(cnt)
+	m.Kind, m.Value = models.EventSet, *req.Value
+	cnt, err := models.ApplyMutation(r.Context(), s.db, id, m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cnt)
+}
+
+func (s *Server) resetCount(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	req, err := decodeIncCountReq(r)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	m, err := s.mutation(req, 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	m.Kind = models.EventReset
+	cnt, err := models.ApplyMutation(r.Context(), s.db, id, m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cnt)
+}
+
+func (s *Server) undoCount(w http.ResponseWriter, r *http.Request) {
+	s.revertCount(w, r, models.Undo)
+}
+
+func (s *Server) redoCount(w http.ResponseWriter, r *http.Request) {
+	s.revertCount(w, r, models.Redo)
+}
+
+func (s *Server) revertCount(w http.ResponseWriter, r *http.Request,
+	revert func(context.Context, *pgxpool.Pool, int64, time.Duration) (*models.Count, error)) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	cnt, err := revert(r.Context(), s.db, id, s.cfg.UndoWindow)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cnt)
 }
 
 func (s *Server) getCountHistory(w http.ResponseWriter, r *http.Request) {
@@ -203,11 +534,24 @@ func (s *Server) getCountHistory(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	counts, err := models.GetCountHistory(r.Context(), s.db, id)
+	q := r.URL.Query()
+	page, err := parsePageParams(q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(counts)
+	counts, next, err := models.GetCounts(r.Context(), s.db, id, models.CountQuery{
+		From:   page.From,
+		To:     page.To,
+		Order:  page.Order,
+		Fill:   q.Get("fill") == "true",
+		Limit:  page.Limit,
+		Cursor: page.Cursor,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, counts)
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -8,9 +9,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/iben12/counter-app/internal/db"
 	"github.com/iben12/counter-app/internal/models"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -492,3 +496,1273 @@ func TestIncrementDecrementSameRow(t *testing.T) {
 		t.Errorf("expected count value 5 (10-5), got %d", history[0].Value)
 	}
 }
+
+// TestConcurrentIncrements fires many parallel increments and checks none are lost.
+func TestConcurrentIncrements(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := models.CreateCounter(ctx, pool, fmt.Sprintf("test-concurrent-%d", time.Now().UnixNano()), "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	router := NewRouter(pool)
+
+	const requests = 2000
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures := 0
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("POST", fmt.Sprintf("/counters/%d/count/increment", counter.ID), bytes.NewReader([]byte(`{"delta":1}`)))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				mu.Lock()
+				failures++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failures > 0 {
+		t.Fatalf("expected all increments to succeed, %d failed", failures)
+	}
+
+	count, err := models.GetOrCreateCurrentCount(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get current count: %v", err)
+	}
+	if count.Value != requests {
+		t.Errorf("expected value %d, got %d", requests, count.Value)
+	}
+
+	history, _ := models.GetCountHistory(ctx, pool, counter.ID)
+	if len(history) != 1 {
+		t.Errorf("expected 1 count row, got %d", len(history))
+	}
+}
+
+// TestPatchCounter tests renaming a counter and changing its timezone and frequency together.
+func TestPatchCounter(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := models.CreateCounter(ctx, pool, "test-patch", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	models.GetOrCreateCurrentCount(ctx, pool, counter.ID)
+
+	router := NewRouter(pool)
+	body := []byte(`{"name":"test-patch-renamed","timezone":"Asia/Tokyo","frequency":"2h"}`)
+	req, _ := http.NewRequest("PATCH", fmt.Sprintf("/counters/%d", counter.ID), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var updated models.Counter
+	if err := json.Unmarshal(rec.Body.Bytes(), &updated); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if updated.Name != "test-patch-renamed" || updated.Timezone != "Asia/Tokyo" || updated.Frequency != "2h" {
+		t.Errorf("unexpected counter after patch: %+v", updated)
+	}
+
+	// The current period should now roll over on the new schedule
+	count, err := models.GetOrCreateCurrentCount(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get current count: %v", err)
+	}
+	want, _ := db.NextExpiryTime("2h", time.Now().UTC(), "Asia/Tokyo")
+	if count.Expiry != want.UTC().Format(time.RFC3339) {
+		t.Errorf("expected re-aligned expiry %s, got %s", want.UTC().Format(time.RFC3339), count.Expiry)
+	}
+}
+
+// TestPatchCounterInvalidTimezone tests that an unknown timezone is rejected.
+func TestPatchCounterInvalidTimezone(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := models.CreateCounter(ctx, pool, "test-patch-invalid", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	router := NewRouter(pool)
+	body := []byte(`{"timezone":"Mars/Olympus_Mons"}`)
+	req, _ := http.NewRequest("PATCH", fmt.Sprintf("/counters/%d", counter.ID), bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestDeleteCounter tests deleting a counter.
+func TestDeleteCounter(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := models.CreateCounter(ctx, pool, "test-delete", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	router := NewRouter(pool)
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/counters/%d", counter.ID), nil)
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/counters/%d", counter.ID), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 after delete, got %d", rec.Code)
+	}
+}
+
+// TestArchiveCounter tests that archived counters are hidden from the list but keep their history.
+func TestArchiveCounter(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := models.CreateCounter(ctx, pool, "test-archive", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	models.IncrementCurrentCount(ctx, pool, counter.ID, 3)
+
+	router := NewRouter(pool)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/counters/%d/archive", counter.ID), nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	listed := func(query string) bool {
+		req, _ := http.NewRequest("GET", "/counters"+query, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var counters []models.Counter
+		if err := json.Unmarshal(rec.Body.Bytes(), &counters); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		for _, c := range counters {
+			if c.ID == counter.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	if listed("") {
+		t.Error("expected archived counter to be hidden from GET /counters")
+	}
+	if !listed("?include_archived=true") {
+		t.Error("expected archived counter with include_archived=true")
+	}
+
+	history, _ := models.GetCountHistory(ctx, pool, counter.ID)
+	if len(history) != 1 || history[0].Value != 3 {
+		t.Errorf("expected archived counter to keep its history, got %+v", history)
+	}
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/counters/%d/unarchive", counter.ID), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if !listed("") {
+		t.Error("expected unarchived counter to be listed again")
+	}
+}
+
+// TestCreateCounterWithWeekStartAndAnchor tests creating a sprint counter aligned to an anchor date.
+func TestCreateCounterWithWeekStartAndAnchor(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	router := NewRouter(pool)
+
+	body := []byte(`{"name":"test-sprint","frequency":"2w","week_start":"wednesday","anchor":"2025-01-08"}`)
+	req, _ := http.NewRequest("POST", "/counters", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var counter models.Counter
+	if err := json.Unmarshal(rec.Body.Bytes(), &counter); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if counter.WeekStart != "wednesday" {
+		t.Errorf("expected week_start 'wednesday', got %q", counter.WeekStart)
+	}
+	if counter.Anchor == nil || *counter.Anchor != "2025-01-08" {
+		t.Errorf("expected anchor '2025-01-08', got %v", counter.Anchor)
+	}
+
+	count, err := models.GetOrCreateCurrentCount(context.Background(), pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get current count: %v", err)
+	}
+	want, _ := db.NextExpiryTimeWithOptions("2w", time.Now().UTC(), "UTC", db.PeriodOptions{
+		WeekStart: time.Wednesday,
+		Anchor:    time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC),
+	})
+	if count.Expiry != want.Format(time.RFC3339) {
+		t.Errorf("expected expiry %s, got %s", want.Format(time.RFC3339), count.Expiry)
+	}
+}
+
+// TestCreateCounterInvalidAnchor tests that malformed week start and anchor values are rejected.
+func TestCreateCounterInvalidAnchor(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	router := NewRouter(pool)
+
+	for _, body := range []string{
+		`{"name":"test-bad-anchor","anchor":"08/01/2025"}`,
+		`{"name":"test-bad-week-start","week_start":"someday"}`,
+	} {
+		req, _ := http.NewRequest("POST", "/counters", bytes.NewReader([]byte(body)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", body, rec.Code)
+		}
+	}
+}
+
+// TestCreateCronCounter tests that a cron-scheduled counter expires on the next cron match.
+func TestCreateCronCounter(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	router := NewRouter(pool)
+
+	body := []byte(`{"name":"test-cron","timezone":"Europe/Budapest","schedule_type":"cron","schedule":"0 9 * * 1-5"}`)
+	req, _ := http.NewRequest("POST", "/counters", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var counter models.Counter
+	if err := json.Unmarshal(rec.Body.Bytes(), &counter); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if counter.ScheduleType != "cron" || counter.Schedule != "0 9 * * 1-5" {
+		t.Errorf("expected cron schedule, got %q %q", counter.ScheduleType, counter.Schedule)
+	}
+
+	count, err := models.GetOrCreateCurrentCount(context.Background(), pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get current count: %v", err)
+	}
+	s, _ := db.NewScheduler("cron", "0 9 * * 1-5", "Europe/Budapest", db.DefaultPeriodOptions)
+	want, _ := s.Next(time.Now().UTC())
+	if count.Expiry != want.Format(time.RFC3339) {
+		t.Errorf("expected expiry %s, got %s", want.Format(time.RFC3339), count.Expiry)
+	}
+
+	for _, body := range []string{
+		`{"name":"test-bad-cron","schedule_type":"cron","schedule":"0 9 * *"}`,
+		`{"name":"test-missing-cron","schedule_type":"cron"}`,
+		`{"name":"test-unknown-schedule","schedule_type":"lunar","schedule":"full moon"}`,
+	} {
+		req, _ := http.NewRequest("POST", "/counters", bytes.NewReader([]byte(body)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", body, rec.Code)
+		}
+	}
+}
+
+// TestUpdateCounterToRRule tests that switching a counter to an RRULE without
+// DTSTART anchors it to today, and that removing the anchor keeps it pinned.
+func TestUpdateCounterToRRule(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	counter, err := models.CreateCounter(context.Background(), pool, "test-rrule", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	router := NewRouter(pool)
+	today := time.Now().UTC().Format("2006-01-02")
+
+	for _, body := range []string{
+		`{"schedule_type":"rrule","schedule":"FREQ=HOURLY"}`,
+		`{"anchor":""}`,
+	} {
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/counters/%d", counter.ID), bytes.NewReader([]byte(body)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", body, rec.Code, rec.Body.String())
+		}
+		var updated models.Counter
+		_ = json.Unmarshal(rec.Body.Bytes(), &updated)
+		if updated.Anchor == nil || *updated.Anchor != today {
+			t.Errorf("expected anchor %s after %s, got %v", today, body, updated.Anchor)
+		}
+	}
+}
+
+// TestListEvents tests the event log endpoint, its notes and the next-page Link header.
+func TestListEvents(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-events", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	router := NewRouter(pool)
+	for i := 0; i < 3; i++ {
+		body := []byte(`{"delta":2,"note":"coffee","actor":"bob"}`)
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/counters/%d/count/increment", counter.ID), bytes.NewReader(body))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/counters/%d/events?limit=2", counter.ID), nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var events []models.Event
+	if err := json.Unmarshal(rec.Body.Bytes(), &events); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(events) != 2 || events[0].Value != 6 || events[0].Note != "coffee" || events[0].Actor != "bob" {
+		t.Errorf("unexpected first page: %+v", events)
+	}
+
+	link := rec.Header().Get("Link")
+	var next string
+	if _, err := fmt.Sscanf(link, "<%s", &next); err != nil || len(next) < 2 {
+		t.Fatalf("expected a next Link header, got %q", link)
+	}
+	next = next[:len(next)-2] // strip `>;`
+	req, _ = http.NewRequest("GET", next, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	events = nil
+	_ = json.Unmarshal(rec.Body.Bytes(), &events)
+	if len(events) != 1 || events[0].Value != 2 {
+		t.Errorf("unexpected second page: %+v", events)
+	}
+	if rec.Header().Get("Link") != "" {
+		t.Errorf("expected no Link header on the last page, got %q", rec.Header().Get("Link"))
+	}
+
+	for _, query := range []string{"?from=yesterday", "?limit=-1", "?cursor=!!"} {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/counters/%d/events%s", counter.ID, query), nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", query, rec.Code)
+		}
+	}
+}
+
+// TestIncrementBackdated tests that an increment with an "at" timestamp lands in
+// its own period and that the backdating window is enforced.
+func TestIncrementBackdated(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-backdate", "1h", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	router := NewRouterWithConfig(pool, Config{MaxBackdate: 24 * time.Hour})
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/counters/%d/count/increment", counter.ID), bytes.NewReader([]byte(body)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	at := time.Now().UTC().Add(-3 * time.Hour)
+	rec := post(fmt.Sprintf(`{"delta":2,"at":%q}`, at.Format(time.RFC3339)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var cnt models.Count
+	_ = json.Unmarshal(rec.Body.Bytes(), &cnt)
+	want, _ := db.NextExpiryTime("1h", at, "UTC")
+	if cnt.Expiry != want.Format(time.RFC3339) || cnt.Value != 2 {
+		t.Errorf("expected count expiring %s with value 2, got %+v", want.Format(time.RFC3339), cnt)
+	}
+
+	current, _ := models.GetOrCreateCurrentCount(ctx, pool, counter.ID)
+	if current.Value != 0 {
+		t.Errorf("expected the current period to be untouched, got value %d", current.Value)
+	}
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"at":%q}`, time.Now().Add(-48*time.Hour).Format(time.RFC3339)),
+		fmt.Sprintf(`{"at":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339)),
+		`{"at":"yesterday"}`,
+	} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", body, rec.Code)
+		}
+	}
+}
+
+// TestSync tests the batch sync endpoint and its per-op rejections.
+func TestSync(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-sync", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	router := NewRouter(pool)
+	body := fmt.Sprintf(`{"ops":[
+		{"op_id":"test-sync-1","counter_id":%[1]d,"type":"increment","delta":3},
+		{"op_id":"test-sync-2","counter_id":%[1]d,"type":"decrement"},
+		{"op_id":"test-sync-3","counter_id":%[1]d,"type":"multiply"},
+		{"op_id":"test-sync-4","counter_id":%[1]d,"type":"increment","at":%[2]q}
+	]}`, counter.ID, time.Now().Add(-30*24*time.Hour).Format(time.RFC3339))
+	req, _ := http.NewRequest("POST", "/sync", bytes.NewReader([]byte(body)))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var res models.SyncResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(res.Applied) != 2 {
+		t.Errorf("expected 2 applied ops, got %v", res.Applied)
+	}
+	if len(res.Rejected) != 2 || res.Rejected[0].OpID != "test-sync-3" || res.Rejected[1].OpID != "test-sync-4" {
+		t.Errorf("expected test-sync-3 and test-sync-4 rejected, got %v", res.Rejected)
+	}
+	if len(res.Counters) != 1 || res.Counters[0].Value != 2 {
+		t.Errorf("expected the counter at 2, got %+v", res.Counters)
+	}
+
+	req, _ = http.NewRequest("POST", "/sync", bytes.NewReader([]byte(`{"ops":[{"counter_id":1,"type":"increment"}]}`)))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without op_id, got %d", rec.Code)
+	}
+}
+
+// TestIdempotencyKey tests that a retried increment with the same Idempotency-Key
+// is replayed instead of applied twice, and that reusing the key differently conflicts.
+func TestIdempotencyKey(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-idempotency", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	router := NewRouter(pool)
+	key := fmt.Sprintf("test-key-%d", time.Now().UnixNano())
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/counters/%d/count/increment", counter.ID), bytes.NewReader([]byte(body)))
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post(`{"delta":3}`)
+	if first.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", first.Code, first.Body.String())
+	}
+	retry := post(`{"delta":3}`)
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Errorf("expected the original response replayed, got %d: %s", retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected the Idempotent-Replayed header on the retry")
+	}
+
+	cnt, _ := models.GetOrCreateCurrentCount(ctx, pool, counter.ID)
+	if cnt.Value != 3 {
+		t.Errorf("expected value 3 after a retried increment, got %d", cnt.Value)
+	}
+
+	if rec := post(`{"delta":4}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for a different payload, got %d", rec.Code)
+	}
+
+	key = fmt.Sprintf("test-key-large-%d", time.Now().UnixNano())
+	large := fmt.Sprintf(`{"delta":1,"note":%q}`, strings.Repeat("x", maxIdempotentBodySize))
+	if rec := post(large); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 for an oversized body, got %d", rec.Code)
+	}
+}
+
+// TestSetAndResetCount tests the set and reset endpoints.
+func TestSetAndResetCount(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-set-reset", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	router := NewRouter(pool)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, fmt.Sprintf("/counters/%d%s", counter.ID, path), bytes.NewReader([]byte(body)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("PUT", "/count", `{"value":42,"note":"recount"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var cnt models.Count
+	_ = json.Unmarshal(rec.Body.Bytes(), &cnt)
+	if cnt.Value != 42 {
+		t.Errorf("expected value 42, got %d", cnt.Value)
+	}
+
+	rec = do("POST", "/count/reset", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &cnt)
+	if cnt.Value != 0 {
+		t.Errorf("expected value 0, got %d", cnt.Value)
+	}
+
+	for _, body := range []string{`{}`, `{"value":-1}`} {
+		if rec := do("PUT", "/count", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", body, rec.Code)
+		}
+	}
+
+	events, _, _ := models.GetEvents(ctx, pool, counter.ID, models.EventQuery{})
+	if len(events) != 2 || events[0].Kind != models.EventReset || events[1].Kind != models.EventSet || events[1].Note != "recount" {
+		t.Errorf("expected reset and set events, got %+v", events)
+	}
+}
+
+// TestUndoRedoCount tests the undo and redo endpoints.
+func TestUndoRedoCount(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-undo", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	router := NewRouter(pool)
+	post := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/counters/%d/count/%s", counter.ID, path), nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post("undo"); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 with nothing to undo, got %d", rec.Code)
+	}
+	post("increment")
+	post("increment")
+
+	for _, step := range []struct {
+		path string
+		want int64
+	}{{"undo", 1}, {"redo", 2}} {
+		rec := post(step.path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", step.path, rec.Code, rec.Body.String())
+		}
+		var cnt models.Count
+		_ = json.Unmarshal(rec.Body.Bytes(), &cnt)
+		if cnt.Value != step.want {
+			t.Errorf("expected value %d after %s, got %d", step.want, step.path, cnt.Value)
+		}
+	}
+
+	if rec := post("redo"); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 with nothing to redo, got %d", rec.Code)
+	}
+}
+
+// TestCounterBounds tests bound settings on create and patch and the 409 for
+// an increment past max in reject mode.
+func TestCounterBounds(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	router := NewRouter(pool)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("POST", "/counters", `{"name":"test-bounds","min":null,"max":3,"goal":2,"bound_mode":"reject"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var counter models.Counter
+	_ = json.Unmarshal(rec.Body.Bytes(), &counter)
+	if counter.Min != nil || counter.Max == nil || *counter.Max != 3 || counter.BoundMode != "reject" {
+		t.Errorf("expected min null, max 3 and reject mode, got %+v", counter)
+	}
+
+	rec = do("POST", fmt.Sprintf("/counters/%d/count/increment", counter.ID), `{"delta":3}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var cnt models.Count
+	_ = json.Unmarshal(rec.Body.Bytes(), &cnt)
+	if cnt.Progress == nil || *cnt.Progress != 1.5 {
+		t.Errorf("expected progress 1.5, got %v", cnt.Progress)
+	}
+	if rec := do("POST", fmt.Sprintf("/counters/%d/count/increment", counter.ID), ""); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 past max, got %d", rec.Code)
+	}
+
+	rec = do("PATCH", fmt.Sprintf("/counters/%d", counter.ID), `{"goal":null,"bound_mode":"clamp"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &counter)
+	if counter.Goal != nil || counter.Max == nil || counter.BoundMode != "clamp" {
+		t.Errorf("expected goal removed and max kept, got %+v", counter)
+	}
+
+	for _, body := range []string{`{"min":5}`, `{"goal":0}`, `{"bound_mode":"wrap"}`} {
+		if rec := do("PATCH", fmt.Sprintf("/counters/%d", counter.ID), body); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", body, rec.Code)
+		}
+	}
+}
+
+// TestCounterStats tests the stats endpoint.
+func TestCounterStats(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-stats", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	models.IncrementCurrentCount(ctx, pool, counter.ID, 1)
+
+	router := NewRouter(pool)
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/counters/%d/stats", counter.ID), nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var stats models.CounterStats
+	_ = json.Unmarshal(rec.Body.Bytes(), &stats)
+	if stats.CurrentStreak != 1 || stats.LongestStreak != 1 || stats.CompletionRate != nil {
+		t.Errorf("expected a streak of 1 and no completion rate yet, got %+v", stats)
+	}
+
+	req, _ = http.NewRequest("GET", "/counters/999999/stats", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rec.Code)
+	}
+}
+
+// TestGetFilledCountHistory tests that ?fill=true lists untouched periods.
+func TestGetFilledCountHistory(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-fill", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	pool.Exec(ctx, "UPDATE counters SET created_at = now() - interval '2 days' WHERE id = $1", counter.ID)
+	models.IncrementCurrentCount(ctx, pool, counter.ID, 1)
+
+	router := NewRouter(pool)
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{{"", 1}, {"?fill=true", 3}} {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/counters/%d/counts%s", counter.ID, tt.query), nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var counts []models.Count
+		_ = json.Unmarshal(rec.Body.Bytes(), &counts)
+		if len(counts) != tt.want {
+			t.Errorf("expected %d counts for %q, got %d", tt.want, tt.query, len(counts))
+		}
+	}
+}
+
+// TestListCountersPagination tests following the next link of the counter listing.
+func TestListCountersPagination(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	pool.Exec(ctx, "TRUNCATE TABLE counters RESTART IDENTITY CASCADE")
+	for i := 0; i < 3; i++ {
+		if _, err := models.CreateCounter(ctx, pool, fmt.Sprintf("test-page-%d", i), "1d", "UTC"); err != nil {
+			t.Fatalf("failed to create counter: %v", err)
+		}
+	}
+
+	router := NewRouter(pool)
+	var names []string
+	path := "/counters?limit=2&order=desc"
+	for page := 0; path != ""; page++ {
+		if page > 3 {
+			t.Fatal("pagination did not terminate")
+		}
+		req, _ := http.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var counters []models.Counter
+		_ = json.Unmarshal(rec.Body.Bytes(), &counters)
+		for _, c := range counters {
+			names = append(names, c.Name)
+		}
+		path = ""
+		if link := rec.Header().Get("Link"); link != "" {
+			path = link[1:strings.Index(link, ">")]
+		}
+	}
+	if fmt.Sprint(names) != "[test-page-2 test-page-1 test-page-0]" {
+		t.Errorf("expected counters newest first across pages, got %v", names)
+	}
+
+	req, _ := http.NewRequest("GET", "/counters?order=random", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown order, got %d", rec.Code)
+	}
+}
+
+// TestAggregate tests the aggregate endpoint and its validation.
+func TestAggregate(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-aggregate", "1h", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	models.IncrementCurrentCount(ctx, pool, counter.ID, 3)
+
+	router := NewRouter(pool)
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/counters/%d/aggregate%s", counter.ID, query), nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("?bucket=1M&fn=sum,avg,p90")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var agg models.Aggregation
+	_ = json.Unmarshal(rec.Body.Bytes(), &agg)
+	if len(agg.Buckets) != 1 {
+		t.Fatalf("expected one monthly bucket, got %+v", agg.Buckets)
+	}
+	for _, fn := range []string{"sum", "avg", "p90"} {
+		if v := agg.Buckets[0].Values[fn]; v == nil || *v != 3 {
+			t.Errorf("expected %s 3, got %v", fn, v)
+		}
+	}
+
+	for _, query := range []string{"", "?bucket=1x", "?bucket=1d&fn=median", "?bucket=1d&from=yesterday"} {
+		if rec := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %q, got %d", query, rec.Code)
+		}
+	}
+}
+
+// TestCounterLifetimeTotals tests that counter responses carry the lifetime totals.
+func TestCounterLifetimeTotals(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-lifetime", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	models.IncrementCurrentCount(ctx, pool, counter.ID, 4)
+
+	router := NewRouter(pool)
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/counters/%d", counter.ID), nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var got models.Counter
+	_ = json.Unmarshal(rec.Body.Bytes(), &got)
+	if got.LifetimeTotal != 4 || got.PeriodCount != 1 {
+		t.Errorf("expected lifetime total 4 over 1 period, got %d over %d", got.LifetimeTotal, got.PeriodCount)
+	}
+}
+
+// TestCounterWindows tests creating a window and reading its count after an increment.
+func TestCounterWindows(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-windows", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	router := NewRouter(pool)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	base := fmt.Sprintf("/counters/%d", counter.ID)
+
+	if rec := do("POST", base+"/windows", `{"name": "weekly", "frequency": "1w"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("POST", base+"/windows", `{"name": "weekly", "frequency": "1w"}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for a duplicate window, got %d", rec.Code)
+	}
+	if rec := do("POST", base+"/count/increment", `{"delta": 3}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := do("GET", base+"/windows/weekly/count", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var cnt models.Count
+	_ = json.Unmarshal(rec.Body.Bytes(), &cnt)
+	if cnt.Value != 3 || cnt.Window != "weekly" {
+		t.Errorf("expected weekly value 3, got %+v", cnt)
+	}
+
+	rec = do("GET", base+"/windows/weekly/counts", "")
+	var counts []models.Count
+	_ = json.Unmarshal(rec.Body.Bytes(), &counts)
+	if rec.Code != http.StatusOK || len(counts) != 1 {
+		t.Errorf("expected one weekly count, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("GET", base+"/windows/monthly/count", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown window, got %d", rec.Code)
+	}
+	if rec := do("DELETE", base+"/windows/weekly", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rec.Code)
+	}
+}
+
+// TestStreamCounter tests resuming a counter's event stream with Last-Event-ID
+// and receiving live changes.
+func TestStreamCounter(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	counter, err := models.CreateCounter(ctx, pool, "test-stream", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	models.IncrementCurrentCount(ctx, pool, counter.ID, 1)
+	models.IncrementCurrentCount(ctx, pool, counter.ID, 2)
+	events, _, err := models.GetEvents(ctx, pool, counter.ID, models.EventQuery{Order: models.OrderAsc})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %v", len(events), err)
+	}
+
+	srv := httptest.NewServer(NewRouter(pool))
+	defer srv.Close()
+	req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/counters/%d/stream", srv.URL, counter.ID), nil)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(events[0].ID))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	nextEvent := func() (id, kind string, e models.Event) {
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				kind = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e)
+			case line == "" && kind != "":
+				return id, kind, e
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return
+	}
+
+	id, kind, e := nextEvent()
+	if id != fmt.Sprint(events[1].ID) || kind != models.EventIncrement || e.Value != 3 {
+		t.Errorf("expected the missed increment to be replayed, got %s %s %+v", id, kind, e)
+	}
+	if _, err := models.SetCurrentCount(ctx, pool, counter.ID, 7); err != nil {
+		t.Fatalf("failed to set count: %v", err)
+	}
+	if _, kind, e := nextEvent(); kind != models.EventSet || e.Value != 7 {
+		t.Errorf("expected the live set, got %s %+v", kind, e)
+	}
+}
+
+// TestWebSocket tests subscribing to a counter and incrementing it over a
+// WebSocket connection.
+func TestWebSocket(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-ws", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	srv := httptest.NewServer(NewRouter(pool))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	roundTrip := func(req wsRequest) wsMessage {
+		if err := conn.WriteJSON(req); err != nil {
+			t.Fatalf("failed to send %s: %v", req.Type, err)
+		}
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("failed to read reply to %s: %v", req.Type, err)
+		}
+		return msg
+	}
+
+	if msg := roundTrip(wsRequest{Type: "subscribe", ID: "1", Counters: []int64{counter.ID + 1000}}); msg.Type != "error" || msg.Status != http.StatusNotFound {
+		t.Errorf("expected a 404 error for an unknown counter, got %+v", msg)
+	}
+	msg := roundTrip(wsRequest{Type: "subscribe", ID: "2", Counters: []int64{counter.ID}})
+	if msg.Type != "ack" || msg.ID != "2" || len(msg.Counts) != 1 || msg.Counts[0].Value != 0 {
+		t.Errorf("expected an ack with the current count, got %+v", msg)
+	}
+	msg = roundTrip(wsRequest{Type: "increment", ID: "3", CounterID: counter.ID, Delta: 4})
+	if msg.Type != "ack" || msg.ID != "3" || msg.Count == nil || msg.Count.Value != 4 {
+		t.Errorf("expected an ack with the confirmed value 4, got %+v", msg)
+	}
+	var change wsMessage
+	if err := conn.ReadJSON(&change); err != nil {
+		t.Fatalf("failed to read change: %v", err)
+	}
+	if change.Type != "change" || change.Kind != models.EventIncrement || change.Event == nil || change.Event.Value != 4 {
+		t.Errorf("expected the increment to be pushed, got %+v", change)
+	}
+	msg = roundTrip(wsRequest{Type: "decrement", ID: "4", CounterID: counter.ID})
+	if msg.Type != "ack" || msg.Count == nil || msg.Count.Value != 3 {
+		t.Errorf("expected an ack with the confirmed value 3, got %+v", msg)
+	}
+	if err := conn.ReadJSON(&change); err != nil || change.Kind != models.EventDecrement {
+		t.Fatalf("expected the decrement to be pushed, got %+v: %v", change, err)
+	}
+	if msg := roundTrip(wsRequest{Type: "unsubscribe", ID: "5", Counters: []int64{counter.ID}}); msg.Type != "ack" {
+		t.Errorf("expected an ack, got %+v", msg)
+	}
+	if msg := roundTrip(wsRequest{Type: "reset", ID: "6"}); msg.Type != "error" || msg.Status != http.StatusBadRequest {
+		t.Errorf("expected a 400 error for an unknown type, got %+v", msg)
+	}
+}
+
+// TestWebhooksAPI tests managing a webhook and listing the deliveries queued
+// for it.
+func TestWebhooksAPI(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-webhooks", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	router := NewRouter(pool)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("POST", "/webhooks", `{"url": "mailto:someone@example.com"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a non-HTTP URL, got %d", rec.Code)
+	}
+	if rec := do("POST", "/webhooks", `{"url": "http://example.com", "events": ["nope"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown event, got %d", rec.Code)
+	}
+	body := fmt.Sprintf(`{"url": "http://example.com/hook", "events": ["count.changed"], "counter_id": %d}`, counter.ID)
+	rec := do("POST", "/webhooks", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var webhook models.Webhook
+	_ = json.Unmarshal(rec.Body.Bytes(), &webhook)
+	if webhook.Secret == "" {
+		t.Error("expected the new webhook's secret in the response")
+	}
+	path := fmt.Sprintf("/webhooks/%d", webhook.ID)
+
+	rec = do("GET", path, "")
+	var fetched models.Webhook
+	_ = json.Unmarshal(rec.Body.Bytes(), &fetched)
+	if rec.Code != http.StatusOK || fetched.Secret != "" || fetched.URL != "http://example.com/hook" {
+		t.Errorf("expected the webhook without its secret, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := do("POST", fmt.Sprintf("/counters/%d/count/increment", counter.ID), `{"delta": 2}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = do("GET", path+"/deliveries", "")
+	var deliveries []models.WebhookDelivery
+	_ = json.Unmarshal(rec.Body.Bytes(), &deliveries)
+	if rec.Code != http.StatusOK || len(deliveries) != 1 || deliveries[0].Status != models.DeliveryPending {
+		t.Errorf("expected one pending delivery, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("GET", path+"/deliveries?status=lost", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown status, got %d", rec.Code)
+	}
+
+	rec = do("PATCH", path, `{"active": false, "secret": ""}`)
+	var updated models.Webhook
+	_ = json.Unmarshal(rec.Body.Bytes(), &updated)
+	if rec.Code != http.StatusOK || updated.Active || updated.Secret == "" || updated.Secret == webhook.Secret {
+		t.Errorf("expected an inactive webhook with a new secret, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := do("DELETE", path, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rec.Code)
+	}
+	if rec := do("GET", path, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a deleted webhook, got %d", rec.Code)
+	}
+}
+
+// TestTriggerTokens tests creating a trigger token, using it with GET and
+// POST, and revoking it.
+func TestTriggerTokens(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-tokens", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	router := NewRouter(pool)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	base := fmt.Sprintf("/counters/%d/tokens", counter.ID)
+
+	if rec := do("POST", base, `{"action": "set"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a set without amount, got %d", rec.Code)
+	}
+	rec := do("POST", base, `{"name": "button"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var token models.TriggerToken
+	_ = json.Unmarshal(rec.Body.Bytes(), &token)
+
+	for i, method := range []string{"GET", "POST"} {
+		rec := do(method, "/t/"+token.Token, "")
+		var cnt models.Count
+		_ = json.Unmarshal(rec.Body.Bytes(), &cnt)
+		if rec.Code != http.StatusOK || cnt.Value != int64(i+1) {
+			t.Errorf("%s: expected value %d, got %d: %s", method, i+1, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec = do("GET", base, "")
+	var tokens []models.TriggerToken
+	_ = json.Unmarshal(rec.Body.Bytes(), &tokens)
+	if rec.Code != http.StatusOK || len(tokens) != 1 || tokens[0].Token != "" || tokens[0].LastUsedAt == nil {
+		t.Errorf("expected the used token without its secret, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	path := fmt.Sprintf("%s/%d", base, token.ID)
+	if rec := do("DELETE", path, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rec.Code)
+	}
+	if rec := do("GET", "/t/"+token.Token, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a revoked token, got %d", rec.Code)
+	}
+	if rec := do("POST", path+"/rotate", ""); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 when rotating a revoked token, got %d", rec.Code)
+	}
+	rec = do("GET", path+"/uses", "")
+	var uses []models.TriggerTokenUse
+	_ = json.Unmarshal(rec.Body.Bytes(), &uses)
+	if rec.Code != http.StatusOK || len(uses) != 3 {
+		t.Errorf("expected 3 logged uses, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAlertRulesAPI(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := models.CreateCounter(ctx, pool, "test-alerts", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	router := NewRouter(pool)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	base := fmt.Sprintf("/counters/%d", counter.ID)
+
+	if rec := do("POST", base+"/alert-rules", `{"kind": "sometimes"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown kind, got %d", rec.Code)
+	}
+	rec := do("POST", base+"/alert-rules", `{"name": "busy", "kind": "threshold", "threshold": 2}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var rule models.AlertRule
+	_ = json.Unmarshal(rec.Body.Bytes(), &rule)
+	path := fmt.Sprintf("%s/alert-rules/%d", base, rule.ID)
+
+	for i := 0; i < 2; i++ {
+		if rec := do("POST", base+"/count/increment", ""); rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	rec = do("GET", path, "")
+	_ = json.Unmarshal(rec.Body.Bytes(), &rule)
+	if rec.Code != http.StatusOK || !rule.Firing {
+		t.Errorf("expected a firing rule, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = do("GET", base+"/alerts?state=firing", "")
+	var alerts []models.Alert
+	_ = json.Unmarshal(rec.Body.Bytes(), &alerts)
+	if rec.Code != http.StatusOK || len(alerts) != 1 || alerts[0].RuleID != rule.ID || alerts[0].Value != 2 {
+		t.Errorf("expected one firing alert, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = do("GET", base+"/alert-rules", "")
+	var rules []models.AlertRule
+	_ = json.Unmarshal(rec.Body.Bytes(), &rules)
+	if rec.Code != http.StatusOK || len(rules) != 1 {
+		t.Errorf("expected one rule, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("DELETE", path, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rec.Code)
+	}
+	if rec := do("GET", path, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a deleted rule, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iben12/counter-app/internal/models"
+)
+
+func (s *Server) streamAll(w http.ResponseWriter, r *http.Request) {
+	s.stream(w, r, 0)
+}
+
+func (s *Server) streamCounter(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if _, err := models.GetCounterByID(r.Context(), s.db, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.stream(w, r, id)
+}
+
+// stream serves the changes of the counter with counterID, or of all counters
+// when it is 0, as Server-Sent Events. Events of the event log carry their ID,
+// so a reconnecting client's Last-Event-ID header resumes after the last one
+// it received; rollovers have no ID and are not replayed.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, counterID int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	var lastID int64
+	resume := r.Header.Get("Last-Event-ID")
+	if resume != "" {
+		id, err := strconv.ParseInt(resume, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	ctx := r.Context()
+	sub, err := s.broker.Subscribe(ctx, counterID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Replay what the client missed: first the events committed after the
+	// last one it received despite their lower ID, then those after it.
+	// Events committed since subscribing may also arrive live; those are
+	// skipped there.
+	replayed := map[int64]bool{}
+	if resume != "" {
+		events, err := models.GetEventsCommittedAfter(ctx, s.db, counterID, lastID)
+		if err != nil {
+			return
+		}
+		for i := range events {
+			e := &events[i]
+			writeChange(w, models.Change{Kind: e.Kind, CounterID: e.CounterID, Event: e})
+			replayed[e.ID] = true
+		}
+	}
+	for resume != "" {
+		events, err := models.GetEventsAfter(ctx, s.db, counterID, lastID, models.MaxPageLimit)
+		if err != nil {
+			return
+		}
+		for i := range events {
+			e := &events[i]
+			writeChange(w, models.Change{Kind: e.Kind, CounterID: e.CounterID, Event: e})
+			replayed[e.ID] = true
+			lastID = e.ID
+		}
+		if len(events) < models.MaxPageLimit {
+			break
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(s.cfg.StreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if change.Event != nil && replayed[change.Event.ID] {
+				continue
+			}
+			writeChange(w, change)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// writeChange writes change as a Server-Sent Event named after its kind. The
+// data is the event log entry, or the new period's count for a rollover.
+func writeChange(w http.ResponseWriter, change models.Change) {
+	var data []byte
+	if change.Event != nil {
+		fmt.Fprintf(w, "id: %d\n", change.Event.ID)
+		data, _ = json.Marshal(change.Event)
+	} else {
+		data, _ = json.Marshal(change.Count)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", change.Kind, data)
+}
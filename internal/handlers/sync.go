@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iben12/counter-app/internal/models"
+)
+
+// maxSyncOps caps the number of operations in one /sync request.
+const maxSyncOps = 1000
+
+type syncOpReq struct {
+	OpID      string     `json:"op_id"`
+	CounterID int64      `json:"counter_id"`
+	Type      string     `json:"type"` // increment, decrement, set or reset
+	Delta     int64      `json:"delta,omitempty"`
+	Value     *int64     `json:"value,omitempty"`
+	At        *time.Time `json:"at,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	Actor     string     `json:"actor,omitempty"`
+}
+
+type syncReq struct {
+	Ops []syncOpReq `json:"ops"`
+}
+
+// syncOp converts a request operation to a model operation, applying the same
+// defaults as the increment and decrement endpoints. Their backdating window is
+// checked by models.Sync, so replays of ops applied before are not rejected
+// once their time has left it.
+func syncOp(req syncOpReq) (models.SyncOp, error) {
+	op := models.SyncOp{OpID: req.OpID, CounterID: req.CounterID}
+	inc := incCountReq{Delta: req.Delta, Note: req.Note, Actor: req.Actor, At: req.At}
+	if inc.Delta == 0 {
+		inc.Delta = 1
+	}
+	switch req.Type {
+	case "increment":
+		op.Mutation = newMutation(inc, inc.Delta)
+	case "decrement":
+		op.Mutation = newMutation(inc, -inc.Delta)
+	case "set":
+		if req.Value == nil {
+			return op, fmt.Errorf("%w: value required for set", models.ErrInvalidInput)
+		}
+		op.Mutation = newMutation(inc, 0)
+		op.Mutation.Kind, op.Mutation.Value = models.EventSet, *req.Value
+	case "reset":
+		op.Mutation = newMutation(inc, 0)
+		op.Mutation.Kind = models.EventReset
+	default:
+		return op, fmt.Errorf("%w: unknown op type: %q", models.ErrInvalidInput, req.Type)
+	}
+	return op, nil
+}
+
+func (s *Server) sync(w http.ResponseWriter, r *http.Request) {
+	var req syncReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Ops) > maxSyncOps {
+		http.Error(w, fmt.Sprintf("at most %d ops per request", maxSyncOps), http.StatusBadRequest)
+		return
+	}
+
+	var ops []models.SyncOp
+	var rejected []models.RejectedOp
+	for _, o := range req.Ops {
+		if o.OpID == "" {
+			http.Error(w, "op_id required", http.StatusBadRequest)
+			return
+		}
+		op, err := syncOp(o)
+		if err != nil {
+			rejected = append(rejected, models.RejectedOp{OpID: o.OpID, Error: err.Error()})
+			continue
+		}
+		ops = append(ops, op)
+	}
+
+	res, err := models.Sync(r.Context(), s.db, ops, s.cfg.MaxBackdate)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res.Rejected = append(append([]models.RejectedOp{}, rejected...), res.Rejected...)
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/iben12/counter-app/internal/models"
+)
+
+func (s *Server) listTokens(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	tokens, err := models.GetTriggerTokens(r.Context(), s.db, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// createTokenReq configures a new trigger token. Amount defaults to 1 for an
+// increment or decrement and is required for a set.
+type createTokenReq struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Amount *int64 `json:"amount,omitempty"`
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req createTokenReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	settings := models.TriggerTokenSettings{Name: req.Name, Action: req.Action, Amount: 1}
+	if req.Amount != nil {
+		settings.Amount = *req.Amount
+	} else if req.Action == models.TokenSet {
+		http.Error(w, "amount required", http.StatusBadRequest)
+		return
+	}
+	token, err := models.CreateTriggerToken(r.Context(), s.db, id, settings)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, token)
+}
+
+// tokenIDs parses the counter and token IDs of a token's path.
+func tokenIDs(r *http.Request) (counterID, tokenID int64, err error) {
+	if counterID, err = pathID(r, "id"); err != nil {
+		return 0, 0, err
+	}
+	tokenID, err = pathID(r, "token_id")
+	return counterID, tokenID, err
+}
+
+func (s *Server) rotateToken(w http.ResponseWriter, r *http.Request) {
+	counterID, tokenID, err := tokenIDs(r)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	token, err := models.RotateTriggerToken(r.Context(), s.db, counterID, tokenID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, token)
+}
+
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request) {
+	counterID, tokenID, err := tokenIDs(r)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if _, err := models.RevokeTriggerToken(r.Context(), s.db, counterID, tokenID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listTokenUses(w http.ResponseWriter, r *http.Request) {
+	counterID, tokenID, err := tokenIDs(r)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	uses, next, err := models.GetTriggerTokenUses(r.Context(), s.db, counterID, tokenID, models.TokenUseQuery{
+		Limit:  page.Limit,
+		Cursor: page.Cursor,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, uses)
+}
+
+// trigger applies the action of the token in the path and returns the count
+// it left. GET works too, for clients that can only open a URL.
+func (s *Server) trigger(w http.ResponseWriter, r *http.Request) {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	cnt, err := models.UseTriggerToken(r.Context(), s.db, mux.Vars(r)["token"], models.TokenRequest{
+		Method:     r.Method,
+		RemoteAddr: remote,
+		UserAgent:  r.UserAgent(),
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cnt)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/iben12/counter-app/internal/models"
+)
+
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := models.GetWebhooks(r.Context(), s.db)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+type createWebhookReq struct {
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	CounterID *int64   `json:"counter_id,omitempty"`
+	Secret    string   `json:"secret,omitempty"`
+}
+
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req createWebhookReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.URL == "" {
+		http.Error(w, "url required", http.StatusBadRequest)
+		return
+	}
+	webhook, err := models.CreateWebhook(r.Context(), s.db, models.WebhookSettings{
+		URL:       req.URL,
+		Events:    req.Events,
+		CounterID: req.CounterID,
+		Secret:    req.Secret,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+func (s *Server) getWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	webhook, err := models.GetWebhookByID(r.Context(), s.db, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+// updateWebhookReq changes the fields present in the body. An empty secret
+// replaces the secret with a new random one.
+type updateWebhookReq struct {
+	URL    *string   `json:"url,omitempty"`
+	Events *[]string `json:"events,omitempty"`
+	Active *bool     `json:"active,omitempty"`
+	Secret *string   `json:"secret,omitempty"`
+}
+
+func (s *Server) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req updateWebhookReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	u := models.WebhookUpdate{URL: req.URL, Active: req.Active, Secret: req.Secret}
+	if req.Events != nil {
+		u.Events = *req.Events
+		if u.Events == nil {
+			u.Events = []string{}
+		}
+	}
+	webhook, err := models.UpdateWebhook(r.Context(), s.db, id, u)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := models.DeleteWebhook(r.Context(), s.db, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhookDeliveries lists a webhook's deliveries, newest first, with the
+// log of their attempts. The status parameter selects pending, delivered or
+// failed ones.
+func (s *Server) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	page, err := parsePageParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	deliveries, next, err := models.GetWebhookDeliveries(r.Context(), s.db, id, models.DeliveryQuery{
+		Status: q.Get("status"),
+		Limit:  page.Limit,
+		Cursor: page.Cursor,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, deliveries)
+}
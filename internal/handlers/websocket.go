@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/iben12/counter-app/internal/models"
+)
+
+// WebSocket message types. Clients send subscribe, unsubscribe, increment and
+// decrement; the server answers each with an ack or an error carrying the same
+// ID, and pushes a change for every change of a subscribed counter.
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsIncrement   = "increment"
+	wsDecrement   = "decrement"
+	wsAck         = "ack"
+	wsError       = "error"
+	wsChange      = "change"
+)
+
+// maxWSMessageSize bounds the size of a message from a WebSocket client.
+const maxWSMessageSize = 64 << 10
+
+var upgrader = websocket.Upgrader{}
+
+// wsRequest is a message from a WebSocket client. Counters lists the counters
+// to subscribe to or unsubscribe from; CounterID, Delta, Note, Actor and At
+// describe an increment or decrement like the body of the HTTP endpoints.
+type wsRequest struct {
+	Type      string     `json:"type"`
+	ID        string     `json:"id,omitempty"`
+	Counters  []int64    `json:"counters,omitempty"`
+	CounterID int64      `json:"counter_id,omitempty"`
+	Delta     int64      `json:"delta,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	Actor     string     `json:"actor,omitempty"`
+	At        *time.Time `json:"at,omitempty"`
+}
+
+// wsMessage is a message to a WebSocket client. An ack of an increment or
+// decrement carries the confirmed Count and an ack of a subscribe the current
+// Counts of the counters. A change carries the Kind and the Event, or the new
+// period's Count for a rollover, like the event streams. An error carries the
+// Error text and the HTTP Status the same failure would have.
+type wsMessage struct {
+	Type      string         `json:"type"`
+	ID        string         `json:"id,omitempty"`
+	Kind      string         `json:"kind,omitempty"`
+	CounterID int64          `json:"counter_id,omitempty"`
+	Count     *models.Count  `json:"count,omitempty"`
+	Counts    []models.Count `json:"counts,omitempty"`
+	Event     *models.Event  `json:"event,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Status    int            `json:"status,omitempty"`
+}
+
+// wsConn is the state of one WebSocket connection.
+type wsConn struct {
+	s          *Server
+	conn       *websocket.Conn
+	sub        *models.Subscription
+	subscribed map[int64]bool
+}
+
+// serveWebSocket upgrades the request to a WebSocket connection that
+// subscribes to counters and changes them with JSON messages.
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has replied with an error already
+		return
+	}
+	ws := &wsConn{s: s, conn: conn, subscribed: map[int64]bool{}}
+	defer func() {
+		if ws.sub != nil {
+			ws.sub.Close()
+		}
+		conn.Close()
+	}()
+	ws.serve(r.Context())
+}
+
+// serve handles the client's messages in order and forwards the changes of
+// the subscribed counters until the connection ends. Only this goroutine
+// writes to the connection.
+func (ws *wsConn) serve(ctx context.Context) {
+	keepAlive := ws.s.cfg.StreamKeepAlive
+	ws.conn.SetReadLimit(maxWSMessageSize)
+	ws.conn.SetReadDeadline(time.Now().Add(2 * keepAlive))
+	ws.conn.SetPongHandler(func(string) error {
+		return ws.conn.SetReadDeadline(time.Now().Add(2 * keepAlive))
+	})
+
+	in := make(chan []byte)
+	go func() {
+		defer close(in)
+		for {
+			_, data, err := ws.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case in <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(keepAlive)
+	defer ping.Stop()
+	for {
+		var changes <-chan models.Change
+		if ws.sub != nil {
+			changes = ws.sub.C
+		}
+		select {
+		case data, ok := <-in:
+			if !ok {
+				return
+			}
+			if !ws.write(ws.handle(ctx, data)) {
+				return
+			}
+		case change, ok := <-changes:
+			if !ok {
+				// Fell behind or the feed failed; the client resubscribes
+				ws.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "change feed interrupted"),
+					time.Now().Add(keepAlive))
+				return
+			}
+			if !ws.subscribed[change.CounterID] {
+				continue
+			}
+			msg := wsMessage{Type: wsChange, Kind: change.Kind, CounterID: change.CounterID, Event: change.Event, Count: change.Count}
+			if !ws.write(msg) {
+				return
+			}
+		case <-ping.C:
+			if err := ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepAlive)); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// write sends msg, reporting whether the connection is still usable.
+func (ws *wsConn) write(msg wsMessage) bool {
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.s.cfg.StreamKeepAlive))
+	return ws.conn.WriteJSON(msg) == nil
+}
+
+// handle applies one client message and returns the reply.
+func (ws *wsConn) handle(ctx context.Context, data []byte) wsMessage {
+	var req wsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return wsMessage{Type: wsError, Error: "bad request", Status: http.StatusBadRequest}
+	}
+	var reply wsMessage
+	var err error
+	switch req.Type {
+	case wsSubscribe:
+		reply, err = ws.subscribe(ctx, req.Counters)
+	case wsUnsubscribe:
+		ws.unsubscribe(req.Counters)
+		reply = wsMessage{Type: wsAck}
+	case wsIncrement, wsDecrement:
+		reply, err = ws.mutate(ctx, req)
+	default:
+		err = fmt.Errorf("%w: unknown message type: %s", models.ErrInvalidInput, req.Type)
+	}
+	if err != nil {
+		reply = wsMessage{Type: wsError, Error: err.Error(), Status: errorStatus(err)}
+	}
+	reply.ID = req.ID
+	return reply
+}
+
+// subscribe adds counters to the subscription and returns their current
+// counts. Nothing is subscribed if any of them does not exist.
+func (ws *wsConn) subscribe(ctx context.Context, counters []int64) (wsMessage, error) {
+	if len(counters) == 0 {
+		return wsMessage{}, fmt.Errorf("%w: counters required", models.ErrInvalidInput)
+	}
+	// Subscribe before reading the counts, so no change in between is lost
+	var added []int64
+	for _, id := range counters {
+		if !ws.subscribed[id] {
+			added = append(added, id)
+		}
+	}
+	if ws.sub == nil {
+		sub, err := ws.s.broker.SubscribeCounters(ctx, added)
+		if err != nil {
+			return wsMessage{}, err
+		}
+		ws.sub = sub
+	} else {
+		ws.sub.Watch(added...)
+	}
+	counts := make([]models.Count, 0, len(counters))
+	for _, id := range counters {
+		cnt, err := models.GetOrCreateCurrentCount(ctx, ws.s.db, id)
+		if err != nil {
+			ws.sub.Unwatch(added...)
+			ws.unsubscribe(nil)
+			return wsMessage{}, fmt.Errorf("counter %d: %w", id, err)
+		}
+		counts = append(counts, *cnt)
+	}
+	for _, id := range counters {
+		ws.subscribed[id] = true
+	}
+	return wsMessage{Type: wsAck, Counts: counts}, nil
+}
+
+// unsubscribe removes counters from the subscription, leaving the change feed
+// once no counter is left.
+func (ws *wsConn) unsubscribe(counters []int64) {
+	for _, id := range counters {
+		delete(ws.subscribed, id)
+	}
+	if ws.sub != nil {
+		ws.sub.Unwatch(counters...)
+	}
+	if len(ws.subscribed) == 0 && ws.sub != nil {
+		ws.sub.Close()
+		ws.sub = nil
+	}
+}
+
+// mutate applies an increment or decrement and returns the confirmed count.
+// As over HTTP, the delta defaults to 1 and is negated for a decrement.
+func (ws *wsConn) mutate(ctx context.Context, req wsRequest) (wsMessage, error) {
+	delta := req.Delta
+	if delta == 0 {
+		delta = 1
+	}
+	if req.Type == wsDecrement {
+		delta = -delta
+	}
+	m, err := ws.s.mutation(incCountReq{Note: req.Note, Actor: req.Actor, At: req.At}, delta)
+	if err != nil {
+		return wsMessage{}, err
+	}
+	cnt, err := models.ApplyMutation(ctx, ws.s.db, req.CounterID, m)
+	if err != nil {
+		return wsMessage{}, err
+	}
+	return wsMessage{Type: wsAck, CounterID: req.CounterID, Count: cnt}, nil
+}
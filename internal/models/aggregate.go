@@ -0,0 +1,279 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iben12/counter-app/internal/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AggregateQuery selects the counts to roll up and how. Counts are assigned to
+// the Bucket, a frequency such as "1w" or "1M", containing their period start.
+// From is inclusive and To exclusive; From defaults to the earliest count and
+// To to now. Fns names the functions to compute: sum, avg, min, max and
+// percentiles such as p50 or p99.9. Fill counts the periods nobody touched
+// with the value a new period starts with, as CountQuery.Fill does, and is
+// limited to maxFilledPeriods periods.
+type AggregateQuery struct {
+	Bucket string
+	From   time.Time
+	To     time.Time
+	Fns    []string
+	Fill   bool
+}
+
+// Aggregation is the result of Aggregate.
+type Aggregation struct {
+	CounterID int64             `json:"counter_id"`
+	Bucket    string            `json:"bucket"`
+	Buckets   []AggregateBucket `json:"buckets"`
+}
+
+// AggregateBucket holds the function values of one bucket. Periods is the
+// number of counts in it; in an empty bucket sum is 0 and the other values are null.
+type AggregateBucket struct {
+	Start   string              `json:"start"`
+	End     string              `json:"end"`
+	Periods int                 `json:"periods"`
+	Values  map[string]*float64 `json:"values"`
+}
+
+// maxAggregateBuckets caps the number of buckets of one aggregation.
+const maxAggregateBuckets = MaxPageLimit
+
+// maxFilledPeriods caps the number of counter periods a filled aggregation
+// lays out.
+const maxFilledPeriods = 100 * maxAggregateBuckets
+
+// errTooManyBuckets is returned for aggregations of more than maxAggregateBuckets buckets.
+var errTooManyBuckets = fmt.Errorf("%w: more than %d buckets; use a larger bucket or a shorter range", ErrInvalidInput, maxAggregateBuckets)
+
+// sample is the value of one counter period, keyed by the period's start.
+type sample struct {
+	start time.Time
+	value int64
+}
+
+// Aggregate rolls a counter's counts up into buckets aligned in the counter's
+// timezone, week start and anchor, like its own periods.
+func Aggregate(ctx context.Context, pool *pgxpool.Pool, counterID int64, q AggregateQuery) (*Aggregation, error) {
+	if len(q.Fns) == 0 {
+		q.Fns = []string{"sum"}
+	}
+	for _, fn := range q.Fns {
+		if _, err := aggregateFn(fn); err != nil {
+			return nil, err
+		}
+	}
+	counter, err := getCounter(ctx, pool, counterID)
+	if err != nil {
+		return nil, err
+	}
+	if err := counter.loadHolidays(ctx, pool); err != nil {
+		return nil, err
+	}
+	s, err := db.NewScheduler(db.ScheduleFrequency, q.Bucket, counter.Timezone, counter.periodOptions())
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid bucket: %v", ErrInvalidInput, err)
+	}
+
+	now := time.Now().UTC()
+	to := q.To
+	if to.IsZero() {
+		to = now
+	}
+	var samples []sample
+	if q.Fill {
+		h, err := counterHistory(ctx, pool, counter, now)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkFill(ctx, h, s, q.From, to); err != nil {
+			return nil, err
+		}
+		periods, err := h.between(ctx, q.From, to, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range periods {
+			v := counter.initialValue()
+			if p.count != nil {
+				v = p.count.Value
+			}
+			samples = append(samples, sample{start: p.start, value: v})
+		}
+	} else {
+		rows, err := pool.Query(ctx,
+			`SELECT `+countColumns+` FROM counts
+			 WHERE counter_id = $1
+			   AND ($2::timestamptz IS NULL OR period_start >= $2)
+			   AND period_start < $3
+			 ORDER BY period_start`,
+			counterID, optionalTime(q.From), to)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			c, err := scanCount(rows)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, sample{start: c.periodStart, value: c.Value})
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	buckets, err := aggregate(s, samples, q.From, to, q.Fns)
+	if err != nil {
+		return nil, err
+	}
+	return &Aggregation{CounterID: counterID, Bucket: q.Bucket, Buckets: buckets}, nil
+}
+
+// checkFill rejects a filled aggregation of the buckets of s from from, or the
+// start of h if zero, to to before its periods are laid out, if it has more
+// than maxAggregateBuckets buckets or maxFilledPeriods periods.
+func checkFill(ctx context.Context, h *periodHistory, s db.Scheduler, from, to time.Time) error {
+	if from.IsZero() {
+		from = h.start
+		var first *time.Time
+		if err := h.q.QueryRow(ctx,
+			"SELECT min(period_start) FROM "+h.table+" WHERE "+h.key+" = $1", h.id).Scan(&first); err != nil {
+			return err
+		}
+		if first != nil && first.Before(from) {
+			from = *first
+		}
+	}
+	if !from.Before(to) {
+		return nil
+	}
+	start, err := db.PeriodStart(s, from)
+	if err != nil {
+		return err
+	}
+	// Buckets start at start and at the boundaries before to
+	n, err := db.CountPeriods(s, start, to.Add(-time.Nanosecond))
+	if err != nil {
+		return err
+	}
+	if n >= maxAggregateBuckets {
+		return errTooManyBuckets
+	}
+	if n, err = db.CountPeriods(h.s, from, to); err != nil {
+		return err
+	}
+	if n >= maxFilledPeriods {
+		return fmt.Errorf("%w: more than %d periods to fill; use a shorter range", ErrInvalidInput, maxFilledPeriods)
+	}
+	return nil
+}
+
+// aggregate groups samples, sorted by start, into the buckets of s that
+// overlap [from, to) and computes fns over each bucket. A zero from starts
+// with the bucket of the first sample.
+func aggregate(s db.Scheduler, samples []sample, from, to time.Time, fns []string) ([]AggregateBucket, error) {
+	i := 0
+	for i < len(samples) && samples[i].start.Before(from) {
+		i++
+	}
+	if from.IsZero() {
+		if len(samples) == 0 {
+			return []AggregateBucket{}, nil
+		}
+		from = samples[0].start
+	}
+	start, err := db.PeriodStart(s, from)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := []AggregateBucket{}
+	for start.Before(to) {
+		if len(buckets) == maxAggregateBuckets {
+			return nil, errTooManyBuckets
+		}
+		end, err := s.Next(start)
+		if err != nil {
+			return nil, err
+		}
+		var values []int64
+		for ; i < len(samples) && samples[i].start.Before(end) && samples[i].start.Before(to); i++ {
+			values = append(values, samples[i].value)
+		}
+		b := AggregateBucket{
+			Start:   start.UTC().Format(time.RFC3339),
+			End:     end.UTC().Format(time.RFC3339),
+			Periods: len(values),
+			Values:  map[string]*float64{},
+		}
+		for _, name := range fns {
+			fn, _ := aggregateFn(name)
+			b.Values[name] = fn(values)
+		}
+		buckets = append(buckets, b)
+		start = end
+	}
+	return buckets, nil
+}
+
+// aggregateFn returns the function computing name over a bucket's values.
+// Except for sum, the functions return nil for an empty bucket.
+func aggregateFn(name string) (func([]int64) *float64, error) {
+	switch name {
+	case "sum":
+		return func(vs []int64) *float64 {
+			var sum float64
+			for _, v := range vs {
+				sum += float64(v)
+			}
+			return &sum
+		}, nil
+	case "avg":
+		return func(vs []int64) *float64 {
+			if len(vs) == 0 {
+				return nil
+			}
+			var sum float64
+			for _, v := range vs {
+				sum += float64(v)
+			}
+			avg := sum / float64(len(vs))
+			return &avg
+		}, nil
+	case "min":
+		return func(vs []int64) *float64 { return percentile(vs, 0) }, nil
+	case "max":
+		return func(vs []int64) *float64 { return percentile(vs, 100) }, nil
+	}
+	if p, ok := strings.CutPrefix(name, "p"); ok {
+		if n, err := strconv.ParseFloat(p, 64); err == nil && n >= 0 && n <= 100 {
+			return func(vs []int64) *float64 { return percentile(vs, n) }, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown aggregate function: %s", ErrInvalidInput, name)
+}
+
+// percentile returns the p-th percentile of vs, interpolating linearly between
+// the closest ranks like PostgreSQL's percentile_cont, or nil for no values.
+func percentile(vs []int64, p float64) *float64 {
+	if len(vs) == 0 {
+		return nil
+	}
+	sorted := append([]int64(nil), vs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	v := float64(sorted[lo]) + (rank-float64(lo))*float64(sorted[hi]-sorted[lo])
+	return &v
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iben12/counter-app/internal/db"
+)
+
+// TestAggregateFns tests the aggregate functions, including interpolated percentiles.
+func TestAggregateFns(t *testing.T) {
+	values := []int64{4, 1, 3, 2}
+	tests := []struct {
+		fn   string
+		want float64
+	}{
+		{"sum", 10},
+		{"avg", 2.5},
+		{"min", 1},
+		{"max", 4},
+		{"p50", 2.5},
+		{"p25", 1.75},
+		{"p100", 4},
+	}
+	for _, tt := range tests {
+		fn, err := aggregateFn(tt.fn)
+		if err != nil {
+			t.Fatalf("aggregateFn(%q) error = %v", tt.fn, err)
+		}
+		if got := fn(values); got == nil || *got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.fn, got, tt.want)
+		}
+		if got := fn(nil); (got == nil) != (tt.fn != "sum") {
+			t.Errorf("%s of no values = %v", tt.fn, got)
+		}
+	}
+	for _, name := range []string{"median", "p101", "p", "px"} {
+		if _, err := aggregateFn(name); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("aggregateFn(%q) expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+}
+
+// TestAggregateBuckets tests that daily samples are grouped into weeks in the
+// counter's timezone, including empty weeks.
+func TestAggregateBuckets(t *testing.T) {
+	budapest, err := time.LoadLocation("Europe/Budapest")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %v", err)
+	}
+	s, err := db.NewScheduler(db.ScheduleFrequency, "1w", "Europe/Budapest", db.DefaultPeriodOptions)
+	if err != nil {
+		t.Fatalf("failed to create scheduler: %v", err)
+	}
+	day := func(d int) time.Time { return time.Date(2025, 11, d, 0, 0, 0, 0, budapest) }
+	samples := []sample{
+		{day(3), 1}, {day(5), 3}, // week of Monday the 3rd
+		{day(16), 5}, // Sunday, still the week of the 10th
+		{day(24), 2}, // week of the 24th; the 17th is empty
+	}
+
+	buckets, err := aggregate(s, samples, day(4), day(26), []string{"sum", "max"})
+	if err != nil {
+		t.Fatalf("aggregate failed: %v", err)
+	}
+	want := []struct {
+		start   time.Time
+		periods int
+		sum     float64
+	}{
+		{day(3), 1, 3}, // from excludes the 3rd
+		{day(10), 1, 5},
+		{day(17), 0, 0},
+		{day(24), 1, 2},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("expected %d buckets, got %d: %+v", len(want), len(buckets), buckets)
+	}
+	for i, w := range want {
+		b := buckets[i]
+		if b.Start != w.start.UTC().Format(time.RFC3339) || b.Periods != w.periods || *b.Values["sum"] != w.sum {
+			t.Errorf("bucket %d: expected start %v, %d periods and sum %v, got %+v", i, w.start.UTC(), w.periods, w.sum, b)
+		}
+	}
+	if buckets[2].Values["max"] != nil {
+		t.Errorf("expected no max in an empty bucket, got %v", *buckets[2].Values["max"])
+	}
+
+	minutes, _ := db.NewScheduler(db.ScheduleFrequency, "1m", "UTC", db.DefaultPeriodOptions)
+	if _, err := aggregate(minutes, nil, day(1), day(30), []string{"sum"}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for too many buckets, got %v", err)
+	}
+}
+
+// TestAggregate tests a monthly total of a daily counter with and without filled gaps.
+func TestAggregate(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounterWithSettings(ctx, pool, "aggregate-test", CounterSettings{
+		Min: NullableInt{Set: true, Value: int64Ptr(1)},
+	})
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "UPDATE counters SET created_at = now() - interval '2 days' WHERE id = $1", counter.ID); err != nil {
+		t.Fatalf("failed to backdate counter: %v", err)
+	}
+	IncrementCurrentCount(ctx, pool, counter.ID, 4) // 1 + 4
+
+	agg, err := Aggregate(ctx, pool, counter.ID, AggregateQuery{Bucket: "1d", Fns: []string{"sum"}})
+	if err != nil {
+		t.Fatalf("failed to aggregate: %v", err)
+	}
+	if len(agg.Buckets) != 1 || *agg.Buckets[0].Values["sum"] != 5 {
+		t.Errorf("expected one daily bucket with sum 5, got %+v", agg.Buckets)
+	}
+
+	agg, err = Aggregate(ctx, pool, counter.ID, AggregateQuery{Bucket: "1d", Fns: []string{"sum", "min"}, Fill: true})
+	if err != nil {
+		t.Fatalf("failed to aggregate: %v", err)
+	}
+	if len(agg.Buckets) != 3 || *agg.Buckets[0].Values["sum"] != 1 || *agg.Buckets[2].Values["sum"] != 5 {
+		t.Errorf("expected three daily buckets starting at min 1, got %+v", agg.Buckets)
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	agg, err = Aggregate(ctx, pool, counter.ID, AggregateQuery{Bucket: "1d", From: today.AddDate(0, 0, -1), To: today, Fill: true})
+	if err != nil {
+		t.Fatalf("failed to aggregate: %v", err)
+	}
+	if len(agg.Buckets) != 1 || agg.Buckets[0].Periods != 1 || *agg.Buckets[0].Values["sum"] != 1 {
+		t.Errorf("expected only yesterday's filled bucket, got %+v", agg.Buckets)
+	}
+	agg, err = Aggregate(ctx, pool, counter.ID, AggregateQuery{Bucket: "1d", From: today.AddDate(0, 0, -1), To: today})
+	if err != nil {
+		t.Fatalf("failed to aggregate: %v", err)
+	}
+	if len(agg.Buckets) != 1 || agg.Buckets[0].Periods != 0 {
+		t.Errorf("expected yesterday's bucket without counts, got %+v", agg.Buckets)
+	}
+
+	// Two days of minutes are too many buckets, found before any period is laid out
+	if _, err := Aggregate(ctx, pool, counter.ID, AggregateQuery{Bucket: "1m", Fill: true}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for too many filled buckets, got %v", err)
+	}
+
+	if _, err := Aggregate(ctx, pool, counter.ID, AggregateQuery{Bucket: "1x"}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for an invalid bucket, got %v", err)
+	}
+	if _, err := Aggregate(ctx, pool, 999999, AggregateQuery{Bucket: "1d"}); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,579 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Alert rule kinds.
+const (
+	// AlertThreshold fires while the running period's value compares to the
+	// rule's threshold with its operator, e.g. value >= 100.
+	AlertThreshold = "threshold"
+	// AlertBelowGoal fires when a period ended below the rule's threshold, or
+	// the counter's goal without one, until the running period reaches it.
+	AlertBelowGoal = "below_goal"
+	// AlertInactivity fires when the counter was not incremented for the
+	// rule's duration.
+	AlertInactivity = "inactivity"
+)
+
+// Alert states and notification events.
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert channel types.
+const (
+	ChannelLog     = "log"
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+)
+
+// AlertChannelTypes lists the channel types alert rules can notify.
+var AlertChannelTypes = []string{ChannelLog, ChannelWebhook, ChannelEmail}
+
+// Notification statuses.
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// AlertChannel is where a rule's notifications go: the server log, a POST to
+// URL, or an email to To.
+type AlertChannel struct {
+	Type string   `json:"type"`
+	URL  string   `json:"url,omitempty"`
+	To   []string `json:"to,omitempty"`
+}
+
+// validate checks the settings a channel of its type needs.
+func (ch AlertChannel) validate() error {
+	switch ch.Type {
+	case ChannelLog:
+	case ChannelWebhook:
+		return validateWebhookURL(ch.URL)
+	case ChannelEmail:
+		if len(ch.To) == 0 {
+			return fmt.Errorf("%w: email channel needs recipients", ErrInvalidInput)
+		}
+		for _, to := range ch.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("%w: invalid email address: %s", ErrInvalidInput, to)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown channel type: %s", ErrInvalidInput, ch.Type)
+	}
+	return nil
+}
+
+// AlertRule watches a counter for a condition; see the Alert kinds. Operator
+// and Threshold apply to threshold rules, Threshold optionally to below-goal
+// rules, and Duration (a Go duration such as "2h") to inactivity rules.
+// Firing reports whether the rule has a firing alert.
+type AlertRule struct {
+	ID        int64          `json:"id"`
+	CounterID int64          `json:"counter_id"`
+	Name      string         `json:"name"`
+	Kind      string         `json:"kind"`
+	Operator  string         `json:"operator,omitempty"`
+	Threshold *int64         `json:"threshold"`
+	Duration  string         `json:"duration,omitempty"`
+	Channels  []AlertChannel `json:"channels"`
+	Firing    bool           `json:"firing"`
+	CreatedAt string         `json:"created_at"`
+
+	createdAt time.Time
+}
+
+// alertRuleColumns lists the alert_rules columns, of the table aliased r, read
+// by scanAlertRule, in order.
+const alertRuleColumns = "r.id, r.counter_id, r.name, r.kind, r.operator, r.threshold, r.duration, r.channels, " +
+	"EXISTS (SELECT 1 FROM alerts a WHERE a.rule_id = r.id AND a.state = 'firing'), r.created_at"
+
+// scanAlertRule scans a single alert_rules row selected with alertRuleColumns.
+func scanAlertRule(row pgx.Row) (*AlertRule, error) {
+	var r AlertRule
+	if err := row.Scan(&r.ID, &r.CounterID, &r.Name, &r.Kind, &r.Operator, &r.Threshold,
+		&r.Duration, &r.Channels, &r.Firing, &r.createdAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	r.CreatedAt = r.createdAt.UTC().Format(time.RFC3339Nano)
+	return &r, nil
+}
+
+// AlertRuleSettings holds the settings of a new alert rule. Operator defaults
+// to ">=" and Channels to the server log.
+type AlertRuleSettings struct {
+	Name      string
+	Kind      string
+	Operator  string
+	Threshold *int64
+	Duration  string
+	Channels  []AlertChannel
+}
+
+// CreateAlertRule adds an alert rule to a counter. The rule is first
+// evaluated with the next change of the counter or run of the scheduler.
+func CreateAlertRule(ctx context.Context, pool *pgxpool.Pool, counterID int64, s AlertRuleSettings) (*AlertRule, error) {
+	counter, err := GetCounterByID(ctx, pool, counterID)
+	if err != nil {
+		return nil, err
+	}
+	switch s.Kind {
+	case AlertThreshold:
+		if s.Operator == "" {
+			s.Operator = ">="
+		}
+		if _, err := compare(0, s.Operator, 0); err != nil {
+			return nil, err
+		}
+		if s.Threshold == nil {
+			return nil, fmt.Errorf("%w: threshold required", ErrInvalidInput)
+		}
+	case AlertBelowGoal:
+		if s.Threshold == nil && counter.Goal == nil {
+			return nil, fmt.Errorf("%w: threshold required for a counter without a goal", ErrInvalidInput)
+		}
+		s.Operator = ""
+	case AlertInactivity:
+		d, err := time.ParseDuration(s.Duration)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: duration must be a positive duration such as 2h", ErrInvalidInput)
+		}
+		s.Operator, s.Threshold = "", nil
+	default:
+		return nil, fmt.Errorf("%w: unknown alert kind: %s", ErrInvalidInput, s.Kind)
+	}
+	if s.Kind != AlertInactivity {
+		s.Duration = ""
+	}
+	if len(s.Channels) == 0 {
+		s.Channels = []AlertChannel{{Type: ChannelLog}}
+	}
+	for _, ch := range s.Channels {
+		if err := ch.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return scanAlertRule(pool.QueryRow(ctx,
+		`INSERT INTO alert_rules AS r (counter_id, name, kind, operator, threshold, duration, channels)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING `+alertRuleColumns,
+		counterID, s.Name, s.Kind, s.Operator, s.Threshold, s.Duration, s.Channels))
+}
+
+// compare applies a threshold operator.
+func compare(value int64, op string, threshold int64) (bool, error) {
+	switch op {
+	case ">=":
+		return value >= threshold, nil
+	case ">":
+		return value > threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	case "<":
+		return value < threshold, nil
+	}
+	return false, fmt.Errorf("%w: operator must be >=, >, <= or <", ErrInvalidInput)
+}
+
+// GetAlertRules lists a counter's alert rules in creation order.
+func GetAlertRules(ctx context.Context, pool *pgxpool.Pool, counterID int64) ([]AlertRule, error) {
+	if _, err := GetCounterByID(ctx, pool, counterID); err != nil {
+		return nil, err
+	}
+	return alertRules(ctx, pool, "SELECT "+alertRuleColumns+" FROM alert_rules r WHERE r.counter_id = $1 ORDER BY r.id", counterID)
+}
+
+// alertRules runs a query selecting alertRuleColumns and scans the rules.
+func alertRules(ctx context.Context, q querier, sql string, args ...any) ([]AlertRule, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *r)
+	}
+	return rules, rows.Err()
+}
+
+// GetAlertRule returns one of a counter's alert rules.
+func GetAlertRule(ctx context.Context, pool *pgxpool.Pool, counterID, id int64) (*AlertRule, error) {
+	return scanAlertRule(pool.QueryRow(ctx,
+		"SELECT "+alertRuleColumns+" FROM alert_rules r WHERE r.id = $1 AND r.counter_id = $2",
+		id, counterID))
+}
+
+// DeleteAlertRule removes an alert rule together with its alerts.
+func DeleteAlertRule(ctx context.Context, pool *pgxpool.Pool, counterID, id int64) error {
+	tag, err := pool.Exec(ctx, "DELETE FROM alert_rules WHERE id = $1 AND counter_id = $2", id, counterID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Alert is one breach of a rule: firing from FiredAt until the condition no
+// longer holds, then resolved. Value is the value that breached the rule.
+type Alert struct {
+	ID         int64   `json:"id"`
+	RuleID     int64   `json:"rule_id"`
+	CounterID  int64   `json:"counter_id"`
+	State      string  `json:"state"`
+	Value      int64   `json:"value"`
+	Message    string  `json:"message"`
+	FiredAt    string  `json:"fired_at"`
+	ResolvedAt *string `json:"resolved_at,omitempty"`
+
+	firedAt time.Time
+}
+
+// alertColumns lists the alerts columns read by scanAlert, in order.
+const alertColumns = "id, rule_id, counter_id, state, value, message, fired_at, resolved_at"
+
+// scanAlert scans a single alerts row selected with alertColumns.
+func scanAlert(row pgx.Row) (*Alert, error) {
+	var a Alert
+	var resolvedAt *time.Time
+	if err := row.Scan(&a.ID, &a.RuleID, &a.CounterID, &a.State, &a.Value, &a.Message, &a.firedAt, &resolvedAt); err != nil {
+		return nil, err
+	}
+	a.FiredAt = a.firedAt.UTC().Format(time.RFC3339Nano)
+	if resolvedAt != nil {
+		s := resolvedAt.UTC().Format(time.RFC3339Nano)
+		a.ResolvedAt = &s
+	}
+	return &a, nil
+}
+
+// AlertQuery filters and pages a counter's alerts, newest first. An empty
+// State selects all of them. Cursor continues a previous page.
+type AlertQuery struct {
+	State  string
+	Limit  int
+	Cursor *Cursor
+}
+
+// GetAlerts returns a page of a counter's alerts and the cursor of the next
+// page, which is nil on the last page.
+func GetAlerts(ctx context.Context, pool *pgxpool.Pool, counterID int64, q AlertQuery) ([]Alert, *Cursor, error) {
+	switch q.State {
+	case "", AlertFiring, AlertResolved:
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown alert state: %s", ErrInvalidInput, q.State)
+	}
+	if _, err := GetCounterByID(ctx, pool, counterID); err != nil {
+		return nil, nil, err
+	}
+	limit := pageLimit(q.Limit)
+	var cursorTime *time.Time
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorTime, cursorID = &q.Cursor.Time, q.Cursor.ID
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	rows, err := pool.Query(ctx,
+		`SELECT `+alertColumns+` FROM alerts
+		 WHERE counter_id = $1 AND ($2 = '' OR state = $2)
+		   AND ($3::timestamptz IS NULL OR (fired_at, id) < ($3, $4))
+		 ORDER BY fired_at DESC, id DESC
+		 LIMIT $5`,
+		counterID, q.State, cursorTime, cursorID, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		alerts = append(alerts, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(alerts) <= limit {
+		return alerts, nil, nil
+	}
+	alerts = alerts[:limit]
+	last := alerts[limit-1]
+	return alerts, &Cursor{Time: last.firedAt, ID: last.ID}, nil
+}
+
+// AlertNotification is the message sent to a rule's channels when an alert
+// fires or resolves. Value is the counter's value at that time.
+type AlertNotification struct {
+	Event       string `json:"event"`
+	Alert       Alert  `json:"alert"`
+	RuleName    string `json:"rule_name,omitempty"`
+	RuleKind    string `json:"rule_kind"`
+	CounterID   int64  `json:"counter_id"`
+	CounterName string `json:"counter_name"`
+	Value       int64  `json:"value"`
+	At          string `json:"at"`
+}
+
+// check reports whether the rule's condition holds for counter at now, given
+// the running period's count, with the value and a description of the breach.
+func (r *AlertRule) check(ctx context.Context, q querier, counter *Counter, current *Count, now time.Time) (bool, int64, string, error) {
+	switch r.Kind {
+	case AlertThreshold:
+		breached, err := compare(current.Value, r.Operator, *r.Threshold)
+		msg := fmt.Sprintf("value %d %s %d in the current period", current.Value, r.Operator, *r.Threshold)
+		return breached, current.Value, msg, err
+	case AlertBelowGoal:
+		target := r.Threshold
+		if target == nil {
+			target = counter.Goal
+		}
+		// Only periods that ended while the rule existed are judged
+		if target == nil || !current.periodStart.After(r.createdAt) {
+			return false, current.Value, "", nil
+		}
+		previous, err := peekCount(ctx, q, counter, current.periodStart.Add(-time.Nanosecond))
+		if err != nil {
+			return false, current.Value, "", nil
+		}
+		breached := previous.Value < *target && current.Value < *target
+		msg := fmt.Sprintf("value %d ended below the goal of %d in the period ending %s", previous.Value, *target, previous.Expiry)
+		return breached, previous.Value, msg, nil
+	case AlertInactivity:
+		d, err := time.ParseDuration(r.Duration)
+		if err != nil {
+			return false, current.Value, "", err
+		}
+		var last *time.Time
+		if err := q.QueryRow(ctx,
+			"SELECT max(created_at) FROM events WHERE counter_id = $1 AND kind = $2",
+			counter.ID, EventIncrement).Scan(&last); err != nil {
+			return false, current.Value, "", err
+		}
+		since := r.createdAt
+		if last != nil && last.After(since) {
+			since = *last
+		}
+		msg := fmt.Sprintf("no increments for %s", r.Duration)
+		return now.Sub(since) >= d, current.Value, msg, nil
+	}
+	return false, current.Value, "", fmt.Errorf("unknown alert kind: %s", r.Kind)
+}
+
+// evaluateAlertRules checks the counter's rules at now and fires or resolves
+// their alerts, queueing the notifications, in the caller's transaction. The
+// counter must be locked; the rules are locked too, so concurrent
+// evaluations agree on each rule's state.
+func evaluateAlertRules(ctx context.Context, tx pgx.Tx, counter *Counter, now time.Time) error {
+	rules, err := alertRules(ctx, tx,
+		"SELECT "+alertRuleColumns+" FROM alert_rules r WHERE r.counter_id = $1 ORDER BY r.id FOR UPDATE",
+		counter.ID)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	if err := counter.loadHolidays(ctx, tx); err != nil {
+		return err
+	}
+	current, err := peekCount(ctx, tx, counter, now)
+	if err != nil {
+		// A schedule without a running period has nothing to check
+		return nil
+	}
+	for i := range rules {
+		r := &rules[i]
+		breached, value, msg, err := r.check(ctx, tx, counter, current, now)
+		if err != nil {
+			return err
+		}
+		var a *Alert
+		switch {
+		case breached && !r.Firing:
+			a, err = scanAlert(tx.QueryRow(ctx,
+				`INSERT INTO alerts (rule_id, counter_id, value, message) VALUES ($1, $2, $3, $4)
+				 RETURNING `+alertColumns,
+				r.ID, counter.ID, value, msg))
+		case !breached && r.Firing:
+			a, err = scanAlert(tx.QueryRow(ctx,
+				`UPDATE alerts SET state = 'resolved', resolved_at = now()
+				 WHERE rule_id = $1 AND state = 'firing' RETURNING `+alertColumns,
+				r.ID))
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n := AlertNotification{
+			Event:       a.State,
+			Alert:       *a,
+			RuleName:    r.Name,
+			RuleKind:    r.Kind,
+			CounterID:   counter.ID,
+			CounterName: counter.Name,
+			Value:       current.Value,
+			At:          now.UTC().Format(time.RFC3339Nano),
+		}
+		if err := enqueueAlertNotifications(ctx, tx, r.Channels, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueAlertNotifications queues n for each of channels.
+func enqueueAlertNotifications(ctx context.Context, tx pgx.Tx, channels []AlertChannel, n AlertNotification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	for _, ch := range channels {
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO alert_notifications (alert_id, channel, payload) VALUES ($1, $2, $3)",
+			n.Alert.ID, ch, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvaluateAlerts evaluates the rules of every active counter that has any,
+// for the conditions that arise without a change to the counter: a period
+// ending below its goal, inactivity and a new period resetting the value.
+func EvaluateAlerts(ctx context.Context, pool *pgxpool.Pool) error {
+	rows, err := pool.Query(ctx,
+		`SELECT DISTINCT r.counter_id FROM alert_rules r JOIN counters c ON c.id = r.counter_id
+		 WHERE c.archived_at IS NULL ORDER BY r.counter_id`)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := evaluateCounterAlerts(ctx, pool, id); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluateCounterAlerts evaluates the rules of one counter in a transaction of
+// its own.
+func evaluateCounterAlerts(ctx context.Context, pool *pgxpool.Pool, counterID int64) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	counter, err := lockCounter(ctx, tx, counterID)
+	if err != nil {
+		return err
+	}
+	if err := evaluateAlertRules(ctx, tx, counter, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// PendingNotification is a notification taken for sending.
+type PendingNotification struct {
+	ID           int64
+	Attempts     int
+	Channel      AlertChannel
+	Notification AlertNotification
+}
+
+// ClaimAlertNotifications takes up to limit due notifications, oldest first,
+// and holds them back from other schedulers for lease.
+func ClaimAlertNotifications(ctx context.Context, pool *pgxpool.Pool, limit int, lease time.Duration) ([]PendingNotification, error) {
+	rows, err := pool.Query(ctx,
+		`UPDATE alert_notifications SET next_attempt_at = now() + $2 * interval '1 microsecond'
+		 WHERE id IN (
+		   SELECT id FROM alert_notifications
+		   WHERE status = 'pending' AND next_attempt_at <= now()
+		   ORDER BY next_attempt_at, id
+		   LIMIT $1
+		   FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING id, attempts, channel, payload`,
+		limit, lease.Microseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []PendingNotification
+	for rows.Next() {
+		var p PendingNotification
+		if err := rows.Scan(&p.ID, &p.Attempts, &p.Channel, &p.Notification); err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+	return pending, rows.Err()
+}
+
+// RecordAlertNotification records an attempt to send a notification. A nil
+// sendErr marks it sent; otherwise it is retried at retryAt, or fails for
+// good when retryAt is zero.
+func RecordAlertNotification(ctx context.Context, pool *pgxpool.Pool, id int64, sendErr error, retryAt time.Time) error {
+	status, errText := NotificationSent, ""
+	if sendErr != nil {
+		status, errText = NotificationPending, sendErr.Error()
+		if retryAt.IsZero() {
+			status = NotificationFailed
+		}
+	}
+	tag, err := pool.Exec(ctx,
+		`UPDATE alert_notifications SET attempts = attempts + 1, status = $2, last_error = $3,
+		   next_attempt_at = COALESCE($4, next_attempt_at),
+		   sent_at = CASE WHEN $2 = 'sent' THEN now() END
+		 WHERE id = $1`,
+		id, status, errText, optionalTime(retryAt))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
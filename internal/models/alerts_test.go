@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestAlertRules tests that alerts fire once per breach and resolve when the
+// condition clears, on mutation and on scheduled evaluation.
+func TestAlertRules(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounterWithSettings(ctx, pool, "alerts-test", CounterSettings{
+		Goal: NullableInt{Set: true, Value: int64Ptr(5)},
+	})
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	invalid := []AlertRuleSettings{
+		{Kind: "sometimes"},
+		{Kind: AlertThreshold},
+		{Kind: AlertThreshold, Operator: "!=", Threshold: int64Ptr(1)},
+		{Kind: AlertInactivity, Duration: "soon"},
+		{Kind: AlertThreshold, Threshold: int64Ptr(1), Channels: []AlertChannel{{Type: ChannelEmail}}},
+		{Kind: AlertThreshold, Threshold: int64Ptr(1), Channels: []AlertChannel{{Type: ChannelWebhook, URL: "nowhere"}}},
+	}
+	for _, s := range invalid {
+		if _, err := CreateAlertRule(ctx, pool, counter.ID, s); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("expected ErrInvalidInput for %+v, got %v", s, err)
+		}
+	}
+
+	high, err := CreateAlertRule(ctx, pool, counter.ID, AlertRuleSettings{Name: "high", Kind: AlertThreshold, Threshold: int64Ptr(3)})
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	if high.Operator != ">=" || len(high.Channels) != 1 || high.Channels[0].Type != ChannelLog {
+		t.Errorf("expected the default operator and channel, got %+v", high)
+	}
+
+	alerts := func(state string) []Alert {
+		list, _, err := GetAlerts(ctx, pool, counter.ID, AlertQuery{State: state})
+		if err != nil {
+			t.Fatalf("failed to list alerts: %v", err)
+		}
+		return list
+	}
+	notifications := func() int {
+		var n int
+		if err := pool.QueryRow(ctx, "SELECT count(*) FROM alert_notifications").Scan(&n); err != nil {
+			t.Fatalf("failed to count notifications: %v", err)
+		}
+		return n
+	}
+
+	steps := []struct {
+		name          string
+		delta         int64
+		firing        int
+		resolved      int
+		notifications int
+	}{
+		{"below", 2, 0, 0, 0},
+		{"breach", 2, 1, 0, 1},
+		{"still breached", 1, 1, 0, 1},
+		{"clear", -4, 0, 1, 2},
+		{"breach again", 3, 1, 1, 3},
+	}
+	for _, step := range steps {
+		if _, err := IncrementCurrentCount(ctx, pool, counter.ID, step.delta); err != nil {
+			t.Fatalf("%s: failed to change count: %v", step.name, err)
+		}
+		if got := len(alerts(AlertFiring)); got != step.firing {
+			t.Errorf("%s: expected %d firing alerts, got %d", step.name, step.firing, got)
+		}
+		if got := len(alerts(AlertResolved)); got != step.resolved {
+			t.Errorf("%s: expected %d resolved alerts, got %d", step.name, step.resolved, got)
+		}
+		if got := notifications(); got != step.notifications {
+			t.Errorf("%s: expected %d notifications, got %d", step.name, step.notifications, got)
+		}
+	}
+	rule, err := GetAlertRule(ctx, pool, counter.ID, high.ID)
+	if err != nil {
+		t.Fatalf("failed to get rule: %v", err)
+	}
+	if !rule.Firing {
+		t.Error("expected the rule to be firing")
+	}
+
+	// Undoing the breaching increment resolves the alert
+	if _, err := Undo(ctx, pool, counter.ID, time.Minute); err != nil {
+		t.Fatalf("failed to undo: %v", err)
+	}
+	if got := len(alerts(AlertFiring)); got != 0 {
+		t.Errorf("expected undo to resolve the alert, got %d firing", got)
+	}
+
+	// Inactivity is only noticed by the scheduled evaluation
+	idle, err := CreateAlertRule(ctx, pool, counter.ID, AlertRuleSettings{Kind: AlertInactivity, Duration: "2h"})
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	if err := EvaluateAlerts(ctx, pool); err != nil {
+		t.Fatalf("failed to evaluate alerts: %v", err)
+	}
+	if got := len(alerts(AlertFiring)); got != 0 {
+		t.Errorf("expected no alert for recent activity, got %d", got)
+	}
+	for _, sql := range []string{
+		"UPDATE events SET created_at = now() - interval '3 hours' WHERE counter_id = $1",
+		"UPDATE alert_rules SET created_at = now() - interval '3 hours' WHERE counter_id = $1",
+	} {
+		if _, err := pool.Exec(ctx, sql, counter.ID); err != nil {
+			t.Fatalf("failed to backdate: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := EvaluateAlerts(ctx, pool); err != nil {
+			t.Fatalf("failed to evaluate alerts: %v", err)
+		}
+	}
+	firing := alerts(AlertFiring)
+	if len(firing) != 1 || firing[0].RuleID != idle.ID {
+		t.Fatalf("expected one inactivity alert, got %+v", firing)
+	}
+	if _, err := IncrementCurrentCount(ctx, pool, counter.ID, 1); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	for _, a := range alerts(AlertFiring) {
+		if a.RuleID == idle.ID {
+			t.Error("expected an increment to resolve the inactivity alert")
+		}
+	}
+
+	if err := DeleteAlertRule(ctx, pool, counter.ID, idle.ID); err != nil {
+		t.Fatalf("failed to delete rule: %v", err)
+	}
+	if _, err := GetAlertRule(ctx, pool, counter.ID, idle.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a deleted rule, got %v", err)
+	}
+}
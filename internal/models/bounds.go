@@ -0,0 +1,107 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrOutOfRange is returned when a mutation would move a count outside the
+// bounds of a counter in reject mode.
+var ErrOutOfRange = errors.New("value out of range")
+
+// Bound modes decide what happens to mutations that leave a counter's range.
+const (
+	BoundClamp  = "clamp"
+	BoundReject = "reject"
+)
+
+// NullableInt is an optional integer setting that may also be set to null.
+// Set reports whether the field was given at all; Value is nil for null.
+type NullableInt struct {
+	Set   bool
+	Value *int64
+}
+
+// UnmarshalJSON records that the field was present, so an explicit null can
+// be told apart from a missing field.
+func (n *NullableInt) UnmarshalJSON(b []byte) error {
+	n.Set = true
+	n.Value = nil
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	var v int64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	n.Value = &v
+	return nil
+}
+
+// or returns the value of n if it was set, or def otherwise.
+func (n NullableInt) or(def *int64) *int64 {
+	if n.Set {
+		return n.Value
+	}
+	return def
+}
+
+// validateBounds checks the counter's range, goal and bound mode.
+func (c *Counter) validateBounds() error {
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		return fmt.Errorf("%w: min must not be greater than max", ErrInvalidInput)
+	}
+	if c.Goal != nil && *c.Goal <= 0 {
+		return fmt.Errorf("%w: goal must be positive", ErrInvalidInput)
+	}
+	if c.BoundMode != BoundClamp && c.BoundMode != BoundReject {
+		return fmt.Errorf("%w: bound mode must be %s or %s", ErrInvalidInput, BoundClamp, BoundReject)
+	}
+	return nil
+}
+
+// inRange reports whether value lies within the counter's bounds.
+func (c *Counter) inRange(value int64) bool {
+	return (c.Min == nil || value >= *c.Min) && (c.Max == nil || value <= *c.Max)
+}
+
+// clamp moves value to the nearest bound of the counter if it lies outside.
+func (c *Counter) clamp(value int64) int64 {
+	if c.Min != nil && value < *c.Min {
+		return *c.Min
+	}
+	if c.Max != nil && value > *c.Max {
+		return *c.Max
+	}
+	return value
+}
+
+// bound applies the counter's bound mode to the result of a relative change:
+// it is clamped into the range, or rejected with ErrOutOfRange.
+func (c *Counter) bound(value int64) (int64, error) {
+	if c.inRange(value) {
+		return value, nil
+	}
+	if c.BoundMode == BoundReject {
+		return 0, fmt.Errorf("%w: %d is outside the counter's bounds", ErrOutOfRange, value)
+	}
+	return c.clamp(value), nil
+}
+
+// initialValue is the value a new period starts with: zero, moved into the
+// counter's range.
+func (c *Counter) initialValue() int64 {
+	return c.clamp(0)
+}
+
+// setGoal fills in the goal of the count's counter and the progress toward it.
+func (cnt *Count) setGoal(goal *int64) {
+	cnt.Goal = goal
+	cnt.Progress = nil
+	if goal != nil && *goal > 0 {
+		p := float64(cnt.Value) / float64(*goal)
+		cnt.Progress = &p
+	}
+}
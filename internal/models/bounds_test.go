@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func int64Ptr(v int64) *int64 { return &v }
+
+// TestNullableIntUnmarshal tests that a missing field, null and a number are told apart.
+func TestNullableIntUnmarshal(t *testing.T) {
+	var req struct {
+		Min  NullableInt `json:"min"`
+		Max  NullableInt `json:"max"`
+		Goal NullableInt `json:"goal"`
+	}
+	if err := json.Unmarshal([]byte(`{"min": null, "max": 5}`), &req); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if !req.Min.Set || req.Min.Value != nil {
+		t.Errorf("expected min set to null, got %+v", req.Min)
+	}
+	if !req.Max.Set || req.Max.Value == nil || *req.Max.Value != 5 {
+		t.Errorf("expected max set to 5, got %+v", req.Max)
+	}
+	if req.Goal.Set {
+		t.Errorf("expected goal unset, got %+v", req.Goal)
+	}
+	if err := json.Unmarshal([]byte(`{"min": "x"}`), &req); err == nil {
+		t.Error("expected error for a non-numeric min")
+	}
+}
+
+// TestCounterBound tests clamping and rejecting values outside a counter's range.
+func TestCounterBound(t *testing.T) {
+	tests := []struct {
+		name    string
+		counter Counter
+		value   int64
+		want    int64
+		wantErr bool
+	}{
+		{"in range", Counter{Min: int64Ptr(0), Max: int64Ptr(10), BoundMode: BoundClamp}, 5, 5, false},
+		{"clamp below", Counter{Min: int64Ptr(0), BoundMode: BoundClamp}, -3, 0, false},
+		{"clamp above", Counter{Min: int64Ptr(0), Max: int64Ptr(10), BoundMode: BoundClamp}, 12, 10, false},
+		{"unbounded", Counter{BoundMode: BoundClamp}, -30, -30, false},
+		{"reject below", Counter{Min: int64Ptr(-5), BoundMode: BoundReject}, -6, 0, true},
+		{"reject above", Counter{Max: int64Ptr(3), BoundMode: BoundReject}, 4, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.counter.bound(tt.value)
+			if tt.wantErr {
+				if !errors.Is(err, ErrOutOfRange) {
+					t.Errorf("expected ErrOutOfRange, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestCounterBoundsAndGoal tests negative balances, reject mode and goal progress.
+func TestCounterBoundsAndGoal(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	balance, err := CreateCounterWithSettings(ctx, pool, "bounds-balance", CounterSettings{
+		Min: NullableInt{Set: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if balance.Min != nil {
+		t.Errorf("expected no lower bound, got %d", *balance.Min)
+	}
+	cnt, err := IncrementCurrentCount(ctx, pool, balance.ID, -4)
+	if err != nil {
+		t.Fatalf("failed to decrement: %v", err)
+	}
+	if cnt.Value != -4 {
+		t.Errorf("expected value -4 without a lower bound, got %d", cnt.Value)
+	}
+
+	capped, err := CreateCounterWithSettings(ctx, pool, "bounds-capped", CounterSettings{
+		Max:       NullableInt{Set: true, Value: int64Ptr(5)},
+		Goal:      NullableInt{Set: true, Value: int64Ptr(4)},
+		BoundMode: BoundReject,
+	})
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if capped.Min == nil || *capped.Min != 0 {
+		t.Errorf("expected default lower bound 0, got %v", capped.Min)
+	}
+	cnt, err = IncrementCurrentCount(ctx, pool, capped.ID, 2)
+	if err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	if cnt.Goal == nil || *cnt.Goal != 4 || cnt.Progress == nil || *cnt.Progress != 0.5 {
+		t.Errorf("expected goal 4 and progress 0.5, got %v and %v", cnt.Goal, cnt.Progress)
+	}
+	if _, err := IncrementCurrentCount(ctx, pool, capped.ID, 4); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("expected ErrOutOfRange above max, got %v", err)
+	}
+	if _, err := SetCurrentCount(ctx, pool, capped.ID, 6); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput setting a value above max, got %v", err)
+	}
+	cnt, _ = GetOrCreateCurrentCount(ctx, pool, capped.ID)
+	if cnt.Value != 2 {
+		t.Errorf("expected rejected mutations to leave value 2, got %d", cnt.Value)
+	}
+
+	// Switching to clamp mode caps the next increment instead
+	mode := BoundClamp
+	if _, err := UpdateCounter(ctx, pool, capped.ID, CounterUpdate{BoundMode: &mode}); err != nil {
+		t.Fatalf("failed to update counter: %v", err)
+	}
+	cnt, err = IncrementCurrentCount(ctx, pool, capped.ID, 4)
+	if err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	if cnt.Value != 5 {
+		t.Errorf("expected value clamped to 5, got %d", cnt.Value)
+	}
+
+	if _, err := UpdateCounter(ctx, pool, capped.ID, CounterUpdate{
+		Min: NullableInt{Set: true, Value: int64Ptr(6)},
+	}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for min above max, got %v", err)
+	}
+	updated, err := UpdateCounter(ctx, pool, capped.ID, CounterUpdate{
+		Max:  NullableInt{Set: true},
+		Goal: NullableInt{Set: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to update counter: %v", err)
+	}
+	if updated.Max != nil || updated.Goal != nil {
+		t.Errorf("expected max and goal removed, got %v and %v", updated.Max, updated.Goal)
+	}
+}
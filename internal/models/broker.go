@@ -0,0 +1,290 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ChangeRollover is the kind of the Change announcing a counter's new period.
+const ChangeRollover = "rollover"
+
+// changeChannel is the Postgres notification channel new events are announced
+// on by the events_notify trigger.
+const changeChannel = "counter_events"
+
+// subscriptionBuffer is how many changes a subscriber may fall behind before
+// it is dropped.
+const subscriptionBuffer = 256
+
+// rolloverRefresh is how often the rollover watch reloads the counters, so new
+// counters and schedule changes are picked up between rollovers.
+const rolloverRefresh = time.Minute
+
+// ErrFeedStopped is returned by Subscribe when the change feed fails to start.
+var ErrFeedStopped = errors.New("change feed stopped")
+
+// Change is one message of the change feed. For an entry of a counter's event
+// log, Kind is the event kind and Event the entry. For ChangeRollover, Count
+// is the count of the period that just began; it is Filled when the period
+// has no record yet.
+type Change struct {
+	Kind      string
+	CounterID int64
+	Event     *Event
+	Count     *Count
+}
+
+// Broker fans the change feed out to subscribers. New events reach it through
+// Postgres LISTEN/NOTIFY, so changes made through any server instance sharing
+// the database are delivered. Rollovers are detected by every instance from
+// the counters' schedules. The broker only listens while it has subscribers.
+type Broker struct {
+	pool *pgxpool.Pool
+
+	mu     sync.Mutex
+	subs   map[*Subscription]bool
+	cancel context.CancelFunc
+	ready  chan struct{}
+}
+
+// Subscription receives the changes of a set of counters, or of all counters.
+// C is closed when the subscription ends: on Close, when the subscriber falls
+// too far behind or when the feed fails. Clients should then reconnect and
+// resume from the last event they received.
+type Subscription struct {
+	C <-chan Change
+
+	broker *Broker
+	// counters selects the counters whose changes are delivered, all of them
+	// when nil. It is guarded by broker.mu.
+	counters map[int64]bool
+	c        chan Change
+	done     chan struct{}
+}
+
+// NewBroker returns a broker reading the change feed from pool.
+func NewBroker(pool *pgxpool.Pool) *Broker {
+	return &Broker{pool: pool, subs: map[*Subscription]bool{}}
+}
+
+// Subscribe subscribes to the changes of the counter with counterID, or of all
+// counters when it is 0. It returns once the broker is listening, so every
+// event committed afterwards is delivered.
+func (b *Broker) Subscribe(ctx context.Context, counterID int64) (*Subscription, error) {
+	if counterID == 0 {
+		return b.subscribe(ctx, nil)
+	}
+	return b.SubscribeCounters(ctx, []int64{counterID})
+}
+
+// SubscribeCounters subscribes to the changes of the counters with
+// counterIDs, which Watch and Unwatch change later. It returns once the broker
+// is listening, like Subscribe.
+func (b *Broker) SubscribeCounters(ctx context.Context, counterIDs []int64) (*Subscription, error) {
+	counters := make(map[int64]bool, len(counterIDs))
+	for _, id := range counterIDs {
+		counters[id] = true
+	}
+	return b.subscribe(ctx, counters)
+}
+
+func (b *Broker) subscribe(ctx context.Context, counters map[int64]bool) (*Subscription, error) {
+	c := make(chan Change, subscriptionBuffer)
+	sub := &Subscription{C: c, broker: b, counters: counters, c: c, done: make(chan struct{})}
+
+	b.mu.Lock()
+	b.subs[sub] = true
+	if b.cancel == nil {
+		runCtx, cancel := context.WithCancel(context.Background())
+		b.cancel, b.ready = cancel, make(chan struct{})
+		go b.run(runCtx, b.ready)
+	}
+	ready := b.ready
+	b.mu.Unlock()
+
+	select {
+	case <-ready:
+		return sub, nil
+	case <-sub.done:
+		return nil, ErrFeedStopped
+	case <-ctx.Done():
+		sub.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Watch adds counterIDs to the counters of the subscription. Their changes
+// committed after it returns are delivered. A subscription to all counters is
+// left as it is.
+func (s *Subscription) Watch(counterIDs ...int64) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if s.counters == nil {
+		return
+	}
+	for _, id := range counterIDs {
+		s.counters[id] = true
+	}
+}
+
+// Unwatch removes counterIDs from the counters of the subscription. Changes of
+// them already in C are still received. A subscription to all counters is left
+// as it is.
+func (s *Subscription) Unwatch(counterIDs ...int64) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	for _, id := range counterIDs {
+		delete(s.counters, id)
+	}
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(s)
+}
+
+// drop removes sub and stops listening once nobody is subscribed. b.mu must be held.
+func (b *Broker) drop(sub *Subscription) {
+	if !b.subs[sub] {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.c)
+	close(sub.done)
+	if len(b.subs) == 0 && b.cancel != nil {
+		b.cancel()
+		b.cancel = nil
+	}
+}
+
+// run listens for events and watches for rollovers until ctx is cancelled.
+// When either fails, all subscriptions of this run are dropped, and the next
+// Subscribe starts over.
+func (b *Broker) run(ctx context.Context, ready chan struct{}) {
+	errc := make(chan error, 2)
+	go func() { errc <- b.listen(ctx, ready) }()
+	go func() { errc <- b.watchRollovers(ctx) }()
+	<-errc
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ready == ready && b.cancel != nil {
+		for sub := range b.subs {
+			b.drop(sub)
+		}
+	}
+}
+
+// publish delivers change to the matching subscribers of the run of ctx.
+// Subscribers whose buffer is full are dropped rather than holding up the rest.
+func (b *Broker) publish(ctx context.Context, change Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	for sub := range b.subs {
+		if sub.counters != nil && !sub.counters[change.CounterID] {
+			continue
+		}
+		select {
+		case sub.c <- change:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// listen publishes the events announced on changeChannel. It takes a
+// connection out of the pool for LISTEN, so it does not hold up queries.
+func (b *Broker) listen(ctx context.Context, ready chan struct{}) error {
+	pooled, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+changeChannel); err != nil {
+		return err
+	}
+	close(ready)
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var payload struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(n.Payload), &payload); err != nil {
+			return err
+		}
+		e, err := scanEvent(b.pool.QueryRow(ctx, "SELECT "+eventColumns+" FROM events WHERE id = $1", payload.ID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The event went with its counter, deleted since it was committed
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		b.publish(ctx, Change{Kind: e.Kind, CounterID: e.CounterID, Event: e})
+	}
+}
+
+// watchRollovers publishes a ChangeRollover for every active counter whose
+// period ends, at the time it ends. The new period's record is not created,
+// as counts are only recorded once a counter is used.
+func (b *Broker) watchRollovers(ctx context.Context) error {
+	var expiries map[int64]time.Time
+	for {
+		now := time.Now().UTC()
+		counters, err := GetAllCounters(ctx, b.pool, false)
+		if err != nil {
+			return err
+		}
+		next := now.Add(rolloverRefresh)
+		current := make(map[int64]time.Time, len(counters))
+		for i := range counters {
+			c := &counters[i]
+			if err := c.loadHolidays(ctx, b.pool); err != nil {
+				return err
+			}
+			expiry, err := c.nextExpiry(now)
+			if err != nil {
+				// A schedule without further periods never rolls over
+				continue
+			}
+			if prev, ok := expiries[c.ID]; ok && !prev.After(now) {
+				cnt, err := peekCount(ctx, b.pool, c, now)
+				if err != nil {
+					return err
+				}
+				cnt.setGoal(c.Goal)
+				b.publish(ctx, Change{Kind: ChangeRollover, CounterID: c.ID, Count: cnt})
+			}
+			current[c.ID] = expiry
+			if expiry.Before(next) {
+				next = expiry
+			}
+		}
+		expiries = current
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
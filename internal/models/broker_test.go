@@ -0,0 +1,217 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestBroker tests that committed events reach the matching subscribers, also
+// after their counters change, and that GetEventsAfter resumes after a given event.
+func TestBroker(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a, err := CreateCounter(ctx, pool, "broker-a", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	b, err := CreateCounter(ctx, pool, "broker-b", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	broker := NewBroker(pool)
+	subA, err := broker.Subscribe(ctx, a.ID)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer subA.Close()
+	subAll, err := broker.Subscribe(ctx, 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer subAll.Close()
+
+	if _, err := IncrementCurrentCount(ctx, pool, a.ID, 2); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	if _, err := SetCurrentCount(ctx, pool, b.ID, 5); err != nil {
+		t.Fatalf("failed to set: %v", err)
+	}
+
+	next := func(sub *Subscription) Change {
+		select {
+		case change := <-sub.C:
+			return change
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for a change")
+			return Change{}
+		}
+	}
+	first := next(subAll)
+	if first.CounterID != a.ID || first.Kind != EventIncrement || first.Event == nil || first.Event.Value != 2 {
+		t.Errorf("expected the increment of counter a, got %+v", first)
+	}
+	if change := next(subAll); change.CounterID != b.ID || change.Kind != EventSet {
+		t.Errorf("expected the set of counter b, got %+v", change)
+	}
+	if change := next(subA); change.CounterID != a.ID || change.Kind != EventIncrement {
+		t.Errorf("expected the increment of counter a, got %+v", change)
+	}
+	select {
+	case change := <-subA.C:
+		t.Errorf("expected no change of other counters, got %+v", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	events, err := GetEventsAfter(ctx, pool, 0, first.Event.ID, 0)
+	if err != nil {
+		t.Fatalf("failed to get events: %v", err)
+	}
+	if len(events) != 1 || events[0].CounterID != b.ID {
+		t.Errorf("expected the event of counter b after the first one, got %+v", events)
+	}
+	if events, _ := GetEventsAfter(ctx, pool, a.ID, first.Event.ID, 0); len(events) != 0 {
+		t.Errorf("expected no later events of counter a, got %+v", events)
+	}
+
+	// Watching b delivers its changes from then on, and unwatching a stops its
+	subA.Watch(b.ID)
+	subA.Unwatch(a.ID)
+	if _, err := IncrementCurrentCount(ctx, pool, a.ID, 1); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	if _, err := IncrementCurrentCount(ctx, pool, b.ID, 1); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	if change := next(subA); change.CounterID != b.ID || change.Kind != EventIncrement {
+		t.Errorf("expected the increment of counter b, got %+v", change)
+	}
+
+	subA.Close()
+	if _, ok := <-subA.C; ok {
+		t.Error("expected a closed subscription channel")
+	}
+}
+
+// TestBrokerDeletedCounter tests that an event whose counter is deleted before
+// the broker reads it is skipped without ending the subscriptions.
+func TestBrokerDeletedCounter(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	doomed, err := CreateCounter(ctx, pool, "broker-doomed", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	kept, err := CreateCounter(ctx, pool, "broker-kept", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if _, err := IncrementCurrentCount(ctx, pool, doomed.ID, 1); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+
+	broker := NewBroker(pool)
+	sub, err := broker.Subscribe(ctx, 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	// The notification is sent on commit, when the event is already gone
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO events (counter_id, count_id, kind, delta, previous_value, value)
+		 SELECT counter_id, count_id, $2, 1, value, value + 1 FROM events WHERE counter_id = $1`,
+		doomed.ID, EventIncrement); err != nil {
+		t.Fatalf("failed to record event: %v", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM counters WHERE id = $1", doomed.ID); err != nil {
+		t.Fatalf("failed to delete counter: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	if _, err := IncrementCurrentCount(ctx, pool, kept.ID, 1); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	select {
+	case change, ok := <-sub.C:
+		if !ok {
+			t.Fatal("expected the subscription to survive the deleted event")
+		}
+		if change.CounterID != kept.ID {
+			t.Errorf("expected the increment of the kept counter, got %+v", change)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for a change")
+	}
+}
+
+// TestGetEventsCommittedAfter tests that an event committed after one with a
+// higher ID is replayed to a client resuming from the later one.
+func TestGetEventsCommittedAfter(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	a, err := CreateCounter(ctx, pool, "late-a", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	b, err := CreateCounter(ctx, pool, "late-b", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	_, lateID, err := applyMutation(ctx, tx, a.ID, Mutation{Delta: 1})
+	if err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	if _, err := IncrementCurrentCount(ctx, pool, b.ID, 1); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	received, err := GetEventsAfter(ctx, pool, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to get events: %v", err)
+	}
+	if len(received) != 1 || received[0].ID <= lateID {
+		t.Fatalf("expected only the later event to be committed, got %+v", received)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	if events, _ := GetEventsAfter(ctx, pool, 0, received[0].ID, 0); len(events) != 0 {
+		t.Errorf("expected no events above the last one received, got %+v", events)
+	}
+	late, err := GetEventsCommittedAfter(ctx, pool, 0, received[0].ID)
+	if err != nil {
+		t.Fatalf("failed to get late events: %v", err)
+	}
+	if len(late) != 1 || late[0].ID != lateID {
+		t.Errorf("expected the late event %d, got %+v", lateID, late)
+	}
+	if late, _ := GetEventsCommittedAfter(ctx, pool, b.ID, received[0].ID); len(late) != 0 {
+		t.Errorf("expected no late events of counter b, got %+v", late)
+	}
+}
@@ -0,0 +1,353 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrDuplicateCalendarName is returned when a calendar name is already taken.
+var ErrDuplicateCalendarName = errors.New("calendar name already exists")
+
+// Calendar is a named set of holidays that business-day counters skip.
+type Calendar struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt string    `json:"created_at"`
+	Holidays  []Holiday `json:"holidays,omitempty"`
+}
+
+// Holiday is a single non-working day of a calendar.
+type Holiday struct {
+	Day  string `json:"day"`
+	Name string `json:"name"`
+}
+
+// calendarColumns lists the calendars columns read by scanCalendar, in order.
+const calendarColumns = "id, name, created_at::TEXT"
+
+// scanCalendar scans a single calendars row selected with calendarColumns.
+func scanCalendar(row pgx.Row) (*Calendar, error) {
+	var c Calendar
+	if err := row.Scan(&c.ID, &c.Name, &c.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+// translateCalendarWriteErr maps constraint violations on calendars to model errors.
+func translateCalendarWriteErr(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDuplicateCalendarName
+	}
+	return err
+}
+
+// CreateCalendar creates a calendar with an optional initial set of holidays.
+func CreateCalendar(ctx context.Context, pool *pgxpool.Pool, name string, holidays []Holiday) (*Calendar, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: name must not be empty", ErrInvalidInput)
+	}
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	c, err := scanCalendar(tx.QueryRow(ctx,
+		"INSERT INTO calendars (name) VALUES ($1) RETURNING "+calendarColumns, name))
+	if err != nil {
+		return nil, translateCalendarWriteErr(err)
+	}
+	if err := upsertHolidays(ctx, tx, c.ID, holidays); err != nil {
+		return nil, err
+	}
+	if c.Holidays, err = getHolidays(ctx, tx, c.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetAllCalendars lists calendars ordered by ID, without their holidays.
+func GetAllCalendars(ctx context.Context, pool *pgxpool.Pool) ([]Calendar, error) {
+	rows, err := pool.Query(ctx, "SELECT "+calendarColumns+" FROM calendars ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Calendar
+	for rows.Next() {
+		c, err := scanCalendar(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *c)
+	}
+	return out, rows.Err()
+}
+
+// GetCalendarByID returns a calendar together with its holidays.
+func GetCalendarByID(ctx context.Context, pool *pgxpool.Pool, id int64) (*Calendar, error) {
+	c, err := scanCalendar(pool.QueryRow(ctx, "SELECT "+calendarColumns+" FROM calendars WHERE id = $1", id))
+	if err != nil {
+		return nil, err
+	}
+	if c.Holidays, err = getHolidays(ctx, pool, id); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RenameCalendar changes a calendar's name.
+func RenameCalendar(ctx context.Context, pool *pgxpool.Pool, id int64, name string) (*Calendar, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: name must not be empty", ErrInvalidInput)
+	}
+	c, err := scanCalendar(pool.QueryRow(ctx,
+		"UPDATE calendars SET name = $1 WHERE id = $2 RETURNING "+calendarColumns, name, id))
+	if err != nil {
+		return nil, translateCalendarWriteErr(err)
+	}
+	return c, nil
+}
+
+// DeleteCalendar removes a calendar and its holidays. Counters using it fall
+// back to skipping weekends only.
+func DeleteCalendar(ctx context.Context, pool *pgxpool.Pool, id int64) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	counters, err := lockCalendarCounters(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM calendars WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	for i := range counters {
+		counters[i].CalendarID = nil
+		if err := realignCurrentCount(ctx, tx, &counters[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// AddHolidays adds holidays to a calendar, replacing the names of days that are
+// already present, and returns the calendar's full holiday list. The running
+// periods of counters using the calendar are re-aligned.
+func AddHolidays(ctx context.Context, pool *pgxpool.Pool, calendarID int64, holidays []Holiday) ([]Holiday, error) {
+	return changeHolidays(ctx, pool, calendarID, func(tx pgx.Tx) error {
+		return upsertHolidays(ctx, tx, calendarID, holidays)
+	})
+}
+
+// RemoveHoliday removes a single day (YYYY-MM-DD) from a calendar and returns
+// the calendar's remaining holidays.
+func RemoveHoliday(ctx context.Context, pool *pgxpool.Pool, calendarID int64, day string) ([]Holiday, error) {
+	d, err := parseHolidayDay(day)
+	if err != nil {
+		return nil, err
+	}
+	return changeHolidays(ctx, pool, calendarID, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, "DELETE FROM calendar_holidays WHERE calendar_id = $1 AND day = $2", calendarID, d)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// changeHolidays runs change in a transaction holding the calendar's counters,
+// then re-aligns their running periods to the new holidays.
+func changeHolidays(ctx context.Context, pool *pgxpool.Pool, calendarID int64, change func(tx pgx.Tx) error) ([]Holiday, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := scanCalendar(tx.QueryRow(ctx,
+		"SELECT "+calendarColumns+" FROM calendars WHERE id = $1 FOR UPDATE", calendarID)); err != nil {
+		return nil, err
+	}
+	counters, err := lockCalendarCounters(ctx, tx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	if err := change(tx); err != nil {
+		return nil, err
+	}
+	for i := range counters {
+		if err := realignCurrentCount(ctx, tx, &counters[i]); err != nil {
+			return nil, err
+		}
+	}
+	holidays, err := getHolidays(ctx, tx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return holidays, nil
+}
+
+// lockCalendarCounters selects the counters using a calendar FOR UPDATE.
+func lockCalendarCounters(ctx context.Context, tx pgx.Tx, calendarID int64) ([]Counter, error) {
+	rows, err := tx.Query(ctx,
+		"SELECT "+counterColumns+" FROM counters WHERE calendar_id = $1 ORDER BY id FOR UPDATE", calendarID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Counter
+	for rows.Next() {
+		c, err := scanCounter(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *c)
+	}
+	return out, rows.Err()
+}
+
+func upsertHolidays(ctx context.Context, q querier, calendarID int64, holidays []Holiday) error {
+	for _, h := range holidays {
+		day, err := parseHolidayDay(h.Day)
+		if err != nil {
+			return err
+		}
+		if _, err := q.Exec(ctx,
+			`INSERT INTO calendar_holidays (calendar_id, day, name) VALUES ($1, $2, $3)
+			 ON CONFLICT (calendar_id, day) DO UPDATE SET name = EXCLUDED.name`,
+			calendarID, day, h.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getHolidays(ctx context.Context, q querier, calendarID int64) ([]Holiday, error) {
+	rows, err := q.Query(ctx,
+		"SELECT day, name FROM calendar_holidays WHERE calendar_id = $1 ORDER BY day", calendarID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Holiday{}
+	for rows.Next() {
+		var day time.Time
+		var h Holiday
+		if err := rows.Scan(&day, &h.Name); err != nil {
+			return nil, err
+		}
+		h.Day = day.Format("2006-01-02")
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+func parseHolidayDay(s string) (time.Time, error) {
+	day, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid holiday date: %s (expected YYYY-MM-DD)", ErrInvalidInput, s)
+	}
+	return day, nil
+}
+
+// ParseICalHolidays reads the all-day events of an iCalendar (.ics) file as
+// holidays. An event spanning several days yields one holiday per day, named
+// after the event's SUMMARY. Recurring events are not expanded.
+func ParseICalHolidays(r io.Reader) ([]Holiday, error) {
+	// Unfold continuation lines (RFC 5545 section 3.1) before parsing properties
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []Holiday
+	var inEvent bool
+	var summary string
+	var start, end time.Time
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(strings.ToUpper(name), ";")
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			inEvent, summary, start, end = true, "", time.Time{}, time.Time{}
+		case name == "END" && value == "VEVENT":
+			inEvent = false
+			if start.IsZero() {
+				return nil, fmt.Errorf("%w: iCalendar event %q has no DTSTART", ErrInvalidInput, summary)
+			}
+			if !end.After(start) {
+				end = start.AddDate(0, 0, 1)
+			}
+			for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+				out = append(out, Holiday{Day: day.Format("2006-01-02"), Name: summary})
+			}
+		case inEvent && name == "SUMMARY":
+			summary = unescapeICalText(value)
+		case inEvent && (name == "DTSTART" || name == "DTEND"):
+			if len(value) < 8 {
+				return nil, fmt.Errorf("%w: invalid iCalendar date: %s", ErrInvalidInput, value)
+			}
+			day, err := time.Parse("20060102", value[:8])
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid iCalendar date: %s", ErrInvalidInput, value)
+			}
+			if name == "DTSTART" {
+				start = day
+			} else {
+				end = day
+			}
+		}
+	}
+	return out, nil
+}
+
+// unescapeICalText reverses the TEXT escaping of RFC 5545 section 3.3.11.
+func unescapeICalText(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iben12/counter-app/internal/db"
+)
+
+func TestParseICalHolidays(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20251224",
+		"DTEND;VALUE=DATE:20251227",
+		"SUMMARY:Christmas\\, Boxing Day",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:New Year",
+		"DTSTART;VALUE=DATE:20260101",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART:20260501T000000Z",
+		"SUMMARY:Labour",
+		"  Day",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	got, err := ParseICalHolidays(strings.NewReader(ics))
+	if err != nil {
+		t.Fatalf("ParseICalHolidays() error = %v", err)
+	}
+	want := []Holiday{
+		{Day: "2025-12-24", Name: "Christmas, Boxing Day"},
+		{Day: "2025-12-25", Name: "Christmas, Boxing Day"},
+		{Day: "2025-12-26", Name: "Christmas, Boxing Day"},
+		{Day: "2026-01-01", Name: "New Year"},
+		{Day: "2026-05-01", Name: "Labour Day"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseICalHolidays() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("holiday %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := ParseICalHolidays(strings.NewReader("BEGIN:VEVENT\r\nSUMMARY:Broken\r\nEND:VEVENT\r\n")); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for an event without DTSTART, got %v", err)
+	}
+}
+
+// TestBusinessDayCounterFollowsCalendar tests that a business-day counter skips its
+// calendar's holidays and is re-aligned when the holidays change.
+func TestBusinessDayCounterFollowsCalendar(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	cal, err := CreateCalendar(ctx, pool, "ops", nil)
+	if err != nil {
+		t.Fatalf("failed to create calendar: %v", err)
+	}
+	counter, err := CreateCounterWithSettings(ctx, pool, "ops-counter", CounterSettings{Frequency: "1b", CalendarID: &cal.ID})
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if counter.CalendarID == nil || *counter.CalendarID != cal.ID {
+		t.Fatalf("expected calendar_id %d, got %v", cal.ID, counter.CalendarID)
+	}
+	before, err := GetOrCreateCurrentCount(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get current count: %v", err)
+	}
+
+	// Declare the day the period was due to end a holiday; the period must now run past it
+	end, _ := time.Parse(time.RFC3339, before.Expiry)
+	if _, err := AddHolidays(ctx, pool, cal.ID, []Holiday{{Day: end.Format("2006-01-02"), Name: "Surprise"}}); err != nil {
+		t.Fatalf("failed to add holiday: %v", err)
+	}
+	want, _ := db.NextExpiryTimeWithOptions("1b", now, "UTC", db.PeriodOptions{
+		WeekStart: time.Monday,
+		Holidays:  []time.Time{end},
+	})
+	after, err := GetOrCreateCurrentCount(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get current count: %v", err)
+	}
+	if after.ID != before.ID || after.Expiry != want.Format(time.RFC3339) {
+		t.Errorf("expected count %d to expire at %s, got count %d at %s", before.ID, want.Format(time.RFC3339), after.ID, after.Expiry)
+	}
+	if !want.After(end) {
+		t.Errorf("expected the holiday %s to push the expiry past it, got %s", end, want)
+	}
+
+	// Deleting the calendar detaches the counter and restores the weekend-only schedule
+	if err := DeleteCalendar(ctx, pool, cal.ID); err != nil {
+		t.Fatalf("failed to delete calendar: %v", err)
+	}
+	updated, err := GetCounterByID(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get counter: %v", err)
+	}
+	if updated.CalendarID != nil {
+		t.Errorf("expected calendar_id to be cleared, got %d", *updated.CalendarID)
+	}
+	restored, _ := GetOrCreateCurrentCount(ctx, pool, counter.ID)
+	if restored.Expiry != before.Expiry {
+		t.Errorf("expected expiry %s after deleting the calendar, got %s", before.Expiry, restored.Expiry)
+	}
+}
+
+func TestCalendarValidation(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	if _, err := CreateCalendar(ctx, pool, "dup", nil); err != nil {
+		t.Fatalf("failed to create calendar: %v", err)
+	}
+	if _, err := CreateCalendar(ctx, pool, "dup", nil); !errors.Is(err, ErrDuplicateCalendarName) {
+		t.Errorf("expected ErrDuplicateCalendarName, got %v", err)
+	}
+	if _, err := CreateCalendar(ctx, pool, "bad-day", []Holiday{{Day: "25/12/2025"}}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a malformed day, got %v", err)
+	}
+	missing := int64(999)
+	if _, err := CreateCounterWithSettings(ctx, pool, "no-calendar", CounterSettings{Frequency: "1b", CalendarID: &missing}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a missing calendar, got %v", err)
+	}
+	if _, err := RemoveHoliday(ctx, pool, 999, "2025-12-25"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing calendar, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Event kinds recorded in the events table.
+const (
+	EventIncrement = "increment"
+	EventDecrement = "decrement"
+	EventSet       = "set"
+	EventReset     = "reset"
+	EventUndo      = "undo"
+	EventRedo      = "redo"
+)
+
+// Event is one entry of a counter's operation log. Delta is the change that was
+// requested; Value - PreviousValue is the change actually applied after clamping
+// to the counter's bounds.
+// For EventSet and EventReset, Delta is the change the new value made.
+type Event struct {
+	ID             int64   `json:"id"`
+	CounterID      int64   `json:"counter_id"`
+	CountID        int64   `json:"count_id"`
+	Kind           string  `json:"kind"`
+	Delta          int64   `json:"delta"`
+	PreviousValue  int64   `json:"previous_value"`
+	Value          int64   `json:"value"`
+	Note           string  `json:"note,omitempty"`
+	Actor          string  `json:"actor,omitempty"`
+	OccurredAt     string  `json:"occurred_at"`
+	CreatedAt      string  `json:"created_at"`
+	UndoneAt       *string `json:"undone_at,omitempty"`
+	RevertsEventID *int64  `json:"reverts_event_id,omitempty"`
+
+	createdAt time.Time
+}
+
+// eventColumns lists the events columns read by scanEvent, in order.
+const eventColumns = "id, counter_id, count_id, kind, delta, previous_value, value, note, actor, occurred_at, created_at, undone_at, reverts_event_id"
+
+// scanEvent scans a single events row selected with eventColumns.
+func scanEvent(row pgx.Row) (*Event, error) {
+	var e Event
+	var occurredAt time.Time
+	var undoneAt *time.Time
+	if err := row.Scan(&e.ID, &e.CounterID, &e.CountID, &e.Kind, &e.Delta, &e.PreviousValue,
+		&e.Value, &e.Note, &e.Actor, &occurredAt, &e.createdAt, &undoneAt, &e.RevertsEventID); err != nil {
+		return nil, err
+	}
+	e.OccurredAt = occurredAt.UTC().Format(time.RFC3339Nano)
+	e.CreatedAt = e.createdAt.UTC().Format(time.RFC3339Nano)
+	if undoneAt != nil {
+		s := undoneAt.UTC().Format(time.RFC3339Nano)
+		e.UndoneAt = &s
+	}
+	return &e, nil
+}
+
+// Mutation is a change to a counter's count together with the context
+// recorded in its event log. At is when the change happened; the zero value
+// means now, and an earlier time applies the change to the period containing it.
+type Mutation struct {
+	// Kind is EventSet to set the count to Value or EventReset to set it to
+	// the counter's initial value; otherwise Delta is added and the kind is EventIncrement or
+	// EventDecrement by its sign.
+	Kind  string
+	Delta int64
+	Value int64
+	Note  string
+	Actor string
+	At    time.Time
+}
+
+// ApplyMutation applies m to the count of the period containing m.At and
+// records the change in the event log in the same transaction. The period's
+// count record is created if it does not exist yet. A delta that leaves the
+// counter's bounds is clamped or fails with ErrOutOfRange, depending on the
+// bound mode; a set value outside them is always invalid input. The change is
+// also added to the counter's windows and queued for the webhooks subscribed to it,
+// and the counter's alert rules are evaluated.
+func ApplyMutation(ctx context.Context, pool *pgxpool.Pool, counterID int64, m Mutation) (*Count, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	cnt, _, err := applyMutation(ctx, tx, counterID, m)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return cnt, nil
+}
+
+// applyMutation is ApplyMutation within the caller's transaction. It also
+// returns the ID of the event recorded.
+func applyMutation(ctx context.Context, tx pgx.Tx, counterID int64, m Mutation) (*Count, int64, error) {
+	kind := m.Kind
+	switch {
+	case kind == EventSet, kind == EventReset:
+	case kind != "":
+		return nil, 0, fmt.Errorf("%w: unknown mutation kind: %s", ErrInvalidInput, kind)
+	case m.Delta == 0:
+		return nil, 0, fmt.Errorf("%w: delta must be non-zero", ErrInvalidInput)
+	case m.Delta > 0:
+		kind = EventIncrement
+	default:
+		kind = EventDecrement
+	}
+	now := time.Now().UTC()
+	at := m.At
+	if at.IsZero() {
+		at = now
+	} else if at.After(now) {
+		return nil, 0, fmt.Errorf("%w: at must not be in the future", ErrInvalidInput)
+	}
+
+	counter, err := lockCounter(ctx, tx, counterID)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case kind == EventReset:
+		m.Value = counter.initialValue()
+	case kind == EventSet && !counter.inRange(m.Value):
+		return nil, 0, fmt.Errorf("%w: value %d is outside the counter's bounds", ErrInvalidInput, m.Value)
+	}
+	target, err := countAt(ctx, tx, counter, at)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Lock the row so the new value is computed from the one it replaces
+	var previous int64
+	if err := tx.QueryRow(ctx, "SELECT value FROM counts WHERE id = $1 FOR UPDATE", target.ID).Scan(&previous); err != nil {
+		return nil, 0, err
+	}
+	value, delta := m.Value, m.Value-previous
+	if kind == EventIncrement || kind == EventDecrement {
+		delta = m.Delta
+		if value, err = counter.bound(previous + m.Delta); err != nil {
+			return nil, 0, err
+		}
+	}
+	cnt, err := scanCount(tx.QueryRow(ctx,
+		"UPDATE counts SET value = $1 WHERE id = $2 RETURNING "+countColumns,
+		value, target.ID))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := addToWindows(ctx, tx, counter, at, cnt.Value-previous); err != nil {
+		return nil, 0, err
+	}
+
+	e, err := scanEvent(tx.QueryRow(ctx,
+		`INSERT INTO events (counter_id, count_id, kind, delta, previous_value, value, note, actor, occurred_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING `+eventColumns,
+		counterID, cnt.ID, kind, delta, previous, cnt.Value, m.Note, m.Actor, at))
+	if err != nil {
+		return nil, 0, err
+	}
+	cnt.setGoal(counter.Goal)
+	if err := reportChange(ctx, tx, e, cnt, previous); err != nil {
+		return nil, 0, err
+	}
+	if err := evaluateAlertRules(ctx, tx, counter, now); err != nil {
+		return nil, 0, err
+	}
+	return cnt, e.ID, nil
+}
+
+// EventQuery filters and pages a counter's event log. From is inclusive and To
+// exclusive; zero times leave the range open. The range applies to the time
+// events happened (occurred_at), so backdated changes are found in the range
+// they belong to, while pages stay ordered by the time events were recorded.
+// Order is OrderDesc (the default, newest first) or OrderAsc. Cursor continues
+// a previous page.
+type EventQuery struct {
+	From   time.Time
+	To     time.Time
+	Order  string
+	Limit  int
+	Cursor *Cursor
+}
+
+// GetEvents returns a page of a counter's events and the cursor of the next
+// page, which is nil on the last page.
+func GetEvents(ctx context.Context, pool *pgxpool.Pool, counterID int64, q EventQuery) ([]Event, *Cursor, error) {
+	dir, op, err := keysetOrder(q.Order, OrderDesc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := GetCounterByID(ctx, pool, counterID); err != nil {
+		return nil, nil, err
+	}
+	limit := pageLimit(q.Limit)
+
+	from, to := optionalTime(q.From), optionalTime(q.To)
+	var cursorTime *time.Time
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorTime, cursorID = &q.Cursor.Time, q.Cursor.ID
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	rows, err := pool.Query(ctx,
+		`SELECT `+eventColumns+` FROM events
+		 WHERE counter_id = $1
+		   AND ($2::timestamptz IS NULL OR occurred_at >= $2)
+		   AND ($3::timestamptz IS NULL OR occurred_at < $3)
+		   AND ($4::timestamptz IS NULL OR (created_at, id) `+op+` ($4, $5))
+		 ORDER BY created_at `+dir+`, id `+dir+`
+		 LIMIT $6`,
+		counterID, from, to, cursorTime, cursorID, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, *e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(events) <= limit {
+		return events, nil, nil
+	}
+	events = events[:limit]
+	last := events[limit-1]
+	return events, &Cursor{Time: last.createdAt, ID: last.ID}, nil
+}
+
+// GetEventsAfter returns up to limit events with an ID above afterID in ID
+// order, of one counter or of all counters when counterID is 0. Streams use it
+// to resume from the last event a client received, together with
+// GetEventsCommittedAfter.
+func GetEventsAfter(ctx context.Context, pool *pgxpool.Pool, counterID, afterID int64, limit int) ([]Event, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT `+eventColumns+` FROM events
+		 WHERE ($1::bigint = 0 OR counter_id = $1) AND id > $2
+		 ORDER BY id
+		 LIMIT $3`,
+		counterID, afterID, pageLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// GetEventsCommittedAfter returns the events with an ID below afterID that may
+// have been committed after event afterID, in ID order, of one counter or of
+// all counters when counterID is 0. IDs are taken when an event is recorded,
+// so a transaction still running then can commit a lower ID later; these are
+// the events of such transactions. Some of them may have been committed
+// before, so a resuming client may see them again. There are none when event
+// afterID no longer exists.
+func GetEventsCommittedAfter(ctx context.Context, pool *pgxpool.Pool, counterID, afterID int64) ([]Event, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT `+eventColumns+` FROM events e, (SELECT xact, xact_horizon FROM events WHERE id = $2) prev
+		 WHERE ($1::bigint = 0 OR e.counter_id = $1) AND e.id < $2
+		   AND e.xact >= prev.xact_horizon AND e.xact <> prev.xact
+		 ORDER BY e.id`,
+		counterID, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iben12/counter-app/internal/db"
+)
+
+// TestApplyMutationRecordsEvents tests that every mutation is logged with the
+// value before and after it, including clamped decrements.
+func TestApplyMutationRecordsEvents(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounter(ctx, pool, "events-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if _, err := ApplyMutation(ctx, pool, counter.ID, Mutation{Delta: 3, Note: "morning batch", Actor: "alice"}); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	if _, err := IncrementCurrentCount(ctx, pool, counter.ID, -5); err != nil {
+		t.Fatalf("failed to decrement: %v", err)
+	}
+
+	events, next, err := GetEvents(ctx, pool, counter.ID, EventQuery{})
+	if err != nil {
+		t.Fatalf("failed to get events: %v", err)
+	}
+	if next != nil {
+		t.Errorf("expected no next page, got %v", next)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+
+	// Newest first
+	dec, inc := events[0], events[1]
+	if inc.Kind != EventIncrement || inc.Delta != 3 || inc.PreviousValue != 0 || inc.Value != 3 ||
+		inc.Note != "morning batch" || inc.Actor != "alice" {
+		t.Errorf("unexpected increment event: %+v", inc)
+	}
+	if dec.Kind != EventDecrement || dec.Delta != -5 || dec.PreviousValue != 3 || dec.Value != 0 {
+		t.Errorf("unexpected decrement event: %+v", dec)
+	}
+	if dec.CountID != inc.CountID {
+		t.Errorf("expected both events on count %d, got %d", inc.CountID, dec.CountID)
+	}
+}
+
+// TestGetEventsPagination tests keyset pagination and time-range filters.
+func TestGetEventsPagination(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounter(ctx, pool, "events-page-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := IncrementCurrentCount(ctx, pool, counter.ID, 1); err != nil {
+			t.Fatalf("failed to increment: %v", err)
+		}
+	}
+
+	var seen []int64
+	q := EventQuery{Limit: 2}
+	for page := 0; ; page++ {
+		events, next, err := GetEvents(ctx, pool, counter.ID, q)
+		if err != nil {
+			t.Fatalf("failed to get events: %v", err)
+		}
+		for _, e := range events {
+			seen = append(seen, e.Value)
+		}
+		if next == nil {
+			break
+		}
+		if page > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		cursor, err := ParseCursor(next.String())
+		if err != nil {
+			t.Fatalf("failed to round-trip cursor: %v", err)
+		}
+		q.Cursor = cursor
+	}
+	want := []int64{5, 4, 3, 2, 1}
+	if len(seen) != len(want) {
+		t.Fatalf("expected values %v, got %v", want, seen)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Errorf("expected values %v, got %v", want, seen)
+			break
+		}
+	}
+
+	events, _, err := GetEvents(ctx, pool, counter.ID, EventQuery{Order: OrderAsc, Limit: 1})
+	if err != nil {
+		t.Fatalf("failed to get events: %v", err)
+	}
+	if len(events) != 1 || events[0].Value != 1 {
+		t.Errorf("expected the oldest event first in ascending order, got %+v", events)
+	}
+	if _, _, err := GetEvents(ctx, pool, counter.ID, EventQuery{Order: "sideways"}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for an unknown order, got %v", err)
+	}
+
+	events, _, err = GetEvents(ctx, pool, counter.ID, EventQuery{From: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("failed to get events: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("expected no events after from, got %d", len(events))
+	}
+
+	if _, _, err := GetEvents(ctx, pool, 999, EventQuery{}); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a missing counter, got %v", err)
+	}
+}
+
+// TestApplyMutationBackdated tests that a backdated mutation lands in the period
+// containing its timestamp and leaves the current period alone.
+func TestApplyMutationBackdated(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounter(ctx, pool, "backdate-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	current, err := IncrementCurrentCount(ctx, pool, counter.ID, 1)
+	if err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+
+	at := time.Now().UTC().Add(-48 * time.Hour)
+	past, err := ApplyMutation(ctx, pool, counter.ID, Mutation{Delta: 4, At: at})
+	if err != nil {
+		t.Fatalf("failed to apply backdated mutation: %v", err)
+	}
+	wantExpiry, _ := db.NextExpiryTime("1d", at, "UTC")
+	if past.ID == current.ID || past.Expiry != wantExpiry.Format(time.RFC3339) || past.Value != 4 {
+		t.Errorf("expected a new count expiring %s with value 4, got %+v", wantExpiry.Format(time.RFC3339), past)
+	}
+
+	// A second change on the same day reuses the row
+	again, err := ApplyMutation(ctx, pool, counter.ID, Mutation{Delta: -1, At: at.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to apply backdated mutation: %v", err)
+	}
+	if again.ID != past.ID || again.Value != 3 {
+		t.Errorf("expected count %d with value 3, got %+v", past.ID, again)
+	}
+
+	latest, err := GetOrCreateCurrentCount(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get current count: %v", err)
+	}
+	if latest.ID != current.ID || latest.Value != 1 {
+		t.Errorf("expected current count %d with value 1, got %+v", current.ID, latest)
+	}
+
+	history, err := GetCountHistory(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	if len(history) != 2 || history[0].ID != current.ID || history[1].ID != past.ID {
+		t.Errorf("expected history ordered by period, got %+v", history)
+	}
+
+	events, _, _ := GetEvents(ctx, pool, counter.ID, EventQuery{})
+	if len(events) != 3 || events[0].OccurredAt != at.Add(time.Minute).Truncate(time.Microsecond).Format(time.RFC3339Nano) {
+		t.Errorf("expected the latest event to carry its occurred_at, got %+v", events)
+	}
+	events, _, _ = GetEvents(ctx, pool, counter.ID, EventQuery{From: at.Add(-time.Hour), To: at.Add(time.Hour)})
+	if len(events) != 2 || events[0].Delta != -1 || events[1].Delta != 4 {
+		t.Errorf("expected the range to select the backdated events, got %+v", events)
+	}
+
+	if _, err := ApplyMutation(ctx, pool, counter.ID, Mutation{Delta: 1, At: time.Now().Add(time.Hour)}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a future timestamp, got %v", err)
+	}
+}
+
+// TestSetAndResetCurrentCount tests that set and reset are logged as their own kinds.
+func TestSetAndResetCurrentCount(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounter(ctx, pool, "set-reset-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if _, err := IncrementCurrentCount(ctx, pool, counter.ID, 4); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	cnt, err := SetCurrentCount(ctx, pool, counter.ID, 10)
+	if err != nil {
+		t.Fatalf("failed to set: %v", err)
+	}
+	if cnt.Value != 10 {
+		t.Errorf("expected value 10 after set, got %d", cnt.Value)
+	}
+	cnt, err = ResetCurrentCount(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to reset: %v", err)
+	}
+	if cnt.Value != 0 {
+		t.Errorf("expected value 0 after reset, got %d", cnt.Value)
+	}
+	if _, err := SetCurrentCount(ctx, pool, counter.ID, -1); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a negative value, got %v", err)
+	}
+
+	events, _, _ := GetEvents(ctx, pool, counter.ID, EventQuery{})
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[1].Kind != EventSet || events[1].PreviousValue != 4 || events[1].Value != 10 || events[1].Delta != 6 {
+		t.Errorf("unexpected set event: %+v", events[1])
+	}
+	if events[0].Kind != EventReset || events[0].PreviousValue != 10 || events[0].Value != 0 || events[0].Delta != -10 {
+		t.Errorf("unexpected reset event: %+v", events[0])
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a different request.
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+	// ErrIdempotencyKeyInProgress is returned while the first request with a key is still running.
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+// StoredResponse is the response recorded for an idempotency key.
+type StoredResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// BeginIdempotentRequest claims key for a request with the given hash. It
+// returns nil when the caller should handle the request and then call
+// CompleteIdempotentRequest or ReleaseIdempotencyKey, or the stored response
+// when the same request was already handled. A key that is neither completed
+// nor released stays in progress until it expires; expired keys are claimed anew.
+func BeginIdempotentRequest(ctx context.Context, pool *pgxpool.Pool, key, requestHash string, ttl time.Duration) (*StoredResponse, error) {
+	if _, err := pool.Exec(ctx, "DELETE FROM idempotency_keys WHERE key = $1 AND expires_at <= now()", key); err != nil {
+		return nil, err
+	}
+	tag, err := pool.Exec(ctx,
+		`INSERT INTO idempotency_keys (key, request_hash, expires_at)
+		 VALUES ($1, $2, now() + $3 * interval '1 microsecond')
+		 ON CONFLICT (key) DO NOTHING`,
+		key, requestHash, ttl.Microseconds())
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var hash, contentType string
+	var status *int
+	var body []byte
+	if err := pool.QueryRow(ctx,
+		"SELECT request_hash, status, content_type, response FROM idempotency_keys WHERE key = $1",
+		key).Scan(&hash, &status, &contentType, &body); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The key was released between the insert and the select
+			return nil, ErrIdempotencyKeyInProgress
+		}
+		return nil, err
+	}
+	if hash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if status == nil {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return &StoredResponse{Status: *status, ContentType: contentType, Body: body}, nil
+}
+
+// CompleteIdempotentRequest stores the response of a request claimed with
+// BeginIdempotentRequest so retries replay it.
+func CompleteIdempotentRequest(ctx context.Context, pool *pgxpool.Pool, key string, resp StoredResponse) error {
+	_, err := pool.Exec(ctx,
+		"UPDATE idempotency_keys SET status = $1, content_type = $2, response = $3 WHERE key = $4",
+		resp.Status, resp.ContentType, resp.Body, key)
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a claimed key, so a retry is handled again.
+func ReleaseIdempotencyKey(ctx context.Context, pool *pgxpool.Pool, key string) error {
+	_, err := pool.Exec(ctx, "DELETE FROM idempotency_keys WHERE key = $1", key)
+	return err
+}
+
+// PurgeExpiredIdempotencyKeys deletes the keys whose TTL has passed.
+func PurgeExpiredIdempotencyKeys(ctx context.Context, pool *pgxpool.Pool) (int64, error) {
+	tag, err := pool.Exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= now()")
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
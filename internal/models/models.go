@@ -3,37 +3,336 @@ package models
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/iben12/counter-app/internal/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var (
+	// ErrNotFound is returned when a counter or related record does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidInput wraps validation failures of caller-supplied settings.
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrDuplicateName is returned when a counter name is already taken.
+	ErrDuplicateName = errors.New("counter name already exists")
+)
+
+// Counter is a named count that rolls over on a schedule. LifetimeTotal is the
+// sum of the values of all its periods and PeriodCount the number of periods
+// with a count record; both are kept up to date by the database.
 type Counter struct {
-	ID        int64  `json:"id"`
-	Name      string `json:"name"`
-	Frequency string `json:"frequency"`
-	Timezone  string `json:"timezone"`
-	CreatedAt string `json:"created_at"`
+	ID            int64   `json:"id"`
+	Name          string  `json:"name"`
+	Frequency     string  `json:"frequency"`
+	Timezone      string  `json:"timezone"`
+	WeekStart     string  `json:"week_start"`
+	Anchor        *string `json:"anchor"`
+	ScheduleType  string  `json:"schedule_type"`
+	Schedule      string  `json:"schedule,omitempty"`
+	CalendarID    *int64  `json:"calendar_id"`
+	Min           *int64  `json:"min"`
+	Max           *int64  `json:"max"`
+	Goal          *int64  `json:"goal"`
+	BoundMode     string  `json:"bound_mode"`
+	LifetimeTotal int64   `json:"lifetime_total"`
+	PeriodCount   int64   `json:"period_count"`
+	CreatedAt     string  `json:"created_at"`
+	ArchivedAt    *string `json:"archived_at"`
+
+	weekStart time.Weekday
+	anchor    *time.Time
+	holidays  []time.Time
 }
 
-func CreateCounter(ctx context.Context, pool *pgxpool.Pool, name string, frequency string, timezone string) (*Counter, error) {
+// querier is the subset of pgxpool.Pool and pgx.Tx used by helpers that run
+// either on their own or inside a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// counterColumns lists the counters columns read by scanCounter, in order.
+const counterColumns = "id, name, frequency, timezone, week_start, anchor, schedule_type, schedule, calendar_id, min_value, max_value, goal, bound_mode, lifetime_total, period_count, created_at::TEXT, archived_at::TEXT"
+
+// scanCounter scans a single counters row selected with counterColumns.
+func scanCounter(row pgx.Row) (*Counter, error) {
 	var c Counter
-	if frequency == "" {
-		frequency = "1d"
+	var weekStart int16
+	var anchor *time.Time
+	if err := row.Scan(&c.ID, &c.Name, &c.Frequency, &c.Timezone, &weekStart, &anchor,
+		&c.ScheduleType, &c.Schedule, &c.CalendarID, &c.Min, &c.Max, &c.Goal, &c.BoundMode,
+		&c.LifetimeTotal, &c.PeriodCount, &c.CreatedAt, &c.ArchivedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	c.setWeekStart(time.Weekday(weekStart))
+	c.setAnchor(anchor)
+	return &c, nil
+}
+
+func (c *Counter) setWeekStart(d time.Weekday) {
+	c.weekStart = d
+	c.WeekStart = strings.ToLower(d.String())
+}
+
+func (c *Counter) setAnchor(anchor *time.Time) {
+	c.anchor = anchor
+	c.Anchor = nil
+	if anchor != nil {
+		s := anchor.Format("2006-01-02")
+		c.Anchor = &s
+	}
+}
+
+// periodOptions returns the alignment settings passed to the frequency engine.
+func (c *Counter) periodOptions() db.PeriodOptions {
+	opts := db.PeriodOptions{WeekStart: c.weekStart}
+	if c.anchor != nil {
+		opts.Anchor = *c.anchor
+	}
+	opts.Holidays = c.holidays
+	return opts
+}
+
+// loadHolidays fetches the holidays of the counter's calendar so business-day
+// periods skip them. It must be called before nextExpiry for counters with a calendar.
+func (c *Counter) loadHolidays(ctx context.Context, q querier) error {
+	c.holidays = nil
+	if c.CalendarID == nil {
+		return nil
+	}
+	rows, err := q.Query(ctx, "SELECT day FROM calendar_holidays WHERE calendar_id = $1 ORDER BY day", *c.CalendarID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			return err
+		}
+		c.holidays = append(c.holidays, day)
+	}
+	return rows.Err()
+}
+
+// scheduler returns the db.Scheduler for the counter's schedule type. Frequency
+// counters go through the same interface as cron and RRULE ones.
+func (c *Counter) scheduler() (db.Scheduler, error) {
+	if c.ScheduleType == db.ScheduleFrequency {
+		return db.NewScheduler(c.ScheduleType, c.Frequency, c.Timezone, c.periodOptions())
+	}
+	return db.NewScheduler(c.ScheduleType, c.Schedule, c.Timezone, c.periodOptions())
+}
+
+// periodStart returns the start of the counter's period containing at. When the
+// schedule has no boundary before at, the period is taken to start at at.
+func (c *Counter) periodStart(at time.Time) (time.Time, error) {
+	s, err := c.scheduler()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return periodStartIn(s, at)
+}
+
+// periodStartIn is db.PeriodStart, taking at as the start when s has no
+// boundary before it.
+func periodStartIn(s db.Scheduler, at time.Time) (time.Time, error) {
+	start, err := db.PeriodStart(s, at)
+	if errors.Is(err, db.ErrNoPeriodStart) {
+		return at, nil
+	}
+	return start, err
+}
+
+// nextExpiry returns the end of the counter's period containing now.
+func (c *Counter) nextExpiry(now time.Time) (time.Time, error) {
+	s, err := c.scheduler()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.Next(now)
+}
+
+// validateSchedule checks that the counter's schedule can produce an expiry.
+func (c *Counter) validateSchedule() error {
+	if c.ScheduleType == db.ScheduleFrequency && c.Schedule != "" {
+		return fmt.Errorf("%w: schedule is only used with cron and rrule schedule types", ErrInvalidInput)
+	}
+	if c.ScheduleType != db.ScheduleFrequency && c.Schedule == "" {
+		return fmt.Errorf("%w: schedule required for schedule type %s", ErrInvalidInput, c.ScheduleType)
+	}
+	if _, err := c.nextExpiry(time.Now()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	return nil
+}
+
+// parseWeekStart parses a week start day name; an empty string means Monday.
+func parseWeekStart(s string) (time.Weekday, error) {
+	if s == "" {
+		return time.Monday, nil
+	}
+	d, err := db.ParseWeekday(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidInput, err)
 	}
-	if timezone == "" {
-		timezone = "UTC"
+	return d, nil
+}
+
+// parseAnchor parses an anchor date in YYYY-MM-DD form; an empty string means no anchor.
+func parseAnchor(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
 	}
-	err := pool.QueryRow(ctx, "INSERT INTO counters (name, frequency, timezone) VALUES ($1, $2, $3) RETURNING id, name, frequency, timezone, created_at::TEXT", name, frequency, timezone).Scan(&c.ID, &c.Name, &c.Frequency, &c.Timezone, &c.CreatedAt)
+	t, err := time.Parse("2006-01-02", s)
 	if err != nil {
+		return nil, fmt.Errorf("%w: invalid anchor date: %s (expected YYYY-MM-DD)", ErrInvalidInput, s)
+	}
+	return &t, nil
+}
+
+// todayIn returns today's date in the given timezone, as a UTC midnight like parseAnchor.
+func todayIn(timezone string) (*time.Time, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timezone: %s", ErrInvalidInput, timezone)
+	}
+	y, m, d := time.Now().In(loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return &today, nil
+}
+
+// pinRRuleStart anchors an RRULE schedule without a DTSTART to today, so its
+// occurrences are generated from a recent date instead of the epoch and stay
+// stable. Other schedules and anchored counters are left as they are.
+func (c *Counter) pinRRuleStart() error {
+	if c.anchor != nil || c.ScheduleType != db.ScheduleRRule || db.HasDTStart(c.Schedule) {
+		return nil
+	}
+	anchor, err := todayIn(c.Timezone)
+	if err != nil {
+		return err
+	}
+	c.setAnchor(anchor)
+	return nil
+}
+
+// translateWriteErr maps constraint violations on counters to model errors.
+func translateWriteErr(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return ErrDuplicateName
+		case "23503":
+			return fmt.Errorf("%w: calendar does not exist", ErrInvalidInput)
+		}
+	}
+	return err
+}
+
+// CounterSettings holds the optional schedule settings of a new counter.
+// Empty fields fall back to the defaults: daily, UTC, weeks starting Monday
+// and periods counted from the epoch. ScheduleType selects a cron or RRULE
+// Schedule instead of the frequency. CalendarID names the holiday calendar
+// that business-day frequencies skip. Min defaults to zero and Max and Goal
+// to none; BoundMode defaults to clamping.
+type CounterSettings struct {
+	Frequency    string
+	Timezone     string
+	WeekStart    string
+	Anchor       string
+	ScheduleType string
+	Schedule     string
+	CalendarID   *int64
+	Min          NullableInt
+	Max          NullableInt
+	Goal         NullableInt
+	BoundMode    string
+}
+
+func CreateCounter(ctx context.Context, pool *pgxpool.Pool, name string, frequency string, timezone string) (*Counter, error) {
+	return CreateCounterWithSettings(ctx, pool, name, CounterSettings{Frequency: frequency, Timezone: timezone})
+}
+
+// CreateCounterWithSettings creates a counter after validating its schedule settings.
+func CreateCounterWithSettings(ctx context.Context, pool *pgxpool.Pool, name string, settings CounterSettings) (*Counter, error) {
+	c := Counter{
+		Name:         name,
+		Frequency:    settings.Frequency,
+		Timezone:     settings.Timezone,
+		ScheduleType: settings.ScheduleType,
+		Schedule:     settings.Schedule,
+		CalendarID:   settings.CalendarID,
+		Min:          settings.Min.or(new(int64)),
+		Max:          settings.Max.or(nil),
+		Goal:         settings.Goal.or(nil),
+		BoundMode:    settings.BoundMode,
+	}
+	if c.Frequency == "" {
+		c.Frequency = "1d"
+	}
+	if c.Timezone == "" {
+		c.Timezone = "UTC"
+	}
+	if c.ScheduleType == "" {
+		c.ScheduleType = db.ScheduleFrequency
+	}
+	if c.BoundMode == "" {
+		c.BoundMode = BoundClamp
+	}
+	if err := c.validateBounds(); err != nil {
 		return nil, err
 	}
-	return &c, nil
+	weekStart, err := parseWeekStart(settings.WeekStart)
+	if err != nil {
+		return nil, err
+	}
+	c.setWeekStart(weekStart)
+	anchor, err := parseAnchor(settings.Anchor)
+	if err != nil {
+		return nil, err
+	}
+	c.setAnchor(anchor)
+	if err := c.pinRRuleStart(); err != nil {
+		return nil, err
+	}
+	if err := c.loadHolidays(ctx, pool); err != nil {
+		return nil, err
+	}
+	if err := c.validateSchedule(); err != nil {
+		return nil, err
+	}
+
+	created, err := scanCounter(pool.QueryRow(ctx,
+		`INSERT INTO counters (name, frequency, timezone, week_start, anchor, schedule_type, schedule, calendar_id,
+		 min_value, max_value, goal, bound_mode)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		 RETURNING `+counterColumns,
+		c.Name, c.Frequency, c.Timezone, int16(c.weekStart), c.anchor, c.ScheduleType, c.Schedule, c.CalendarID,
+		c.Min, c.Max, c.Goal, c.BoundMode))
+	if err != nil {
+		return nil, translateWriteErr(err)
+	}
+	return created, nil
 }
 
-func GetAllCounters(ctx context.Context, pool *pgxpool.Pool) ([]Counter, error) {
-	rows, err := pool.Query(ctx, "SELECT id, name, frequency, timezone, created_at::TEXT FROM counters ORDER BY id")
+// GetAllCounters lists counters ordered by ID. Archived counters are only
+// included when includeArchived is set.
+func GetAllCounters(ctx context.Context, pool *pgxpool.Pool, includeArchived bool) ([]Counter, error) {
+	rows, err := pool.Query(ctx,
+		"SELECT "+counterColumns+" FROM counters WHERE $1 OR archived_at IS NULL ORDER BY id",
+		includeArchived)
 	if err != nil {
 		return nil, err
 	}
@@ -41,42 +340,327 @@ func GetAllCounters(ctx context.Context, pool *pgxpool.Pool) ([]Counter, error)
 
 	var out []Counter
 	for rows.Next() {
-		var c Counter
-		if err := rows.Scan(&c.ID, &c.Name, &c.Frequency, &c.Timezone, &c.CreatedAt); err != nil {
+		c, err := scanCounter(rows)
+		if err != nil {
 			return nil, err
 		}
-		out = append(out, c)
+		out = append(out, *c)
 	}
-	return out, nil
+	return out, rows.Err()
+}
+
+// CounterQuery filters and pages the counter listing. From is inclusive and To
+// exclusive on the creation time; zero times leave the range open. Order is
+// OrderAsc (the default) or OrderDesc by ID. Cursor continues a previous page.
+type CounterQuery struct {
+	IncludeArchived bool
+	From            time.Time
+	To              time.Time
+	Order           string
+	Limit           int
+	Cursor          *Cursor
+}
+
+// GetCounters returns a page of counters and the cursor of the next page, which
+// is nil on the last page.
+func GetCounters(ctx context.Context, pool *pgxpool.Pool, q CounterQuery) ([]Counter, *Cursor, error) {
+	dir, op, err := keysetOrder(q.Order, OrderAsc)
+	if err != nil {
+		return nil, nil, err
+	}
+	limit := pageLimit(q.Limit)
+	from, to := optionalTime(q.From), optionalTime(q.To)
+	var cursorID *int64
+	if q.Cursor != nil {
+		cursorID = &q.Cursor.ID
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	rows, err := pool.Query(ctx,
+		`SELECT `+counterColumns+` FROM counters
+		 WHERE ($1 OR archived_at IS NULL)
+		   AND ($2::timestamptz IS NULL OR created_at >= $2)
+		   AND ($3::timestamptz IS NULL OR created_at < $3)
+		   AND ($4::bigint IS NULL OR id `+op+` $4)
+		 ORDER BY id `+dir+`
+		 LIMIT $5`,
+		q.IncludeArchived, from, to, cursorID, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	counters := []Counter{}
+	for rows.Next() {
+		c, err := scanCounter(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		counters = append(counters, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(counters) <= limit {
+		return counters, nil, nil
+	}
+	counters = counters[:limit]
+	return counters, &Cursor{ID: counters[limit-1].ID}, nil
+}
+
+// optionalTime returns nil for the zero time, so it is passed to SQL as NULL.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
 }
 
 func GetCounterByID(ctx context.Context, pool *pgxpool.Pool, id int64) (*Counter, error) {
-	var c Counter
-	err := pool.QueryRow(ctx, "SELECT id, name, frequency, timezone, created_at::TEXT FROM counters WHERE id=$1", id).Scan(&c.ID, &c.Name, &c.Frequency, &c.Timezone, &c.CreatedAt)
+	return getCounter(ctx, pool, id)
+}
+
+func getCounter(ctx context.Context, q querier, id int64) (*Counter, error) {
+	return scanCounter(q.QueryRow(ctx, "SELECT "+counterColumns+" FROM counters WHERE id=$1", id))
+}
+
+// lockCounter is getCounter that also locks the counter row until the end of
+// tx. Transactions that change counts take this lock first: a trigger updates
+// the counter's lifetime totals when its counts change, and UpdateCounter locks
+// the counter before re-aligning counts, so the other order could deadlock.
+func lockCounter(ctx context.Context, tx pgx.Tx, id int64) (*Counter, error) {
+	return scanCounter(tx.QueryRow(ctx, "SELECT "+counterColumns+" FROM counters WHERE id=$1 FOR NO KEY UPDATE", id))
+}
+
+// CounterUpdate holds the counter settings to change. Nil and unset fields are
+// left as-is; an empty Anchor removes the anchor (an RRULE schedule without a
+// DTSTART is anchored to today instead), a zero CalendarID removes the
+// calendar and a null Min, Max or Goal removes that bound or the goal.
+type CounterUpdate struct {
+	Name         *string
+	Frequency    *string
+	Timezone     *string
+	WeekStart    *string
+	Anchor       *string
+	ScheduleType *string
+	Schedule     *string
+	CalendarID   *int64
+	Min          NullableInt
+	Max          NullableInt
+	Goal         NullableInt
+	BoundMode    *string
+}
+
+// UpdateCounter applies a partial update to a counter. When any schedule setting
+// changes, the current period's expiry is re-aligned to the new schedule in the
+// same transaction, so the running count is kept but rolls over on time. New
+// bounds are not applied to the running count until it is next changed.
+func UpdateCounter(ctx context.Context, pool *pgxpool.Pool, id int64, u CounterUpdate) (*Counter, error) {
+	tx, err := pool.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &c, nil
+	defer tx.Rollback(ctx)
+
+	c, err := scanCounter(tx.QueryRow(ctx, "SELECT "+counterColumns+" FROM counters WHERE id=$1 FOR UPDATE", id))
+	if err != nil {
+		return nil, err
+	}
+
+	before := *c
+	if u.Name != nil {
+		if *u.Name == "" {
+			return nil, fmt.Errorf("%w: name must not be empty", ErrInvalidInput)
+		}
+		c.Name = *u.Name
+	}
+	if u.Frequency != nil {
+		c.Frequency = *u.Frequency
+	}
+	if u.Timezone != nil {
+		c.Timezone = *u.Timezone
+	}
+	if u.WeekStart != nil {
+		weekStart, err := parseWeekStart(*u.WeekStart)
+		if err != nil {
+			return nil, err
+		}
+		c.setWeekStart(weekStart)
+	}
+	if u.Anchor != nil {
+		anchor, err := parseAnchor(*u.Anchor)
+		if err != nil {
+			return nil, err
+		}
+		c.setAnchor(anchor)
+	}
+	if u.ScheduleType != nil {
+		c.ScheduleType = *u.ScheduleType
+		if c.ScheduleType == db.ScheduleFrequency && u.Schedule == nil {
+			c.Schedule = ""
+		}
+	}
+	if u.Schedule != nil {
+		c.Schedule = *u.Schedule
+	}
+	if u.CalendarID != nil {
+		c.CalendarID = u.CalendarID
+		if *u.CalendarID == 0 {
+			c.CalendarID = nil
+		}
+	}
+	if err := c.pinRRuleStart(); err != nil {
+		return nil, err
+	}
+	c.Min, c.Max, c.Goal = u.Min.or(c.Min), u.Max.or(c.Max), u.Goal.or(c.Goal)
+	if u.BoundMode != nil {
+		c.BoundMode = *u.BoundMode
+	}
+	if err := c.validateBounds(); err != nil {
+		return nil, err
+	}
+	if err := c.loadHolidays(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := c.validateSchedule(); err != nil {
+		return nil, err
+	}
+	scheduleChanged := c.Frequency != before.Frequency || c.Timezone != before.Timezone ||
+		c.WeekStart != before.WeekStart || !equalStringPtr(c.Anchor, before.Anchor) ||
+		c.ScheduleType != before.ScheduleType || c.Schedule != before.Schedule ||
+		!equalInt64Ptr(c.CalendarID, before.CalendarID)
+
+	c, err = scanCounter(tx.QueryRow(ctx,
+		`UPDATE counters SET name = $1, frequency = $2, timezone = $3, week_start = $4, anchor = $5,
+		 schedule_type = $6, schedule = $7, calendar_id = $8,
+		 min_value = $9, max_value = $10, goal = $11, bound_mode = $12
+		 WHERE id = $13 RETURNING `+counterColumns,
+		c.Name, c.Frequency, c.Timezone, int16(c.weekStart), c.anchor, c.ScheduleType, c.Schedule, c.CalendarID,
+		c.Min, c.Max, c.Goal, c.BoundMode, id))
+	if err != nil {
+		return nil, translateWriteErr(err)
+	}
+
+	if scheduleChanged {
+		if err := realignCurrentCount(ctx, tx, c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// realignCurrentCount moves the boundaries of the counter's running period, and
+// those of its windows, to its current schedule, keeping the values. The
+// period starts where the schedule's does, but not before the end of the
+// period before it.
+func realignCurrentCount(ctx context.Context, q querier, c *Counter) error {
+	if err := c.loadHolidays(ctx, q); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	expiry, err := c.nextExpiry(now)
+	if err != nil {
+		return err
+	}
+	start, err := c.periodStart(now)
+	if err != nil {
+		return err
+	}
+	if _, err := q.Exec(ctx,
+		`UPDATE counts SET expiry = $1,
+		 period_start = GREATEST($2, (SELECT max(expiry) FROM counts WHERE counter_id = $3 AND expiry <= $4))
+		 WHERE counter_id = $3 AND expiry > $4`,
+		expiry, start, c.ID, now); err != nil {
+		return err
+	}
+	return realignWindows(ctx, q, c, now)
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func equalInt64Ptr(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
 
 func UpdateCounterFrequency(ctx context.Context, pool *pgxpool.Pool, id int64, frequency string) (*Counter, error) {
-	tag, err := pool.Exec(ctx, "UPDATE counters SET frequency = $1 WHERE id = $2", frequency, id)
+	return UpdateCounter(ctx, pool, id, CounterUpdate{Frequency: &frequency})
+}
+
+// DeleteCounter removes a counter together with its count history.
+func DeleteCounter(ctx context.Context, pool *pgxpool.Pool, id int64) error {
+	tag, err := pool.Exec(ctx, "DELETE FROM counters WHERE id = $1", id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if tag.RowsAffected() == 0 {
-		return nil, errors.New("not found")
+		return ErrNotFound
 	}
-	return GetCounterByID(ctx, pool, id)
+	return nil
 }
 
-// Count represents a count record with value and expiry aligned to calendar boundaries.
+// SetCounterArchived archives or unarchives a counter. Archived counters are
+// hidden from listings but keep their count history.
+func SetCounterArchived(ctx context.Context, pool *pgxpool.Pool, id int64, archived bool) (*Counter, error) {
+	return scanCounter(pool.QueryRow(ctx,
+		`UPDATE counters SET archived_at = CASE WHEN $1 THEN COALESCE(archived_at, now()) END
+		 WHERE id = $2 RETURNING `+counterColumns,
+		archived, id))
+}
+
+// Count represents a count record with value and a period from PeriodStart to
+// Expiry aligned to calendar boundaries. Filled marks a period without a record
+// that was filled in for a gap-filled history; it has no ID or CreatedAt.
+// Goal is the counter's goal and Progress the fraction of it reached, which
+// exceeds 1 once the goal is passed; both are omitted for counters without a goal.
+// Window names the window of a window count; see Window.
 type Count struct {
-	ID        int64  `json:"id"`
-	CounterID int64  `json:"counter_id"`
-	Value     int64  `json:"value"`
-	Expiry    string `json:"expiry"`
-	CreatedAt string `json:"created_at"`
+	ID          int64    `json:"id"`
+	CounterID   int64    `json:"counter_id"`
+	Window      string   `json:"window,omitempty"`
+	Value       int64    `json:"value"`
+	PeriodStart string   `json:"period_start"`
+	Expiry      string   `json:"expiry"`
+	CreatedAt   string   `json:"created_at,omitempty"`
+	Filled      bool     `json:"filled,omitempty"`
+	Goal        *int64   `json:"goal,omitempty"`
+	Progress    *float64 `json:"progress,omitempty"`
+
+	periodStart time.Time
+	expiry      time.Time
+}
+
+// countColumns lists the counts columns read by scanCount, in order.
+const countColumns = "id, counter_id, value, period_start, expiry, created_at"
+
+// scanCount scans a single counts row selected with countColumns.
+func scanCount(row pgx.Row) (*Count, error) {
+	var c Count
+	var createdAt time.Time
+	if err := row.Scan(&c.ID, &c.CounterID, &c.Value, &c.periodStart, &c.expiry, &createdAt); err != nil {
+		return nil, err
+	}
+	c.setPeriod(c.periodStart, c.expiry)
+	c.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	return &c, nil
+}
+
+func (c *Count) setPeriod(start, expiry time.Time) {
+	c.periodStart, c.expiry = start, expiry
+	c.PeriodStart = start.UTC().Format(time.RFC3339)
+	c.Expiry = expiry.UTC().Format(time.RFC3339)
 }
 
 // GetOrCreateCurrentCount retrieves the current (non-expired) count for a counter,
@@ -87,93 +671,133 @@ func GetOrCreateCurrentCount(ctx context.Context, pool *pgxpool.Pool, counterID
 		return nil, err
 	}
 
-	var c Count
-	// Try to get the current (latest) count
-	var expiryTime time.Time
-	var createdAt time.Time
-	err = pool.QueryRow(ctx,
-		"SELECT id, counter_id, value, expiry, created_at FROM counts WHERE counter_id = $1 ORDER BY id DESC LIMIT 1",
-		counterID).Scan(&c.ID, &c.CounterID, &c.Value, &expiryTime, &createdAt)
-	if err != nil {
+	// Try to get the current (latest) count. Backdated increments can add rows
+	// for past periods later, so the latest period is the one expiring last.
+	c, err := scanCount(pool.QueryRow(ctx,
+		"SELECT "+countColumns+" FROM counts WHERE counter_id = $1 ORDER BY expiry DESC LIMIT 1",
+		counterID))
+	if errors.Is(err, pgx.ErrNoRows) {
 		// No count exists yet, create one
-		return createNewCount(ctx, pool, counterID, counter.Frequency, counter.Timezone)
+		c, err = createCurrentCount(ctx, pool, counterID)
+	}
+	if err != nil {
+		return nil, err
 	}
-
-	c.Expiry = expiryTime.UTC().Format(time.RFC3339)
-	c.CreatedAt = createdAt.UTC().Format(time.RFC3339)
 
 	// Check if current count is expired
-	if time.Now().UTC().After(expiryTime) {
+	if time.Now().UTC().After(c.expiry) {
 		// Expired, create new count
-		return createNewCount(ctx, pool, counterID, counter.Frequency, counter.Timezone)
+		c, err = createCurrentCount(ctx, pool, counterID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &c, nil
+	c.setGoal(counter.Goal)
+	return c, nil
 }
 
-// createNewCount creates a new count record with value 0 and expiry based on the counter's frequency and timezone.
-func createNewCount(ctx context.Context, pool *pgxpool.Pool, counterID int64, frequency string, timezone string) (*Count, error) {
-	expiry, err := db.NextExpiryTime(frequency, time.Now().UTC(), timezone)
+// createCurrentCount returns the count of the period containing now, creating
+// it with the counter locked; see lockCounter.
+func createCurrentCount(ctx context.Context, pool *pgxpool.Pool, counterID int64) (*Count, error) {
+	tx, err := pool.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback(ctx)
 
-	var c Count
-	var expiryTime time.Time
-	var createdAt time.Time
-	err = pool.QueryRow(ctx,
-		"INSERT INTO counts (counter_id, value, expiry) VALUES ($1, 0, $2) RETURNING id, counter_id, value, expiry, created_at",
-		counterID, expiry).Scan(&c.ID, &c.CounterID, &c.Value, &expiryTime, &createdAt)
-	if err == nil {
-		c.Expiry = expiryTime.UTC().Format(time.RFC3339)
-		c.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	counter, err := lockCounter(ctx, tx, counterID)
+	if err != nil {
+		return nil, err
 	}
+	c, err := countAt(ctx, tx, counter, time.Now().UTC())
 	if err != nil {
 		return nil, err
 	}
-	return &c, nil
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-// IncrementCurrentCount increments the current count by delta. If expired, creates a new one first.
-// Delta must be non-zero and positive. The count value is clamped to zero (no negative values).
-func IncrementCurrentCount(ctx context.Context, pool *pgxpool.Pool, counterID int64, delta int64) (*Count, error) {
-	// Validate delta: must be non-zero
-	if delta == 0 {
-		return nil, errors.New("delta must be non-zero")
+// countAt returns the count record of the period containing at, creating it
+// with the counter's initial value if it is missing. The period is the one with the earliest expiry
+// after at, as long as that expiry is not later than the one the counter's
+// schedule gives for at; rows left over from an earlier schedule are reused
+// this way. Concurrent callers crossing the same boundary compute the same
+// expiry, so the unique (counter_id, expiry) constraint makes them all converge
+// on one row.
+func countAt(ctx context.Context, q querier, counter *Counter, at time.Time) (*Count, error) {
+	if err := counter.loadHolidays(ctx, q); err != nil {
+		return nil, err
+	}
+	c, err := peekCount(ctx, q, counter, at)
+	if err != nil || !c.Filled {
+		return c, err
 	}
 
-	current, err := GetOrCreateCurrentCount(ctx, pool, counterID)
+	return scanCount(q.QueryRow(ctx,
+		`INSERT INTO counts (counter_id, value, period_start, expiry) VALUES ($1, $3, $4, $2)
+		 ON CONFLICT (counter_id, expiry) DO UPDATE SET value = counts.value
+		 RETURNING `+countColumns,
+		counter.ID, c.expiry, c.Value, c.periodStart))
+}
+
+// peekCount is countAt without creating the record: a missing one is returned
+// as a Filled count with the counter's initial value. The counter's holidays
+// must be loaded.
+func peekCount(ctx context.Context, q querier, counter *Counter, at time.Time) (*Count, error) {
+	expiry, err := counter.nextExpiry(at)
 	if err != nil {
 		return nil, err
 	}
 
-	// Calculate new value and clamp to zero
-	newValue := current.Value + delta
-	if newValue < 0 {
-		newValue = 0
+	c, err := scanCount(q.QueryRow(ctx,
+		"SELECT "+countColumns+" FROM counts WHERE counter_id = $1 AND expiry > $2 ORDER BY expiry LIMIT 1",
+		counter.ID, at))
+	if err == nil && !c.expiry.After(expiry) {
+		return c, nil
 	}
-
-	// Update the value
-	var c Count
-	var expiryTime time.Time
-	var createdAt time.Time
-	err = pool.QueryRow(ctx,
-		"UPDATE counts SET value = $1 WHERE id = $2 RETURNING id, counter_id, value, expiry, created_at",
-		newValue, current.ID).Scan(&c.ID, &c.CounterID, &c.Value, &expiryTime, &createdAt)
-	if err == nil {
-		c.Expiry = expiryTime.UTC().Format(time.RFC3339)
-		c.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
 	}
+	start, err := counter.periodStart(at)
 	if err != nil {
 		return nil, err
 	}
-	return &c, nil
+	c = &Count{CounterID: counter.ID, Value: counter.initialValue(), Filled: true}
+	c.setPeriod(start, expiry)
+	return c, nil
+}
+
+// IncrementCurrentCount increments the current count by delta. If expired, creates a new one first.
+// Delta must be non-zero. The count value is kept within the counter's bounds,
+// which by default clamp it at zero (no negative values).
+// The change is recorded in the counter's event log; see ApplyMutation.
+func IncrementCurrentCount(ctx context.Context, pool *pgxpool.Pool, counterID int64, delta int64) (*Count, error) {
+	return ApplyMutation(ctx, pool, counterID, Mutation{Delta: delta})
+}
+
+// SetCurrentCount sets the current count to value, recorded as a set event.
+func SetCurrentCount(ctx context.Context, pool *pgxpool.Pool, counterID int64, value int64) (*Count, error) {
+	return ApplyMutation(ctx, pool, counterID, Mutation{Kind: EventSet, Value: value})
+}
+
+// ResetCurrentCount sets the current count back to the counter's initial value,
+// zero unless that is outside its bounds, recorded as a reset event.
+func ResetCurrentCount(ctx context.Context, pool *pgxpool.Pool, counterID int64) (*Count, error) {
+	return ApplyMutation(ctx, pool, counterID, Mutation{Kind: EventReset})
 }
 
-// GetCountHistory retrieves all count records for a counter, ordered by creation time descending.
+// GetCountHistory retrieves all count records for a counter, newest period first.
 func GetCountHistory(ctx context.Context, pool *pgxpool.Pool, counterID int64) ([]Count, error) {
+	var goal *int64
+	err := pool.QueryRow(ctx, "SELECT goal FROM counters WHERE id = $1", counterID).Scan(&goal)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
 	rows, err := pool.Query(ctx,
-		"SELECT id, counter_id, value, expiry, created_at FROM counts WHERE counter_id = $1 ORDER BY created_at DESC",
+		"SELECT "+countColumns+" FROM counts WHERE counter_id = $1 ORDER BY expiry DESC",
 		counterID)
 	if err != nil {
 		return nil, err
@@ -182,15 +806,113 @@ func GetCountHistory(ctx context.Context, pool *pgxpool.Pool, counterID int64) (
 
 	var counts []Count
 	for rows.Next() {
-		var c Count
-		var expiryTime time.Time
-		var createdAt time.Time
-		if err := rows.Scan(&c.ID, &c.CounterID, &c.Value, &expiryTime, &createdAt); err != nil {
+		c, err := scanCount(rows)
+		if err != nil {
 			return nil, err
 		}
-		c.Expiry = expiryTime.UTC().Format(time.RFC3339)
-		c.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		c.setGoal(goal)
+		counts = append(counts, *c)
+	}
+	return counts, rows.Err()
+}
+
+// CountQuery filters and pages a counter's count history. From and To select
+// the periods that overlap [From, To); zero times leave the range open. Order
+// is OrderDesc (the default, newest period first) or OrderAsc by expiry.
+// Fill includes the periods without a record since the counter was created,
+// as Filled counts with the value a new period starts with. Cursor continues
+// a previous page.
+type CountQuery struct {
+	From   time.Time
+	To     time.Time
+	Order  string
+	Fill   bool
+	Limit  int
+	Cursor *Cursor
+}
+
+// GetCounts returns a page of a counter's count history and the cursor of the
+// next page, which is nil on the last page.
+func GetCounts(ctx context.Context, pool *pgxpool.Pool, counterID int64, q CountQuery) ([]Count, *Cursor, error) {
+	dir, op, err := keysetOrder(q.Order, OrderDesc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if q.Fill {
+		return getFilledCounts(ctx, pool, counterID, q)
+	}
+	var goal *int64
+	if err := pool.QueryRow(ctx, "SELECT goal FROM counters WHERE id = $1", counterID).Scan(&goal); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	limit := pageLimit(q.Limit)
+	from, to := optionalTime(q.From), optionalTime(q.To)
+	var cursorTime *time.Time
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorTime, cursorID = &q.Cursor.Time, q.Cursor.ID
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	rows, err := pool.Query(ctx,
+		`SELECT `+countColumns+` FROM counts
+		 WHERE counter_id = $1
+		   AND ($2::timestamptz IS NULL OR expiry > $2)
+		   AND ($3::timestamptz IS NULL OR period_start < $3)
+		   AND ($4::timestamptz IS NULL OR (expiry, id) `+op+` ($4, $5))
+		 ORDER BY expiry `+dir+`, id `+dir+`
+		 LIMIT $6`,
+		counterID, from, to, cursorTime, cursorID, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	counts := []Count{}
+	for rows.Next() {
+		c, err := scanCount(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.setGoal(goal)
+		counts = append(counts, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(counts) <= limit {
+		return counts, nil, nil
+	}
+	counts = counts[:limit]
+	last := counts[limit-1]
+	return counts, &Cursor{Time: last.expiry, ID: last.ID}, nil
+}
+
+// getFilledCounts pages the gap-filled history, laying out only the periods
+// of the page.
+func getFilledCounts(ctx context.Context, pool *pgxpool.Pool, counterID int64, q CountQuery) ([]Count, *Cursor, error) {
+	counter, err := getCounter(ctx, pool, counterID)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := counterHistory(ctx, pool, counter, time.Now().UTC())
+	if err != nil {
+		return nil, nil, err
+	}
+	periods, next, err := h.page(ctx, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	blank := Count{CounterID: counterID, Value: counter.initialValue(), Filled: true}
+	counts := make([]Count, 0, len(periods))
+	for _, p := range periods {
+		c := p.asCount(blank)
+		c.setGoal(counter.Goal)
 		counts = append(counts, c)
 	}
-	return counts, nil
+	return counts, next, nil
 }
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page size bounds of the paginated listings.
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// pageLimit applies the default to a missing limit and caps it at MaxPageLimit.
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return limit
+}
+
+// Cursor is a keyset pagination position: the sort time and ID of the last row
+// of the previous page.
+type Cursor struct {
+	Time time.Time
+	ID   int64
+}
+
+// String encodes the cursor as an opaque URL-safe token.
+func (c Cursor) String() string {
+	raw := strconv.FormatInt(c.Time.UnixNano(), 10) + "." + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a token produced by Cursor.String.
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidInput)
+	}
+	nanos, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidInput)
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidInput)
+	}
+	c := Cursor{Time: time.Unix(0, n).UTC()}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidInput)
+	}
+	return &c, nil
+}
+
+// Sort orders of the paginated listings.
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// keysetOrder returns the SQL sort direction for order, or for def when order
+// is empty, and the operator that selects the rows after a cursor in it.
+func keysetOrder(order, def string) (dir, op string, err error) {
+	if order == "" {
+		order = def
+	}
+	switch order {
+	case OrderAsc:
+		return "ASC", ">", nil
+	case OrderDesc:
+		return "DESC", "<", nil
+	}
+	return "", "", fmt.Errorf("%w: order must be %s or %s", ErrInvalidInput, OrderAsc, OrderDesc)
+}
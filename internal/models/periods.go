@@ -0,0 +1,319 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/iben12/counter-app/internal/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// period is one period of a counter. count is nil when the period has no
+// counts row because nobody touched the counter during it.
+type period struct {
+	start time.Time
+	end   time.Time
+	count *Count
+}
+
+// asCount returns the row of p, or blank when it has none, spanning p.
+func (p period) asCount(blank Count) Count {
+	c := blank
+	if p.count != nil {
+		c = *p.count
+	}
+	c.setPeriod(p.start, p.end)
+	return c
+}
+
+// walkPeriods lays out a counter's periods from start, or its earliest row if
+// that begins before, up to and including the one containing now. counts must
+// be sorted by expiry. Every row makes a period of its own, so rows from before
+// a schedule change keep their boundaries; the gaps between rows are split into
+// periods by s. A scheduled period that would run into the next row is left to
+// that row. With a positive limit, the walk stops after that many periods.
+func walkPeriods(s db.Scheduler, counts []Count, start, now time.Time, limit int) ([]period, error) {
+	var out []period
+	full := func() bool { return limit > 0 && len(out) == limit }
+	t := start
+	if len(counts) > 0 && counts[0].periodStart.Before(t) {
+		t = counts[0].periodStart
+	}
+	for i := range counts {
+		c := &counts[i]
+		for t.Before(c.periodStart) && !full() {
+			end, err := s.Next(t)
+			if err != nil {
+				return nil, err
+			}
+			if end.After(c.periodStart) {
+				break
+			}
+			out = append(out, period{start: t, end: end})
+			t = end
+		}
+		if full() {
+			return out, nil
+		}
+		out = append(out, period{start: c.periodStart, end: c.expiry, count: c})
+		if c.expiry.After(t) {
+			t = c.expiry
+		}
+	}
+	for !t.After(now) && !full() {
+		end, err := s.Next(t)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, period{start: t, end: end})
+		t = end
+	}
+	return out, nil
+}
+
+// periodHistory is the period rows of a counter or of one of its windows,
+// together with the schedule that splits the gaps between them. Its methods
+// lay out a part of the history with walkPeriods and load only the rows of
+// that part, so a page costs the same however long the history is.
+type periodHistory struct {
+	q   querier
+	s   db.Scheduler
+	now time.Time
+	// start is the start of the period the owner was created in.
+	start time.Time
+	// table holds the rows, selected by key = id and read with columns and scan.
+	table   string
+	key     string
+	id      int64
+	columns string
+	scan    func(pgx.Row) (*Count, error)
+}
+
+// counterHistory returns the history of a counter's counts, beginning with
+// the period it was created in.
+func counterHistory(ctx context.Context, q querier, counter *Counter, now time.Time) (*periodHistory, error) {
+	var createdAt time.Time
+	if err := q.QueryRow(ctx, "SELECT created_at FROM counters WHERE id = $1", counter.ID).Scan(&createdAt); err != nil {
+		return nil, err
+	}
+	if err := counter.loadHolidays(ctx, q); err != nil {
+		return nil, err
+	}
+	s, err := counter.scheduler()
+	if err != nil {
+		return nil, err
+	}
+	start, err := counter.periodStart(createdAt)
+	if err != nil {
+		return nil, err
+	}
+	return &periodHistory{q: q, s: s, now: now, start: start,
+		table: "counts", key: "counter_id", id: counter.ID, columns: countColumns, scan: scanCount}, nil
+}
+
+// windowHistory returns the history of a window's counts, beginning with the
+// period the window was created in.
+func windowHistory(ctx context.Context, q querier, counter *Counter, w *Window, now time.Time) (*periodHistory, error) {
+	var createdAt time.Time
+	if err := q.QueryRow(ctx, "SELECT created_at FROM counter_windows WHERE id = $1", w.ID).Scan(&createdAt); err != nil {
+		return nil, err
+	}
+	if err := counter.loadHolidays(ctx, q); err != nil {
+		return nil, err
+	}
+	s, err := w.scheduler(counter)
+	if err != nil {
+		return nil, err
+	}
+	start, err := periodStartIn(s, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	scan := func(row pgx.Row) (*Count, error) { return scanWindowCount(row, w) }
+	return &periodHistory{q: q, s: s, now: now, start: start,
+		table: "window_counts", key: "window_id", id: w.ID, columns: windowCountColumns, scan: scan}, nil
+}
+
+// rows loads the rows matching cond, which may refer to $2 and on, ordered by
+// expiry in dir. A positive limit caps their number.
+func (h *periodHistory) rows(ctx context.Context, cond, dir string, limit int, args ...any) ([]Count, error) {
+	sql := "SELECT " + h.columns + " FROM " + h.table + " WHERE " + h.key + " = $1 AND " + cond + " ORDER BY expiry " + dir
+	if limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	rows, err := h.q.Query(ctx, sql, append([]any{h.id}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []Count
+	for rows.Next() {
+		c, err := h.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, *c)
+	}
+	return counts, rows.Err()
+}
+
+// between lays out the periods that end after lo and start before hi, up to
+// and including the running one, in ascending order. Zero times leave the
+// range open. With a positive limit, at most limit periods are laid out,
+// beginning at lo.
+func (h *periodHistory) between(ctx context.Context, lo, hi time.Time, limit int) ([]period, error) {
+	t := h.start
+	if !lo.IsZero() {
+		// A walk from the start of the history passes lo in the period that
+		// begins at the later of the schedule's last boundary and the end of
+		// the last row before it
+		var prev *time.Time
+		if err := h.q.QueryRow(ctx,
+			"SELECT max(expiry) FROM "+h.table+" WHERE "+h.key+" = $1 AND expiry <= $2",
+			h.id, lo).Scan(&prev); err != nil {
+			return nil, err
+		}
+		if prev != nil || lo.After(t) {
+			start, err := periodStartIn(h.s, lo)
+			if err != nil {
+				return nil, err
+			}
+			t = start
+			if prev != nil && prev.After(t) {
+				t = *prev
+			}
+		}
+	}
+	end := h.now
+	if !hi.IsZero() && !hi.After(end) {
+		end = hi.Add(-time.Nanosecond)
+	}
+	counts, err := h.rows(ctx,
+		"($2::timestamptz IS NULL OR expiry > $2) AND ($3::timestamptz IS NULL OR period_start < $3)",
+		"ASC", limit, optionalTime(lo), optionalTime(hi))
+	if err != nil {
+		return nil, err
+	}
+	if !hi.IsZero() && (limit <= 0 || len(counts) < limit) {
+		// The walk leaves the period before hi out if it runs into the next row
+		next, err := h.rows(ctx, "period_start >= $2", "ASC", 1, hi)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, next...)
+	}
+	periods, err := walkPeriods(h.s, counts, t, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	for len(periods) > 0 && !hi.IsZero() && !periods[len(periods)-1].start.Before(hi) {
+		periods = periods[:len(periods)-1]
+	}
+	return periods, nil
+}
+
+// page lays out the periods of one page of the history that q asks for, in
+// its order (newest first by default), and returns the cursor of the next
+// page. Periods without a row have no ID, so the cursor only carries the
+// expiry, which is unique among the periods.
+func (h *periodHistory) page(ctx context.Context, q CountQuery) ([]period, *Cursor, error) {
+	limit := pageLimit(q.Limit)
+	var periods []period
+	var err error
+	if q.Order == OrderAsc {
+		lo := q.From
+		if q.Cursor != nil && q.Cursor.Time.After(lo) {
+			lo = q.Cursor.Time
+		}
+		periods, err = h.between(ctx, lo, q.To, limit+1)
+	} else {
+		periods, err = h.latest(ctx, q, limit+1)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(periods) <= limit {
+		return periods, nil, nil
+	}
+	periods = periods[:limit]
+	return periods, &Cursor{Time: periods[limit-1].end}, nil
+}
+
+// latest lays out up to n of the newest periods that end after q.From, start
+// before q.To and, with a cursor, end before it, newest first. Schedules only
+// look forward, so it walks forward from a point estimated to lie n periods
+// back, and moves that point further back until n periods are found or the
+// history begins.
+func (h *periodHistory) latest(ctx context.Context, q CountQuery, n int) ([]period, error) {
+	hi := q.To
+	if q.Cursor != nil && (hi.IsZero() || q.Cursor.Time.Before(hi)) {
+		hi = q.Cursor.Time
+	}
+	top := h.now
+	if !hi.IsZero() && hi.Before(top) {
+		top = hi
+	}
+
+	// Estimate the span of n periods from the length of the one at top
+	start, err := periodStartIn(h.s, top)
+	if err != nil {
+		return nil, err
+	}
+	end, err := h.s.Next(start)
+	if err != nil {
+		return nil, err
+	}
+	span := time.Duration(n) * end.Sub(start)
+
+	// The n+1 newest rows before hi are n periods at least, even if the last
+	// of them ends at the cursor, so the walk never needs to begin earlier
+	var floor *time.Time
+	err = h.q.QueryRow(ctx,
+		"SELECT period_start FROM "+h.table+" WHERE "+h.key+" = $1 AND ($2::timestamptz IS NULL OR period_start < $2)"+
+			" ORDER BY expiry DESC OFFSET $3 LIMIT 1",
+		h.id, optionalTime(hi), n).Scan(&floor)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	bottom := h.start
+	var first *time.Time
+	if err := h.q.QueryRow(ctx,
+		"SELECT min(period_start) FROM "+h.table+" WHERE "+h.key+" = $1", h.id).Scan(&first); err != nil {
+		return nil, err
+	}
+	if first != nil && first.Before(bottom) {
+		bottom = *first
+	}
+	if q.From.After(bottom) {
+		bottom = q.From
+	}
+
+	for {
+		lo := bottom
+		if span < top.Sub(bottom) {
+			lo = top.Add(-span)
+		}
+		if floor != nil && floor.After(lo) {
+			lo = *floor
+		}
+		periods, err := h.between(ctx, lo, hi, 0)
+		if err != nil {
+			return nil, err
+		}
+		if q.Cursor != nil {
+			for len(periods) > 0 && !periods[len(periods)-1].end.Before(q.Cursor.Time) {
+				periods = periods[:len(periods)-1]
+			}
+		}
+		if len(periods) >= n || lo.Equal(bottom) {
+			slices.Reverse(periods)
+			return periods[:min(n, len(periods))], nil
+		}
+		floor = nil
+		span *= 2
+	}
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iben12/counter-app/internal/db"
+)
+
+// TestWalkPeriods tests that gaps between count rows are filled with the
+// schedule's periods and that rows keep their own boundaries.
+func TestWalkPeriods(t *testing.T) {
+	s, err := db.NewScheduler(db.ScheduleFrequency, "1d", "UTC", db.PeriodOptions{})
+	if err != nil {
+		t.Fatalf("failed to create scheduler: %v", err)
+	}
+	day := func(d int) time.Time { return time.Date(2025, 11, d, 0, 0, 0, 0, time.UTC) }
+	count := func(start, end time.Time, value int64) Count {
+		c := Count{Value: value}
+		c.setPeriod(start, end)
+		return c
+	}
+	counts := []Count{
+		count(day(10), day(11), 1),
+		// Left over from an earlier schedule; the 12 to 13 period runs into it
+		count(day(12).Add(12*time.Hour), day(13).Add(12*time.Hour), 2),
+	}
+
+	periods, err := walkPeriods(s, counts, day(10).Add(9*time.Hour), day(15).Add(time.Hour), 0)
+	if err != nil {
+		t.Fatalf("walkPeriods failed: %v", err)
+	}
+	want := []struct {
+		start time.Time
+		end   time.Time
+		value int64
+		row   bool
+	}{
+		{day(10), day(11), 1, true},
+		{day(11), day(12), 0, false},
+		{day(12).Add(12 * time.Hour), day(13).Add(12 * time.Hour), 2, true},
+		{day(13).Add(12 * time.Hour), day(14), 0, false},
+		{day(14), day(15), 0, false},
+		{day(15), day(16), 0, false},
+	}
+	if len(periods) != len(want) {
+		t.Fatalf("expected %d periods, got %d: %+v", len(want), len(periods), periods)
+	}
+	for i, w := range want {
+		p := periods[i]
+		if !p.start.Equal(w.start) || !p.end.Equal(w.end) || (p.count != nil) != w.row || (p.count != nil && p.count.Value != w.value) {
+			t.Errorf("period %d: expected %v to %v (row %v, value %d), got %+v", i, w.start, w.end, w.row, w.value, p)
+		}
+	}
+
+	limited, err := walkPeriods(s, counts, day(10).Add(9*time.Hour), day(15).Add(time.Hour), 3)
+	if err != nil {
+		t.Fatalf("walkPeriods failed: %v", err)
+	}
+	if len(limited) != 3 || limited[2].count == nil || limited[2].count.Value != 2 {
+		t.Errorf("expected the first 3 periods with a limit, got %+v", limited)
+	}
+}
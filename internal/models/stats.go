@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/iben12/counter-app/internal/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CounterStats summarizes how often a counter's periods met its goal. A period
+// meets the goal when its value reaches Goal, or is positive for counters
+// without a goal. Periods without a count record are misses. The running
+// period adds to the streaks once it meets the goal, but never breaks them and
+// is left out of the completion rate, which is null before the first period ends.
+type CounterStats struct {
+	CounterID      int64    `json:"counter_id"`
+	Goal           *int64   `json:"goal"`
+	CurrentStreak  int      `json:"current_streak"`
+	LongestStreak  int      `json:"longest_streak"`
+	Periods        int      `json:"periods"`
+	MetPeriods     int      `json:"met_periods"`
+	CompletionRate *float64 `json:"completion_rate"`
+}
+
+// GetCounterStats computes a counter's streaks and completion rate from its
+// count history, starting with the period the counter was created in. It goes
+// through the counter's rows and counts the empty periods between them, so the
+// cost grows with the rows rather than with the periods.
+func GetCounterStats(ctx context.Context, pool *pgxpool.Pool, counterID int64) (*CounterStats, error) {
+	counter, err := getCounter(ctx, pool, counterID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	h, err := counterHistory(ctx, pool, counter, now)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := h.rows(ctx, "TRUE", "ASC", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Follow walkPeriods: the gaps before rows hold the scheduled periods that
+	// end by the row's start, and the gap after the last one runs to the period
+	// containing now, which is running and neither meets nor misses the goal
+	b := newStatsBuilder(counter.Goal, now)
+	t := h.start
+	if len(counts) > 0 && counts[0].periodStart.Before(t) {
+		t = counts[0].periodStart
+	}
+	for i := range counts {
+		c := &counts[i]
+		n, err := db.CountPeriods(h.s, t, c.periodStart)
+		if err != nil {
+			return nil, err
+		}
+		b.miss(n)
+		b.add(period{start: c.periodStart, end: c.expiry, count: c})
+		if c.expiry.After(t) {
+			t = c.expiry
+		}
+	}
+	n, err := db.CountPeriods(h.s, t, now)
+	if err != nil {
+		return nil, err
+	}
+	b.miss(n)
+
+	stats := b.result()
+	stats.CounterID = counterID
+	return stats, nil
+}
+
+// computeStats derives the streaks and completion rate of periods, as laid out
+// by walkPeriods.
+func computeStats(periods []period, goal *int64, now time.Time) *CounterStats {
+	b := newStatsBuilder(goal, now)
+	for _, p := range periods {
+		b.add(p)
+	}
+	return b.result()
+}
+
+// statsBuilder accumulates CounterStats over a counter's periods, oldest first.
+type statsBuilder struct {
+	stats  CounterStats
+	now    time.Time
+	streak int
+}
+
+func newStatsBuilder(goal *int64, now time.Time) *statsBuilder {
+	return &statsBuilder{stats: CounterStats{Goal: goal}, now: now}
+}
+
+// add counts in period p.
+func (b *statsBuilder) add(p period) {
+	running := p.end.After(b.now)
+	if b.met(p) {
+		b.streak++
+		if b.streak > b.stats.LongestStreak {
+			b.stats.LongestStreak = b.streak
+		}
+		if !running {
+			b.stats.MetPeriods++
+		}
+	} else if !running {
+		b.streak = 0
+	}
+	if !running {
+		b.stats.Periods++
+	}
+}
+
+// miss counts in n ended periods without a row.
+func (b *statsBuilder) miss(n int) {
+	if n > 0 {
+		b.streak = 0
+		b.stats.Periods += n
+	}
+}
+
+func (b *statsBuilder) met(p period) bool {
+	if p.count == nil {
+		return false
+	}
+	if b.stats.Goal != nil {
+		return p.count.Value >= *b.stats.Goal
+	}
+	return p.count.Value > 0
+}
+
+func (b *statsBuilder) result() *CounterStats {
+	stats := b.stats
+	stats.CurrentStreak = b.streak
+	if stats.Periods > 0 {
+		rate := float64(stats.MetPeriods) / float64(stats.Periods)
+		stats.CompletionRate = &rate
+	}
+	return &stats
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestComputeStats tests streaks and completion rate with and without a goal.
+func TestComputeStats(t *testing.T) {
+	now := time.Date(2025, 11, 15, 12, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2025, 11, d, 0, 0, 0, 0, time.UTC) }
+	with := func(end time.Time, value int64) period { return period{end: end, count: &Count{Value: value}} }
+
+	periods := []period{
+		with(day(10), 3),
+		with(day(11), 2),
+		with(day(12), 5),
+		{end: day(13)}, // no row: a miss
+		with(day(14), 2),
+		with(day(15), 4),
+		with(day(16), 0), // running
+	}
+
+	tests := []struct {
+		name          string
+		goal          *int64
+		current       int
+		longest       int
+		met           int
+		runningValue  int64
+		wantRunningIn bool
+	}{
+		{"any activity", nil, 2, 3, 5, 0, false},
+		{"goal of 3", int64Ptr(3), 1, 1, 3, 0, false},
+		{"running period met", int64Ptr(3), 2, 2, 3, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			periods[len(periods)-1].count.Value = tt.runningValue
+			stats := computeStats(periods, tt.goal, now)
+			if stats.CurrentStreak != tt.current || stats.LongestStreak != tt.longest {
+				t.Errorf("expected streaks %d/%d, got %d/%d", tt.current, tt.longest, stats.CurrentStreak, stats.LongestStreak)
+			}
+			if stats.Periods != 6 || stats.MetPeriods != tt.met {
+				t.Errorf("expected %d of 6 periods met, got %d of %d", tt.met, stats.MetPeriods, stats.Periods)
+			}
+			if stats.CompletionRate == nil || *stats.CompletionRate != float64(tt.met)/6 {
+				t.Errorf("expected completion rate %v, got %v", float64(tt.met)/6, stats.CompletionRate)
+			}
+		})
+	}
+
+	if stats := computeStats([]period{{end: day(16)}}, nil, now); stats.CompletionRate != nil {
+		t.Errorf("expected no completion rate before the first period ends, got %v", *stats.CompletionRate)
+	}
+}
+
+// TestGetCounterStats tests that untouched days since the counter was created count as misses.
+func TestGetCounterStats(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	counter, err := CreateCounter(ctx, pool, "stats-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "UPDATE counters SET created_at = now() - interval '4 days' WHERE id = $1", counter.ID); err != nil {
+		t.Fatalf("failed to backdate counter: %v", err)
+	}
+
+	// Active three and one days ago and today; four and two days ago are misses
+	now := time.Now().UTC()
+	for _, daysAgo := range []int{3, 1, 0} {
+		m := Mutation{Delta: 1}
+		if daysAgo > 0 {
+			m.At = now.AddDate(0, 0, -daysAgo)
+		}
+		if _, err := ApplyMutation(ctx, pool, counter.ID, m); err != nil {
+			t.Fatalf("failed to increment: %v", err)
+		}
+	}
+
+	stats, err := GetCounterStats(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	if stats.CurrentStreak != 2 || stats.LongestStreak != 2 {
+		t.Errorf("expected current and longest streak 2, got %d and %d", stats.CurrentStreak, stats.LongestStreak)
+	}
+	if stats.Periods != 4 || stats.MetPeriods != 2 {
+		t.Errorf("expected 2 of 4 periods met, got %d of %d", stats.MetPeriods, stats.Periods)
+	}
+
+	if _, err := GetCounterStats(ctx, pool, 999999); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
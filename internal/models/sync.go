@@ -0,0 +1,126 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrSyncOpReused is returned for an operation whose OpID was applied before
+// with a different counter or mutation.
+var ErrSyncOpReused = errors.New("op_id was already used for a different operation")
+
+// SyncOp is one client-generated operation of an offline batch. OpID must be
+// unique per client operation; it makes replays of the same batch harmless.
+type SyncOp struct {
+	OpID      string
+	CounterID int64
+	Mutation  Mutation
+}
+
+// RejectedOp reports why a sync operation was not applied.
+type RejectedOp struct {
+	OpID  string `json:"op_id"`
+	Error string `json:"error"`
+}
+
+// SyncResult is the outcome of a batch: the IDs of the operations applied now
+// or by an earlier sync, the rejected ones, and the current count of every
+// counter the batch touched.
+type SyncResult struct {
+	Applied  []string     `json:"applied"`
+	Rejected []RejectedOp `json:"rejected"`
+	Counters []Count      `json:"counters"`
+}
+
+// Sync applies a batch of operations in order, each in its own transaction, so
+// one failing operation does not hold back the others. An operation whose OpID
+// was applied before is reported as applied without being applied again, unless
+// it differs from the one applied, which is rejected with ErrSyncOpReused. New
+// operations happening further back than maxBackdate are rejected; replays are
+// not, so retrying a batch gives the same result however late it is.
+func Sync(ctx context.Context, pool *pgxpool.Pool, ops []SyncOp, maxBackdate time.Duration) (*SyncResult, error) {
+	res := &SyncResult{Applied: []string{}, Rejected: []RejectedOp{}, Counters: []Count{}}
+	var touched []int64
+	seen := map[int64]bool{}
+	for _, op := range ops {
+		if err := applySyncOp(ctx, pool, op, maxBackdate); err != nil {
+			res.Rejected = append(res.Rejected, RejectedOp{OpID: op.OpID, Error: err.Error()})
+		} else {
+			res.Applied = append(res.Applied, op.OpID)
+		}
+		if !seen[op.CounterID] {
+			seen[op.CounterID] = true
+			touched = append(touched, op.CounterID)
+		}
+	}
+
+	for _, id := range touched {
+		cnt, err := GetOrCreateCurrentCount(ctx, pool, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.Counters = append(res.Counters, *cnt)
+	}
+	return res, nil
+}
+
+func applySyncOp(ctx context.Context, pool *pgxpool.Pool, op SyncOp, maxBackdate time.Duration) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := getCounter(ctx, tx, op.CounterID); err != nil {
+		return err
+	}
+	// Claim the op ID first; a concurrent replay of it waits here and then skips
+	m := op.Mutation
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO sync_ops (op_id, counter_id, kind, delta, value) VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (op_id) DO NOTHING`,
+		op.OpID, op.CounterID, m.Kind, m.Delta, m.Value)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return checkSyncReplay(ctx, tx, op)
+	}
+	if !m.At.IsZero() && time.Since(m.At) > maxBackdate {
+		return fmt.Errorf("%w: at is further back than %s", ErrInvalidInput, maxBackdate)
+	}
+
+	_, eventID, err := applyMutation(ctx, tx, op.CounterID, op.Mutation)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE sync_ops SET event_id = $1 WHERE op_id = $2", eventID, op.OpID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// checkSyncReplay compares a replayed operation with the one applied under its
+// OpID. Operations recorded before their mutation was stored are not compared.
+func checkSyncReplay(ctx context.Context, q querier, op SyncOp) error {
+	var counterID int64
+	var kind *string
+	var delta, value *int64
+	if err := q.QueryRow(ctx, "SELECT counter_id, kind, delta, value FROM sync_ops WHERE op_id = $1", op.OpID).
+		Scan(&counterID, &kind, &delta, &value); err != nil {
+		return err
+	}
+	m := op.Mutation
+	if counterID != op.CounterID ||
+		(kind != nil && (*kind != m.Kind || *delta != m.Delta || *value != m.Value)) {
+		return ErrSyncOpReused
+	}
+	return nil
+}
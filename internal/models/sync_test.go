@@ -0,0 +1,109 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSyncIsIdempotent tests that a batch applies each operation once, reports
+// failures per operation and can be replayed without double-counting.
+func TestSyncIsIdempotent(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	water, err := CreateCounter(ctx, pool, "sync-water", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	steps, err := CreateCounter(ctx, pool, "sync-steps", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	ops := []SyncOp{
+		{OpID: "op-1", CounterID: water.ID, Mutation: Mutation{Delta: 2}},
+		{OpID: "op-2", CounterID: steps.ID, Mutation: Mutation{Kind: EventSet, Value: 500}},
+		{OpID: "op-3", CounterID: water.ID, Mutation: Mutation{Delta: -1, At: time.Now().Add(-time.Minute)}},
+		{OpID: "op-4", CounterID: 999, Mutation: Mutation{Delta: 1}},
+		{OpID: "op-5", CounterID: steps.ID, Mutation: Mutation{Kind: EventSet, Value: -3}},
+	}
+	check := func(res *SyncResult) {
+		t.Helper()
+		if len(res.Applied) != 3 || res.Applied[0] != "op-1" || res.Applied[1] != "op-2" || res.Applied[2] != "op-3" {
+			t.Errorf("expected op-1..op-3 applied, got %v", res.Applied)
+		}
+		if len(res.Rejected) != 2 || res.Rejected[0].OpID != "op-4" || res.Rejected[1].OpID != "op-5" {
+			t.Errorf("expected op-4 and op-5 rejected, got %v", res.Rejected)
+		}
+		if len(res.Counters) != 2 {
+			t.Fatalf("expected 2 touched counters, got %v", res.Counters)
+		}
+		if res.Counters[0].CounterID != water.ID || res.Counters[0].Value != 1 {
+			t.Errorf("expected water at 1, got %+v", res.Counters[0])
+		}
+		if res.Counters[1].CounterID != steps.ID || res.Counters[1].Value != 500 {
+			t.Errorf("expected steps at 500, got %+v", res.Counters[1])
+		}
+	}
+
+	res, err := Sync(ctx, pool, ops, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	check(res)
+
+	// Replaying the batch after a lost response changes nothing
+	res, err = Sync(ctx, pool, ops, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to replay sync: %v", err)
+	}
+	check(res)
+
+	// A replay is not rejected once its time has left the backdating window,
+	// while a new operation that far back is
+	res, err = Sync(ctx, pool, ops, time.Second)
+	if err != nil {
+		t.Fatalf("failed to replay sync: %v", err)
+	}
+	check(res)
+	late := []SyncOp{{OpID: "op-7", CounterID: water.ID, Mutation: Mutation{Delta: 1, At: time.Now().Add(-time.Minute)}}}
+	res, err = Sync(ctx, pool, late, time.Second)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(res.Rejected) != 1 || !strings.Contains(res.Rejected[0].Error, ErrInvalidInput.Error()) {
+		t.Errorf("expected the late op rejected as invalid input, got %+v", res)
+	}
+
+	events, _, _ := GetEvents(ctx, pool, steps.ID, EventQuery{})
+	if len(events) != 1 || events[0].Kind != EventSet || events[0].Delta != 500 {
+		t.Errorf("expected a single set event, got %+v", events)
+	}
+
+	// Reusing an op ID for a different operation is rejected, not skipped
+	reused := []SyncOp{
+		{OpID: "op-1", CounterID: steps.ID, Mutation: Mutation{Delta: 2}},
+		{OpID: "op-2", CounterID: steps.ID, Mutation: Mutation{Kind: EventSet, Value: 501}},
+		{OpID: "op-3", CounterID: water.ID, Mutation: Mutation{Delta: 1}},
+		{OpID: "op-6", CounterID: water.ID, Mutation: Mutation{Delta: 0}},
+	}
+	res, err = Sync(ctx, pool, reused, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(res.Applied) != 0 || len(res.Rejected) != 4 {
+		t.Fatalf("expected all ops rejected, got %+v", res)
+	}
+	for _, r := range res.Rejected[:3] {
+		if r.Error != ErrSyncOpReused.Error() {
+			t.Errorf("expected %s rejected as reused, got %q", r.OpID, r.Error)
+		}
+	}
+	if !strings.Contains(res.Rejected[3].Error, ErrInvalidInput.Error()) {
+		t.Errorf("expected a zero delta rejected as invalid input, got %q", res.Rejected[3].Error)
+	}
+}
@@ -0,0 +1,328 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Trigger token actions.
+const (
+	TokenIncrement = "increment"
+	TokenDecrement = "decrement"
+	TokenSet       = "set"
+)
+
+// ErrTokenRevoked is returned when rotating a trigger token that was revoked.
+var ErrTokenRevoked = errors.New("trigger token is revoked")
+
+// TriggerToken is a secret that changes a counter when its URL is requested,
+// for clients that cannot send a JSON body. Action is TokenIncrement or
+// TokenDecrement by Amount, or TokenSet to the value Amount. Token is only
+// returned when the token is created or rotated; the database keeps a hash.
+type TriggerToken struct {
+	ID         int64   `json:"id"`
+	CounterID  int64   `json:"counter_id"`
+	Name       string  `json:"name"`
+	Action     string  `json:"action"`
+	Amount     int64   `json:"amount"`
+	Token      string  `json:"token,omitempty"`
+	CreatedAt  string  `json:"created_at"`
+	RotatedAt  *string `json:"rotated_at"`
+	RevokedAt  *string `json:"revoked_at"`
+	LastUsedAt *string `json:"last_used_at"`
+}
+
+// triggerTokenColumns lists the trigger_tokens columns read by scanTriggerToken, in order.
+const triggerTokenColumns = "id, counter_id, name, action, amount, created_at::TEXT, rotated_at::TEXT, revoked_at::TEXT, last_used_at::TEXT"
+
+// scanTriggerToken scans a single trigger_tokens row selected with triggerTokenColumns.
+func scanTriggerToken(row pgx.Row) (*TriggerToken, error) {
+	var t TriggerToken
+	if err := row.Scan(&t.ID, &t.CounterID, &t.Name, &t.Action, &t.Amount,
+		&t.CreatedAt, &t.RotatedAt, &t.RevokedAt, &t.LastUsedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// newTriggerToken returns a random token and the hash it is stored as.
+func newTriggerToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashTriggerToken(token), nil
+}
+
+// hashTriggerToken returns the hash a token is stored and looked up by.
+func hashTriggerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TriggerTokenSettings holds the settings of a new trigger token. Action
+// defaults to TokenIncrement; the Amount of an increment or decrement must be
+// positive.
+type TriggerTokenSettings struct {
+	Name   string
+	Action string
+	Amount int64
+}
+
+// CreateTriggerToken adds a trigger token to a counter and returns it with
+// the token.
+func CreateTriggerToken(ctx context.Context, pool *pgxpool.Pool, counterID int64, s TriggerTokenSettings) (*TriggerToken, error) {
+	if s.Action == "" {
+		s.Action = TokenIncrement
+	}
+	switch s.Action {
+	case TokenIncrement, TokenDecrement:
+		if s.Amount <= 0 {
+			return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidInput)
+		}
+	case TokenSet:
+	default:
+		return nil, fmt.Errorf("%w: unknown token action: %s", ErrInvalidInput, s.Action)
+	}
+	counter, err := GetCounterByID(ctx, pool, counterID)
+	if err != nil {
+		return nil, err
+	}
+	if s.Action == TokenSet && !counter.inRange(s.Amount) {
+		return nil, fmt.Errorf("%w: value %d is outside the counter's bounds", ErrInvalidInput, s.Amount)
+	}
+
+	token, hash, err := newTriggerToken()
+	if err != nil {
+		return nil, err
+	}
+	t, err := scanTriggerToken(pool.QueryRow(ctx,
+		`INSERT INTO trigger_tokens (counter_id, name, token_hash, action, amount)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING `+triggerTokenColumns,
+		counterID, s.Name, hash, s.Action, s.Amount))
+	if err != nil {
+		return nil, err
+	}
+	t.Token = token
+	return t, nil
+}
+
+// GetTriggerTokens lists a counter's trigger tokens, revoked ones included,
+// in creation order.
+func GetTriggerTokens(ctx context.Context, pool *pgxpool.Pool, counterID int64) ([]TriggerToken, error) {
+	if _, err := GetCounterByID(ctx, pool, counterID); err != nil {
+		return nil, err
+	}
+	rows, err := pool.Query(ctx,
+		"SELECT "+triggerTokenColumns+" FROM trigger_tokens WHERE counter_id = $1 ORDER BY id",
+		counterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []TriggerToken{}
+	for rows.Next() {
+		t, err := scanTriggerToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// RotateTriggerToken replaces a token with a new one and returns it. The old
+// token stops working at once.
+func RotateTriggerToken(ctx context.Context, pool *pgxpool.Pool, counterID, id int64) (*TriggerToken, error) {
+	token, hash, err := newTriggerToken()
+	if err != nil {
+		return nil, err
+	}
+	t, err := scanTriggerToken(pool.QueryRow(ctx,
+		`UPDATE trigger_tokens SET token_hash = CASE WHEN revoked_at IS NULL THEN $3 ELSE token_hash END,
+		   rotated_at = CASE WHEN revoked_at IS NULL THEN now() ELSE rotated_at END
+		 WHERE id = $1 AND counter_id = $2 RETURNING `+triggerTokenColumns,
+		id, counterID, hash))
+	if err != nil {
+		return nil, err
+	}
+	if t.RevokedAt != nil {
+		return nil, ErrTokenRevoked
+	}
+	t.Token = token
+	return t, nil
+}
+
+// RevokeTriggerToken disables a token for good. It stays listed with its uses.
+func RevokeTriggerToken(ctx context.Context, pool *pgxpool.Pool, counterID, id int64) (*TriggerToken, error) {
+	return scanTriggerToken(pool.QueryRow(ctx,
+		`UPDATE trigger_tokens SET revoked_at = COALESCE(revoked_at, now())
+		 WHERE id = $1 AND counter_id = $2 RETURNING `+triggerTokenColumns,
+		id, counterID))
+}
+
+// TokenRequest describes the request that used a trigger token, for its log.
+type TokenRequest struct {
+	Method     string
+	RemoteAddr string
+	UserAgent  string
+}
+
+// TriggerTokenUse is one logged use of a trigger token. Value is the count
+// the use left; Error says why a use failed.
+type TriggerTokenUse struct {
+	ID         int64  `json:"id"`
+	UsedAt     string `json:"used_at"`
+	Method     string `json:"method"`
+	RemoteAddr string `json:"remote_addr"`
+	UserAgent  string `json:"user_agent"`
+	Succeeded  bool   `json:"succeeded"`
+	Value      *int64 `json:"value"`
+	Error      string `json:"error,omitempty"`
+
+	usedAt time.Time
+}
+
+// UseTriggerToken applies the action of a token to its counter like
+// IncrementCurrentCount and SetCurrentCount, with the token named as the
+// event's actor, and logs the use. Unknown and revoked tokens are ErrNotFound;
+// uses of a revoked token are logged as failed.
+func UseTriggerToken(ctx context.Context, pool *pgxpool.Pool, token string, r TokenRequest) (*Count, error) {
+	t, err := scanTriggerToken(pool.QueryRow(ctx,
+		"SELECT "+triggerTokenColumns+" FROM trigger_tokens WHERE token_hash = $1",
+		hashTriggerToken(token)))
+	if err != nil {
+		return nil, err
+	}
+	if t.RevokedAt != nil {
+		if err := logTokenUse(ctx, pool, t.ID, r, nil, ErrTokenRevoked); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+
+	actor := "token:" + t.Name
+	if t.Name == "" {
+		actor = "token:" + strconv.FormatInt(t.ID, 10)
+	}
+	m := Mutation{Delta: t.Amount, Actor: actor}
+	switch t.Action {
+	case TokenDecrement:
+		m.Delta = -t.Amount
+	case TokenSet:
+		m = Mutation{Kind: EventSet, Value: t.Amount, Actor: actor}
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	cnt, _, err := applyMutation(ctx, tx, t.CounterID, m)
+	if err != nil {
+		// The failed transaction cannot hold the log entry
+		tx.Rollback(ctx)
+		if logErr := logTokenUse(ctx, pool, t.ID, r, nil, err); logErr != nil {
+			return nil, logErr
+		}
+		return nil, err
+	}
+	if err := logTokenUse(ctx, tx, t.ID, r, cnt, nil); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return cnt, nil
+}
+
+// logTokenUse records a use of a token that left cnt or failed with useErr.
+func logTokenUse(ctx context.Context, q querier, tokenID int64, r TokenRequest, cnt *Count, useErr error) error {
+	var value *int64
+	var errText string
+	if cnt != nil {
+		value = &cnt.Value
+	}
+	if useErr != nil {
+		errText = useErr.Error()
+	}
+	if _, err := q.Exec(ctx,
+		`INSERT INTO trigger_token_uses (token_id, method, remote_addr, user_agent, succeeded, value, error)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		tokenID, r.Method, r.RemoteAddr, r.UserAgent, useErr == nil, value, errText); err != nil {
+		return err
+	}
+	_, err := q.Exec(ctx, "UPDATE trigger_tokens SET last_used_at = now() WHERE id = $1", tokenID)
+	return err
+}
+
+// TokenUseQuery pages a token's use log, newest first. Cursor continues a
+// previous page.
+type TokenUseQuery struct {
+	Limit  int
+	Cursor *Cursor
+}
+
+// GetTriggerTokenUses returns a page of a token's use log and the cursor of
+// the next page, which is nil on the last page.
+func GetTriggerTokenUses(ctx context.Context, pool *pgxpool.Pool, counterID, id int64, q TokenUseQuery) ([]TriggerTokenUse, *Cursor, error) {
+	if _, err := scanTriggerToken(pool.QueryRow(ctx,
+		"SELECT "+triggerTokenColumns+" FROM trigger_tokens WHERE id = $1 AND counter_id = $2",
+		id, counterID)); err != nil {
+		return nil, nil, err
+	}
+	limit := pageLimit(q.Limit)
+	var cursorTime *time.Time
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorTime, cursorID = &q.Cursor.Time, q.Cursor.ID
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	rows, err := pool.Query(ctx,
+		`SELECT id, used_at, method, remote_addr, user_agent, succeeded, value, error FROM trigger_token_uses
+		 WHERE token_id = $1 AND ($2::timestamptz IS NULL OR (used_at, id) < ($2, $3))
+		 ORDER BY used_at DESC, id DESC
+		 LIMIT $4`,
+		id, cursorTime, cursorID, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	uses := []TriggerTokenUse{}
+	for rows.Next() {
+		var u TriggerTokenUse
+		if err := rows.Scan(&u.ID, &u.usedAt, &u.Method, &u.RemoteAddr, &u.UserAgent, &u.Succeeded, &u.Value, &u.Error); err != nil {
+			return nil, nil, err
+		}
+		u.UsedAt = u.usedAt.UTC().Format(time.RFC3339Nano)
+		uses = append(uses, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(uses) <= limit {
+		return uses, nil, nil
+	}
+	uses = uses[:limit]
+	last := uses[limit-1]
+	return uses, &Cursor{Time: last.usedAt, ID: last.ID}, nil
+}
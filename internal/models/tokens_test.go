@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestTriggerTokens tests that tokens apply their action, that rotated and
+// revoked tokens stop working, and that every use is logged.
+func TestTriggerTokens(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounter(ctx, pool, "tokens-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	inc, err := CreateTriggerToken(ctx, pool, counter.ID, TriggerTokenSettings{Name: "button", Amount: 2})
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	if inc.Token == "" || inc.Action != TokenIncrement {
+		t.Errorf("expected an increment token with its secret, got %+v", inc)
+	}
+	set, err := CreateTriggerToken(ctx, pool, counter.ID, TriggerTokenSettings{Action: TokenSet, Amount: 10})
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	invalid := []TriggerTokenSettings{
+		{Action: "multiply", Amount: 2},
+		{Action: TokenDecrement, Amount: 0},
+		{Action: TokenSet, Amount: -1},
+	}
+	for _, s := range invalid {
+		if _, err := CreateTriggerToken(ctx, pool, counter.ID, s); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("expected ErrInvalidInput for %+v, got %v", s, err)
+		}
+	}
+
+	req := TokenRequest{Method: "GET", RemoteAddr: "192.0.2.1", UserAgent: "shortcut"}
+	for _, step := range []struct {
+		token string
+		value int64
+	}{{inc.Token, 2}, {inc.Token, 4}, {set.Token, 10}} {
+		cnt, err := UseTriggerToken(ctx, pool, step.token, req)
+		if err != nil {
+			t.Fatalf("failed to use token: %v", err)
+		}
+		if cnt.Value != step.value {
+			t.Errorf("expected value %d, got %d", step.value, cnt.Value)
+		}
+	}
+	events, _, err := GetEvents(ctx, pool, counter.ID, EventQuery{})
+	if err != nil {
+		t.Fatalf("failed to get events: %v", err)
+	}
+	if len(events) != 3 || events[2].Actor != "token:button" || events[0].Kind != EventSet {
+		t.Errorf("expected the uses in the event log, got %+v", events)
+	}
+	if _, err := UseTriggerToken(ctx, pool, "no-such-token", req); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown token, got %v", err)
+	}
+
+	rotated, err := RotateTriggerToken(ctx, pool, counter.ID, inc.ID)
+	if err != nil {
+		t.Fatalf("failed to rotate token: %v", err)
+	}
+	if rotated.Token == inc.Token || rotated.RotatedAt == nil {
+		t.Errorf("expected a new token, got %+v", rotated)
+	}
+	if _, err := UseTriggerToken(ctx, pool, inc.Token, req); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a rotated-out token, got %v", err)
+	}
+	if _, err := UseTriggerToken(ctx, pool, rotated.Token, req); err != nil {
+		t.Errorf("failed to use the rotated token: %v", err)
+	}
+
+	if _, err := RevokeTriggerToken(ctx, pool, counter.ID, inc.ID); err != nil {
+		t.Fatalf("failed to revoke token: %v", err)
+	}
+	if _, err := UseTriggerToken(ctx, pool, rotated.Token, req); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a revoked token, got %v", err)
+	}
+	if _, err := RotateTriggerToken(ctx, pool, counter.ID, inc.ID); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("expected ErrTokenRevoked when rotating a revoked token, got %v", err)
+	}
+
+	uses, _, err := GetTriggerTokenUses(ctx, pool, counter.ID, inc.ID, TokenUseQuery{})
+	if err != nil {
+		t.Fatalf("failed to get token uses: %v", err)
+	}
+	if len(uses) != 4 {
+		t.Fatalf("expected 4 uses, got %d", len(uses))
+	}
+	if uses[0].Succeeded || uses[0].Error == "" || !uses[1].Succeeded || uses[1].Value == nil || *uses[1].Value != 12 {
+		t.Errorf("expected a failed use after the successful ones, got %+v", uses[:2])
+	}
+	if uses[3].UserAgent != "shortcut" || uses[3].RemoteAddr != "192.0.2.1" || uses[3].Method != "GET" {
+		t.Errorf("expected the request to be logged, got %+v", uses[3])
+	}
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrUndoUnavailable is returned when there is no operation that may be undone or redone.
+var ErrUndoUnavailable = errors.New("operation cannot be reverted")
+
+// undoableKinds are the event kinds of regular operations. A new one of them
+// clears the redo stack.
+var undoableKinds = []string{EventIncrement, EventDecrement, EventSet, EventReset}
+
+// revertibleKinds are the event kinds Undo can revert.
+var revertibleKinds = []string{EventIncrement, EventDecrement, EventSet, EventReset, EventRedo}
+
+// Undo reverts the counter's most recent operation that is not undone yet,
+// including a redo. The change the operation actually made is subtracted from
+// the current value, subject to the counter's bounds like an increment. Only operations of the current period
+// made within window can be undone.
+func Undo(ctx context.Context, pool *pgxpool.Pool, counterID int64, window time.Duration) (*Count, error) {
+	return revert(ctx, pool, counterID, window, EventUndo)
+}
+
+// Redo reverts the most recent undo, as long as no regular operation followed
+// it. The same period and window restrictions as for Undo apply.
+func Redo(ctx context.Context, pool *pgxpool.Pool, counterID int64, window time.Duration) (*Count, error) {
+	return revert(ctx, pool, counterID, window, EventRedo)
+}
+
+// revert records an undo or redo event reverting the latest eligible event.
+func revert(ctx context.Context, pool *pgxpool.Pool, counterID int64, window time.Duration, kind string) (*Count, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	counter, err := lockCounter(ctx, tx, counterID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	current, err := countAt(ctx, tx, counter, now)
+	if err != nil {
+		return nil, err
+	}
+	// Locking the current count serializes undo and redo with other operations
+	var previous int64
+	if err := tx.QueryRow(ctx, "SELECT value FROM counts WHERE id = $1 FOR UPDATE", current.ID).Scan(&previous); err != nil {
+		return nil, err
+	}
+
+	var target *Event
+	if kind == EventUndo {
+		target, err = scanEvent(tx.QueryRow(ctx,
+			`SELECT `+eventColumns+` FROM events
+			 WHERE counter_id = $1 AND kind = ANY($2) AND undone_at IS NULL
+			 ORDER BY id DESC LIMIT 1`,
+			counterID, revertibleKinds))
+	} else {
+		target, err = scanEvent(tx.QueryRow(ctx,
+			`SELECT `+eventColumns+` FROM events
+			 WHERE counter_id = $1 AND kind = $2 AND undone_at IS NULL
+			   AND id > COALESCE((SELECT max(id) FROM events WHERE counter_id = $1 AND kind = ANY($3)), 0)
+			 ORDER BY id DESC LIMIT 1`,
+			counterID, EventUndo, undoableKinds))
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: nothing to %s", ErrUndoUnavailable, kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target.CountID != current.ID {
+		return nil, fmt.Errorf("%w: the last operation belongs to an earlier period", ErrUndoUnavailable)
+	}
+	if now.Sub(target.createdAt) > window {
+		return nil, fmt.Errorf("%w: the last operation is older than %s", ErrUndoUnavailable, window)
+	}
+
+	delta := target.PreviousValue - target.Value
+	value, err := counter.bound(previous + delta)
+	if err != nil {
+		return nil, err
+	}
+	cnt, err := scanCount(tx.QueryRow(ctx,
+		"UPDATE counts SET value = $1 WHERE id = $2 RETURNING "+countColumns,
+		value, current.ID))
+	if err != nil {
+		return nil, err
+	}
+	if err := addToWindows(ctx, tx, counter, now, cnt.Value-previous); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE events SET undone_at = now() WHERE id = $1", target.ID); err != nil {
+		return nil, err
+	}
+	e, err := scanEvent(tx.QueryRow(ctx,
+		`INSERT INTO events (counter_id, count_id, kind, delta, previous_value, value, occurred_at, reverts_event_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING `+eventColumns,
+		counterID, cnt.ID, kind, delta, previous, cnt.Value, now, target.ID))
+	if err != nil {
+		return nil, err
+	}
+	cnt.setGoal(counter.Goal)
+	if err := reportChange(ctx, tx, e, cnt, previous); err != nil {
+		return nil, err
+	}
+	if err := evaluateAlertRules(ctx, tx, counter, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return cnt, nil
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TestUndoRedo tests multi-level undo and redo, the zero clamp and that a new
+// operation clears the redo stack.
+func TestUndoRedo(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	window := time.Minute
+
+	counter, err := CreateCounter(ctx, pool, "undo-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if _, err := Undo(ctx, pool, counter.ID, window); !errors.Is(err, ErrUndoUnavailable) {
+		t.Errorf("expected ErrUndoUnavailable without operations, got %v", err)
+	}
+
+	IncrementCurrentCount(ctx, pool, counter.ID, 3)
+	IncrementCurrentCount(ctx, pool, counter.ID, -5) // clamped: only -3 applied
+
+	steps := []struct {
+		name string
+		op   func(context.Context, *pgxpool.Pool, int64, time.Duration) (*Count, error)
+		want int64
+	}{
+		{"undo clamped decrement", Undo, 3},
+		{"undo increment", Undo, 0},
+		{"redo increment", Redo, 3},
+		{"redo decrement", Redo, 0},
+		{"undo redo", Undo, 3},
+	}
+	for _, step := range steps {
+		cnt, err := step.op(ctx, pool, counter.ID, window)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if cnt.Value != step.want {
+			t.Errorf("%s: expected value %d, got %d", step.name, step.want, cnt.Value)
+		}
+	}
+
+	// A new operation clears the redo stack
+	IncrementCurrentCount(ctx, pool, counter.ID, 1)
+	if _, err := Redo(ctx, pool, counter.ID, window); !errors.Is(err, ErrUndoUnavailable) {
+		t.Errorf("expected ErrUndoUnavailable after a new operation, got %v", err)
+	}
+
+	events, _, _ := GetEvents(ctx, pool, counter.ID, EventQuery{})
+	undo := events[1]
+	if undo.Kind != EventUndo || undo.RevertsEventID == nil || *undo.RevertsEventID != events[2].ID || events[2].UndoneAt == nil {
+		t.Errorf("expected the last undo to revert the redo before it, got %+v and %+v", undo, events[2])
+	}
+
+	if _, err := Undo(ctx, pool, counter.ID, 0); !errors.Is(err, ErrUndoUnavailable) {
+		t.Errorf("expected ErrUndoUnavailable outside the window, got %v", err)
+	}
+}
+
+// TestUndoAcrossPeriods tests that an operation of an earlier period cannot be undone.
+func TestUndoAcrossPeriods(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounter(ctx, pool, "undo-period-test", "1h", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if _, err := ApplyMutation(ctx, pool, counter.ID, Mutation{Delta: 2, At: time.Now().Add(-2 * time.Hour)}); err != nil {
+		t.Fatalf("failed to apply backdated mutation: %v", err)
+	}
+	if _, err := Undo(ctx, pool, counter.ID, time.Hour); !errors.Is(err, ErrUndoUnavailable) {
+		t.Errorf("expected ErrUndoUnavailable for an earlier period, got %v", err)
+	}
+}
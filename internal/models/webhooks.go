@@ -0,0 +1,603 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Webhook event types.
+const (
+	// WebhookCountChanged reports every change of a count, undo and redo included.
+	WebhookCountChanged = "count.changed"
+	// WebhookGoalReached reports a change that took a count from below the
+	// counter's goal to at least the goal.
+	WebhookGoalReached = "goal.reached"
+	// WebhookPeriodRolledOver reports the start of a counter's new period.
+	WebhookPeriodRolledOver = "period.rolled_over"
+)
+
+// WebhookEventTypes lists the event types a webhook can subscribe to.
+var WebhookEventTypes = []string{WebhookCountChanged, WebhookGoalReached, WebhookPeriodRolledOver}
+
+// Delivery statuses. A pending delivery is retried until it is delivered or
+// runs out of attempts and fails.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook posts the events of one counter, or of all counters when CounterID
+// is nil, to URL. Events filters the event types; an empty list subscribes to
+// all of them. Payloads are signed with Secret, which is only returned when
+// the webhook is created or the secret is changed.
+type Webhook struct {
+	ID        int64    `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	CounterID *int64   `json:"counter_id"`
+	Secret    string   `json:"secret,omitempty"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// webhookColumns lists the webhooks columns read by scanWebhook, in order.
+const webhookColumns = "id, url, events, counter_id, secret, active, created_at::TEXT"
+
+// scanWebhook scans a single webhooks row selected with webhookColumns.
+func scanWebhook(row pgx.Row) (*Webhook, error) {
+	var w Webhook
+	if err := row.Scan(&w.ID, &w.URL, &w.Events, &w.CounterID, &w.Secret, &w.Active, &w.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+// WebhookSettings holds the settings of a new webhook. An empty Secret is
+// replaced by a random one.
+type WebhookSettings struct {
+	URL       string
+	Events    []string
+	CounterID *int64
+	Secret    string
+}
+
+// WebhookUpdate holds the webhook settings to change; nil fields are left as
+// they are. A Secret of "" is replaced by a random one.
+type WebhookUpdate struct {
+	URL    *string
+	Events []string
+	Active *bool
+	Secret *string
+}
+
+// validateWebhookURL checks that u is an absolute http or https URL.
+func validateWebhookURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidInput)
+	}
+	return nil
+}
+
+// validateWebhookEvents checks that events only holds known event types.
+func validateWebhookEvents(events []string) error {
+	for _, e := range events {
+		if !slices.Contains(WebhookEventTypes, e) {
+			return fmt.Errorf("%w: unknown webhook event: %s", ErrInvalidInput, e)
+		}
+	}
+	return nil
+}
+
+// newWebhookSecret returns a random signing secret.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateWebhook registers a webhook and returns it with its secret.
+func CreateWebhook(ctx context.Context, pool *pgxpool.Pool, s WebhookSettings) (*Webhook, error) {
+	if err := validateWebhookURL(s.URL); err != nil {
+		return nil, err
+	}
+	if err := validateWebhookEvents(s.Events); err != nil {
+		return nil, err
+	}
+	if s.Events == nil {
+		s.Events = []string{}
+	}
+	if s.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		s.Secret = secret
+	}
+	w, err := scanWebhook(pool.QueryRow(ctx,
+		"INSERT INTO webhooks (url, events, counter_id, secret) VALUES ($1, $2, $3, $4) RETURNING "+webhookColumns,
+		s.URL, s.Events, s.CounterID, s.Secret))
+	if err != nil {
+		return nil, translateWebhookWriteErr(err)
+	}
+	return w, nil
+}
+
+// translateWebhookWriteErr maps constraint violations on webhooks to model errors.
+func translateWebhookWriteErr(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return fmt.Errorf("%w: counter does not exist", ErrInvalidInput)
+	}
+	return err
+}
+
+// GetWebhooks lists all webhooks in creation order, without their secrets.
+func GetWebhooks(ctx context.Context, pool *pgxpool.Pool) ([]Webhook, error) {
+	rows, err := pool.Query(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		w.Secret = ""
+		webhooks = append(webhooks, *w)
+	}
+	return webhooks, rows.Err()
+}
+
+// GetWebhookByID returns a webhook without its secret.
+func GetWebhookByID(ctx context.Context, pool *pgxpool.Pool, id int64) (*Webhook, error) {
+	w, err := scanWebhook(pool.QueryRow(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1", id))
+	if err != nil {
+		return nil, err
+	}
+	w.Secret = ""
+	return w, nil
+}
+
+// UpdateWebhook changes the settings of a webhook. The secret is returned
+// only when it was changed. Deliveries already queued are sent with the new
+// URL and secret; deactivating a webhook holds its pending deliveries back
+// until it is activated again.
+func UpdateWebhook(ctx context.Context, pool *pgxpool.Pool, id int64, u WebhookUpdate) (*Webhook, error) {
+	if u.URL != nil {
+		if err := validateWebhookURL(*u.URL); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateWebhookEvents(u.Events); err != nil {
+		return nil, err
+	}
+	if u.Secret != nil && *u.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		u.Secret = &secret
+	}
+	w, err := scanWebhook(pool.QueryRow(ctx,
+		`UPDATE webhooks SET url = COALESCE($2, url), events = COALESCE($3, events),
+		   active = COALESCE($4, active), secret = COALESCE($5, secret)
+		 WHERE id = $1 RETURNING `+webhookColumns,
+		id, u.URL, u.Events, u.Active, u.Secret))
+	if err != nil {
+		return nil, err
+	}
+	if u.Secret == nil {
+		w.Secret = ""
+	}
+	return w, nil
+}
+
+// DeleteWebhook removes a webhook together with its deliveries.
+func DeleteWebhook(ctx context.Context, pool *pgxpool.Pool, id int64) error {
+	tag, err := pool.Exec(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// WebhookEvent is the payload posted to webhooks. Event is the event log entry
+// of a count change and Count the count it left. For a rollover, Previous is
+// the count of the period that ended and Count the one of the period that
+// began; either is Filled when its period has no record.
+type WebhookEvent struct {
+	Type       string `json:"type"`
+	CounterID  int64  `json:"counter_id"`
+	Event      *Event `json:"event,omitempty"`
+	Count      *Count `json:"count,omitempty"`
+	Previous   *Count `json:"previous,omitempty"`
+	OccurredAt string `json:"occurred_at"`
+}
+
+// enqueueWebhooks queues e for every active webhook subscribed to its type and
+// counter, in the transaction of the change it reports. With a dedupeKey, e is
+// queued at most once per webhook; with a non-zero since, only for webhooks
+// created by then.
+func enqueueWebhooks(ctx context.Context, q querier, e WebhookEvent, dedupeKey *string, since time.Time) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, event_type, payload, dedupe_key)
+		 SELECT id, $2, $3, $4 FROM webhooks
+		 WHERE active AND (counter_id IS NULL OR counter_id = $1)
+		   AND (cardinality(events) = 0 OR $2 = ANY(events))
+		   AND ($5::timestamptz IS NULL OR created_at <= $5)
+		 ON CONFLICT (webhook_id, dedupe_key) DO NOTHING`,
+		e.CounterID, e.Type, payload, dedupeKey, optionalTime(since))
+	return err
+}
+
+// reportChange queues the webhook events of a count change from previous to
+// cnt recorded as e. cnt must have the counter's goal set.
+func reportChange(ctx context.Context, tx pgx.Tx, e *Event, cnt *Count, previous int64) error {
+	we := WebhookEvent{Type: WebhookCountChanged, CounterID: e.CounterID, Event: e, Count: cnt, OccurredAt: e.OccurredAt}
+	if err := enqueueWebhooks(ctx, tx, we, nil, time.Time{}); err != nil {
+		return err
+	}
+	if cnt.Goal == nil || previous >= *cnt.Goal || cnt.Value < *cnt.Goal {
+		return nil
+	}
+	we.Type = WebhookGoalReached
+	return enqueueWebhooks(ctx, tx, we, nil, time.Time{})
+}
+
+// EnqueueRollovers queues a period.rolled_over event for the running period
+// of every active counter a webhook subscribes to it for, and returns when the
+// next of their periods ends, or a zero time if none does. It is meant to be
+// called again then: each period is queued once per webhook, and only for
+// webhooks that existed when the period began.
+func EnqueueRollovers(ctx context.Context, pool *pgxpool.Pool) (time.Time, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT c.id, c.created_at FROM counters c
+		 WHERE c.archived_at IS NULL AND EXISTS (
+		   SELECT 1 FROM webhooks w
+		   WHERE w.active AND (w.counter_id IS NULL OR w.counter_id = c.id)
+		     AND (cardinality(w.events) = 0 OR $1 = ANY(w.events)))`,
+		WebhookPeriodRolledOver)
+	if err != nil {
+		return time.Time{}, err
+	}
+	created := map[int64]time.Time{}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var createdAt time.Time
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			rows.Close()
+			return time.Time{}, err
+		}
+		ids = append(ids, id)
+		created[id] = createdAt
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now().UTC()
+	var next time.Time
+	for _, id := range ids {
+		counter, err := getCounter(ctx, pool, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if err := counter.loadHolidays(ctx, pool); err != nil {
+			return time.Time{}, err
+		}
+		expiry, err := counter.nextExpiry(now)
+		if err != nil {
+			// A schedule without further periods never rolls over
+			continue
+		}
+		if next.IsZero() || expiry.Before(next) {
+			next = expiry
+		}
+		start, err := counter.periodStart(now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if start.Before(created[id]) {
+			// No period began since the counter was created
+			continue
+		}
+		previous, err := peekCount(ctx, pool, counter, start.Add(-time.Nanosecond))
+		if err != nil {
+			return time.Time{}, err
+		}
+		current, err := peekCount(ctx, pool, counter, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		previous.setGoal(counter.Goal)
+		current.setGoal(counter.Goal)
+
+		key := fmt.Sprintf("%s:%d:%d", WebhookPeriodRolledOver, counter.ID, start.Unix())
+		e := WebhookEvent{
+			Type:       WebhookPeriodRolledOver,
+			CounterID:  counter.ID,
+			Count:      current,
+			Previous:   previous,
+			OccurredAt: start.UTC().Format(time.RFC3339Nano),
+		}
+		if err := enqueueWebhooks(ctx, pool, e, &key, start); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return next, nil
+}
+
+// WebhookDelivery is one event queued for a webhook. NextAttemptAt is when a
+// pending delivery is tried next. AttemptLog lists its attempts, oldest first.
+type WebhookDelivery struct {
+	ID            int64            `json:"id"`
+	WebhookID     int64            `json:"webhook_id"`
+	EventType     string           `json:"event_type"`
+	Payload       json.RawMessage  `json:"payload"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	NextAttemptAt *string          `json:"next_attempt_at,omitempty"`
+	CreatedAt     string           `json:"created_at"`
+	DeliveredAt   *string          `json:"delivered_at,omitempty"`
+	AttemptLog    []WebhookAttempt `json:"attempt_log,omitempty"`
+
+	createdAt time.Time
+}
+
+// deliveryColumns lists the webhook_deliveries columns, of the table aliased
+// d, read by scanDelivery, in order.
+const deliveryColumns = "d.id, d.webhook_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at, d.created_at, d.delivered_at"
+
+// scanDelivery scans a webhook_deliveries row selected with deliveryColumns,
+// followed by dest.
+func scanDelivery(row pgx.Row, dest ...any) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var nextAttemptAt time.Time
+	var deliveredAt *time.Time
+	if err := row.Scan(append([]any{&d.ID, &d.WebhookID, &d.EventType, &d.Payload, &d.Status,
+		&d.Attempts, &nextAttemptAt, &d.createdAt, &deliveredAt}, dest...)...); err != nil {
+		return nil, err
+	}
+	d.CreatedAt = d.createdAt.UTC().Format(time.RFC3339Nano)
+	if d.Status == DeliveryPending {
+		s := nextAttemptAt.UTC().Format(time.RFC3339Nano)
+		d.NextAttemptAt = &s
+	}
+	if deliveredAt != nil {
+		s := deliveredAt.UTC().Format(time.RFC3339Nano)
+		d.DeliveredAt = &s
+	}
+	return &d, nil
+}
+
+// WebhookAttempt is one attempt to send a delivery. StatusCode is nil when no
+// response was received, and Error describes why the attempt failed.
+type WebhookAttempt struct {
+	AttemptedAt string `json:"attempted_at"`
+	StatusCode  *int   `json:"status_code"`
+	Error       string `json:"error,omitempty"`
+	DurationMS  int64  `json:"duration_ms"`
+}
+
+// DeliveryQuery filters and pages a webhook's deliveries, newest first.
+// An empty Status selects all of them. Cursor continues a previous page.
+type DeliveryQuery struct {
+	Status string
+	Limit  int
+	Cursor *Cursor
+}
+
+// GetWebhookDeliveries returns a page of a webhook's deliveries with their
+// attempts and the cursor of the next page, which is nil on the last page.
+func GetWebhookDeliveries(ctx context.Context, pool *pgxpool.Pool, webhookID int64, q DeliveryQuery) ([]WebhookDelivery, *Cursor, error) {
+	switch q.Status {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryFailed:
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown delivery status: %s", ErrInvalidInput, q.Status)
+	}
+	if _, err := GetWebhookByID(ctx, pool, webhookID); err != nil {
+		return nil, nil, err
+	}
+	limit := pageLimit(q.Limit)
+	var cursorTime *time.Time
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorTime, cursorID = &q.Cursor.Time, q.Cursor.ID
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	rows, err := pool.Query(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries d
+		 WHERE d.webhook_id = $1 AND ($2 = '' OR d.status = $2)
+		   AND ($3::timestamptz IS NULL OR (d.created_at, d.id) < ($3, $4))
+		 ORDER BY d.created_at DESC, d.id DESC
+		 LIMIT $5`,
+		webhookID, q.Status, cursorTime, cursorID, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	rows.Close()
+
+	var next *Cursor
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		last := deliveries[limit-1]
+		next = &Cursor{Time: last.createdAt, ID: last.ID}
+	}
+	if err := loadAttempts(ctx, pool, deliveries); err != nil {
+		return nil, nil, err
+	}
+	return deliveries, next, nil
+}
+
+// loadAttempts fills in the attempt logs of deliveries.
+func loadAttempts(ctx context.Context, pool *pgxpool.Pool, deliveries []WebhookDelivery) error {
+	ids := make([]int64, len(deliveries))
+	byID := make(map[int64]*WebhookDelivery, len(deliveries))
+	for i := range deliveries {
+		ids[i] = deliveries[i].ID
+		byID[ids[i]] = &deliveries[i]
+	}
+	rows, err := pool.Query(ctx,
+		`SELECT delivery_id, attempted_at, status_code, error, duration_ms FROM webhook_attempts
+		 WHERE delivery_id = ANY($1) ORDER BY id`,
+		ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var a WebhookAttempt
+		var attemptedAt time.Time
+		if err := rows.Scan(&id, &attemptedAt, &a.StatusCode, &a.Error, &a.DurationMS); err != nil {
+			return err
+		}
+		a.AttemptedAt = attemptedAt.UTC().Format(time.RFC3339Nano)
+		d := byID[id]
+		d.AttemptLog = append(d.AttemptLog, a)
+	}
+	return rows.Err()
+}
+
+// ClaimedDelivery is a delivery taken for sending together with the URL and
+// secret of its webhook.
+type ClaimedDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// ClaimWebhookDeliveries takes up to limit due deliveries of active webhooks,
+// oldest first, and moves their next attempt lease into the future, so other
+// dispatchers skip them while they are being sent. A delivery whose sender
+// dies without recording an attempt is taken again once the lease is over.
+func ClaimWebhookDeliveries(ctx context.Context, pool *pgxpool.Pool, limit int, lease time.Duration) ([]ClaimedDelivery, error) {
+	rows, err := pool.Query(ctx,
+		`WITH due AS (
+		   SELECT d.id FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		   WHERE d.status = 'pending' AND d.next_attempt_at <= now() AND w.active
+		   ORDER BY d.next_attempt_at, d.id
+		   LIMIT $1
+		   FOR UPDATE OF d SKIP LOCKED
+		 )
+		 UPDATE webhook_deliveries d SET next_attempt_at = now() + $2 * interval '1 microsecond'
+		 FROM due, webhooks w
+		 WHERE d.id = due.id AND w.id = d.webhook_id
+		 RETURNING `+deliveryColumns+`, w.url, w.secret`,
+		limit, lease.Microseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []ClaimedDelivery
+	for rows.Next() {
+		var c ClaimedDelivery
+		d, err := scanDelivery(rows, &c.URL, &c.Secret)
+		if err != nil {
+			return nil, err
+		}
+		c.WebhookDelivery = *d
+		claimed = append(claimed, c)
+	}
+	return claimed, rows.Err()
+}
+
+// AttemptResult is the outcome of sending a delivery. StatusCode is 0 when no
+// response was received. A failed attempt is retried at RetryAt, or fails the
+// delivery for good when RetryAt is zero.
+type AttemptResult struct {
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+	Delivered  bool
+	RetryAt    time.Time
+}
+
+// RecordWebhookAttempt logs an attempt to send a delivery and updates the
+// delivery's status accordingly.
+func RecordWebhookAttempt(ctx context.Context, pool *pgxpool.Pool, deliveryID int64, r AttemptResult) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var statusCode *int
+	if r.StatusCode != 0 {
+		statusCode = &r.StatusCode
+	}
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms) VALUES ($1, $2, $3, $4)",
+		deliveryID, statusCode, r.Error, r.Duration.Milliseconds()); err != nil {
+		return err
+	}
+	status := DeliveryPending
+	switch {
+	case r.Delivered:
+		status = DeliveryDelivered
+	case r.RetryAt.IsZero():
+		status = DeliveryFailed
+	}
+	tag, err := tx.Exec(ctx,
+		`UPDATE webhook_deliveries SET attempts = attempts + 1, status = $2,
+		   next_attempt_at = COALESCE($3, next_attempt_at),
+		   delivered_at = CASE WHEN $2 = 'delivered' THEN now() END
+		 WHERE id = $1`,
+		deliveryID, status, optionalTime(r.RetryAt))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestWebhookOutbox tests that changes are queued for the webhooks subscribed
+// to them, that rollovers are queued once, and that claimed deliveries record
+// their attempts.
+func TestWebhookOutbox(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounterWithSettings(ctx, pool, "webhooks-test", CounterSettings{
+		Goal: NullableInt{Set: true, Value: int64Ptr(5)},
+	})
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	other, err := CreateCounter(ctx, pool, "webhooks-other", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+
+	all, err := CreateWebhook(ctx, pool, WebhookSettings{URL: "http://example.com/all"})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	if all.Secret == "" || len(all.Events) != 0 || !all.Active {
+		t.Errorf("expected an active webhook for all events with a secret, got %+v", all)
+	}
+	goal, err := CreateWebhook(ctx, pool, WebhookSettings{
+		URL:       "https://example.com/goal",
+		Events:    []string{WebhookGoalReached},
+		CounterID: &counter.ID,
+		Secret:    "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	invalid := []WebhookSettings{
+		{URL: "ftp://example.com"},
+		{URL: "/relative"},
+		{URL: "http://example.com", Events: []string{"count.exploded"}},
+		{URL: "http://example.com", CounterID: int64Ptr(999999)},
+	}
+	for _, s := range invalid {
+		if _, err := CreateWebhook(ctx, pool, s); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("expected ErrInvalidInput for %+v, got %v", s, err)
+		}
+	}
+
+	for _, delta := range []int64{3, 3, 1} {
+		if _, err := IncrementCurrentCount(ctx, pool, counter.ID, delta); err != nil {
+			t.Fatalf("failed to increment: %v", err)
+		}
+	}
+	if _, err := Undo(ctx, pool, counter.ID, time.Minute); err != nil {
+		t.Fatalf("failed to undo: %v", err)
+	}
+	if _, err := IncrementCurrentCount(ctx, pool, other.ID, 1); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+
+	deliveries := func(webhookID int64, status string) []WebhookDelivery {
+		list, _, err := GetWebhookDeliveries(ctx, pool, webhookID, DeliveryQuery{Status: status})
+		if err != nil {
+			t.Fatalf("failed to list deliveries: %v", err)
+		}
+		return list
+	}
+	// Five changes and one goal crossing
+	if got := deliveries(all.ID, ""); len(got) != 6 {
+		t.Errorf("expected 6 deliveries for all events, got %d", len(got))
+	}
+	got := deliveries(goal.ID, "")
+	if len(got) != 1 {
+		t.Fatalf("expected 1 goal delivery, got %d", len(got))
+	}
+	var payload WebhookEvent
+	if err := json.Unmarshal(got[0].Payload, &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if payload.Type != WebhookGoalReached || payload.Count == nil || payload.Count.Value != 6 || payload.Event == nil || payload.Event.PreviousValue != 3 {
+		t.Errorf("unexpected goal payload: %s", got[0].Payload)
+	}
+
+	// Rollovers are only reported for periods that began after the counter
+	// and the webhook were created
+	if _, err := pool.Exec(ctx, "UPDATE counters SET created_at = now() - interval '3 days' WHERE id = $1", counter.ID); err != nil {
+		t.Fatalf("failed to backdate counter: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "UPDATE webhooks SET created_at = now() - interval '3 days' WHERE id = $1", all.ID); err != nil {
+		t.Fatalf("failed to backdate webhook: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		next, err := EnqueueRollovers(ctx, pool)
+		if err != nil {
+			t.Fatalf("failed to enqueue rollovers: %v", err)
+		}
+		if y, m, d := time.Now().UTC().AddDate(0, 0, 1).Date(); !next.Equal(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected the next rollover at midnight, got %v", next)
+		}
+	}
+	var rollovers int
+	for _, d := range deliveries(all.ID, "") {
+		if d.EventType == WebhookPeriodRolledOver {
+			rollovers++
+		}
+	}
+	if rollovers != 1 {
+		t.Errorf("expected 1 rollover delivery, got %d", rollovers)
+	}
+
+	claimed, err := ClaimWebhookDeliveries(ctx, pool, 100, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to claim deliveries: %v", err)
+	}
+	if len(claimed) != 8 {
+		t.Fatalf("expected 8 claimed deliveries, got %d", len(claimed))
+	}
+	if again, err := ClaimWebhookDeliveries(ctx, pool, 100, time.Minute); err != nil || len(again) != 0 {
+		t.Errorf("expected leased deliveries not to be claimed again, got %d, %v", len(again), err)
+	}
+	for _, c := range claimed {
+		if c.WebhookID == goal.ID && (c.URL != goal.URL || c.Secret != "s3cret") {
+			t.Errorf("expected the goal webhook's URL and secret, got %s, %s", c.URL, c.Secret)
+		}
+	}
+
+	results := []AttemptResult{
+		{StatusCode: 200, Delivered: true},
+		{StatusCode: 500, Error: "unexpected status: 500", RetryAt: time.Now().Add(time.Hour)},
+		{Error: "connection refused"},
+	}
+	ids := map[int64]bool{}
+	for i, r := range results {
+		ids[claimed[i].ID] = true
+		if err := RecordWebhookAttempt(ctx, pool, claimed[i].ID, r); err != nil {
+			t.Fatalf("failed to record attempt: %v", err)
+		}
+	}
+	byStatus := map[string]int{}
+	for _, status := range []string{DeliveryPending, DeliveryDelivered, DeliveryFailed} {
+		for _, d := range append(deliveries(all.ID, status), deliveries(goal.ID, status)...) {
+			if ids[d.ID] {
+				byStatus[status]++
+				if len(d.AttemptLog) != 1 || d.Attempts != 1 {
+					t.Errorf("expected one attempt of delivery %d, got %+v", d.ID, d)
+				}
+			}
+		}
+	}
+	if byStatus[DeliveryPending] != 1 || byStatus[DeliveryDelivered] != 1 || byStatus[DeliveryFailed] != 1 {
+		t.Errorf("expected one pending, delivered and failed delivery, got %v", byStatus)
+	}
+
+	// Inactive webhooks get nothing queued
+	active := false
+	if _, err := UpdateWebhook(ctx, pool, all.ID, WebhookUpdate{Active: &active}); err != nil {
+		t.Fatalf("failed to deactivate webhook: %v", err)
+	}
+	before := len(deliveries(all.ID, ""))
+	if _, err := IncrementCurrentCount(ctx, pool, counter.ID, 1); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	if after := len(deliveries(all.ID, "")); after != before {
+		t.Errorf("expected no delivery for an inactive webhook, got %d more", after-before)
+	}
+
+	if err := DeleteWebhook(ctx, pool, goal.ID); err != nil {
+		t.Fatalf("failed to delete webhook: %v", err)
+	}
+	if _, err := GetWebhookByID(ctx, pool, goal.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a deleted webhook, got %v", err)
+	}
+}
@@ -0,0 +1,387 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/iben12/counter-app/internal/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrDuplicateWindowName is returned when a counter already has a window of that name.
+var ErrDuplicateWindowName = errors.New("window name already exists")
+
+// Window is an additional rollup of a counter on a frequency of its own, such
+// as the weekly and monthly totals of a daily counter. It shares the counter's
+// timezone, week start, anchor and calendar. Every change made to the counter's
+// count is also added to the window's period containing it, so a window period's
+// value is the net change made to the counter during that period.
+type Window struct {
+	ID        int64  `json:"id"`
+	CounterID int64  `json:"counter_id"`
+	Name      string `json:"name"`
+	Frequency string `json:"frequency"`
+	CreatedAt string `json:"created_at"`
+}
+
+// windowColumns lists the counter_windows columns read by scanWindow, in order.
+const windowColumns = "id, counter_id, name, frequency, created_at::TEXT"
+
+// scanWindow scans a single counter_windows row selected with windowColumns.
+func scanWindow(row pgx.Row) (*Window, error) {
+	var w Window
+	if err := row.Scan(&w.ID, &w.CounterID, &w.Name, &w.Frequency, &w.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+// windowCountColumns lists the window_counts columns read by scanWindowCount, in order.
+const windowCountColumns = "id, window_id, value, period_start, expiry, created_at"
+
+// scanWindowCount scans a window_counts row selected with windowCountColumns
+// into a Count of w. The columns line up with countColumns, with the window ID
+// in place of the counter ID.
+func scanWindowCount(row pgx.Row, w *Window) (*Count, error) {
+	c, err := scanCount(row)
+	if err != nil {
+		return nil, err
+	}
+	c.CounterID, c.Window = w.CounterID, w.Name
+	return c, nil
+}
+
+// scheduler returns the frequency scheduler of the window, aligned like counter.
+// The counter's holidays must be loaded.
+func (w *Window) scheduler(counter *Counter) (db.Scheduler, error) {
+	return db.NewScheduler(db.ScheduleFrequency, w.Frequency, counter.Timezone, counter.periodOptions())
+}
+
+// CreateWindow adds a window to a counter. The window's history is filled in
+// from the counter's event log, so it covers the changes made before it existed.
+func CreateWindow(ctx context.Context, pool *pgxpool.Pool, counterID int64, name, frequency string) (*Window, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: name must not be empty", ErrInvalidInput)
+	}
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the counter keeps mutations out until the backfill is done
+	counter, err := lockCounter(ctx, tx, counterID)
+	if err != nil {
+		return nil, err
+	}
+	if err := counter.loadHolidays(ctx, tx); err != nil {
+		return nil, err
+	}
+	w := &Window{CounterID: counterID, Name: name, Frequency: frequency}
+	s, err := w.scheduler(counter)
+	if err == nil {
+		_, err = s.Next(time.Now())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	w, err = scanWindow(tx.QueryRow(ctx,
+		"INSERT INTO counter_windows (counter_id, name, frequency) VALUES ($1, $2, $3) RETURNING "+windowColumns,
+		counterID, name, frequency))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateWindowName
+		}
+		return nil, err
+	}
+	if err := backfillWindow(ctx, tx, w, s); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// backfillWindow adds up the changes recorded in the counter's event log into
+// the periods of a new window.
+func backfillWindow(ctx context.Context, tx pgx.Tx, w *Window, s db.Scheduler) error {
+	rows, err := tx.Query(ctx,
+		`SELECT occurred_at, value - previous_value FROM events
+		 WHERE counter_id = $1 AND value <> previous_value
+		 ORDER BY occurred_at`,
+		w.CounterID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var periods []period
+	var values []int64
+	for rows.Next() {
+		var at time.Time
+		var delta int64
+		if err := rows.Scan(&at, &delta); err != nil {
+			return err
+		}
+		if n := len(periods); n > 0 && at.Before(periods[n-1].end) {
+			values[n-1] += delta
+			continue
+		}
+		end, err := s.Next(at)
+		if err != nil {
+			return err
+		}
+		start, err := periodStartIn(s, at)
+		if err != nil {
+			return err
+		}
+		periods = append(periods, period{start: start, end: end})
+		values = append(values, delta)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for i, p := range periods {
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO window_counts (window_id, value, period_start, expiry) VALUES ($1, $2, $3, $4)",
+			w.ID, values[i], p.start, p.end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetWindows lists a counter's windows ordered by ID.
+func GetWindows(ctx context.Context, pool *pgxpool.Pool, counterID int64) ([]Window, error) {
+	if _, err := getCounter(ctx, pool, counterID); err != nil {
+		return nil, err
+	}
+	return counterWindows(ctx, pool, counterID)
+}
+
+// counterWindows lists a counter's windows without checking that it exists.
+func counterWindows(ctx context.Context, q querier, counterID int64) ([]Window, error) {
+	rows, err := q.Query(ctx,
+		"SELECT "+windowColumns+" FROM counter_windows WHERE counter_id = $1 ORDER BY id", counterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Window{}
+	for rows.Next() {
+		w, err := scanWindow(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *w)
+	}
+	return out, rows.Err()
+}
+
+func getWindow(ctx context.Context, q querier, counterID int64, name string) (*Window, error) {
+	return scanWindow(q.QueryRow(ctx,
+		"SELECT "+windowColumns+" FROM counter_windows WHERE counter_id = $1 AND name = $2", counterID, name))
+}
+
+// DeleteWindow removes a window together with its history.
+func DeleteWindow(ctx context.Context, pool *pgxpool.Pool, counterID int64, name string) error {
+	tag, err := pool.Exec(ctx, "DELETE FROM counter_windows WHERE counter_id = $1 AND name = $2", counterID, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetCurrentWindowCount returns the count of the window's running period,
+// creating it with a value of zero if nothing changed during the period yet.
+func GetCurrentWindowCount(ctx context.Context, pool *pgxpool.Pool, counterID int64, name string) (*Count, error) {
+	counter, err := getCounter(ctx, pool, counterID)
+	if err != nil {
+		return nil, err
+	}
+	w, err := getWindow(ctx, pool, counterID, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := counter.loadHolidays(ctx, pool); err != nil {
+		return nil, err
+	}
+	return windowCountAt(ctx, pool, counter, w, time.Now().UTC())
+}
+
+// windowCountAt is countAt for a window: it returns the window's count of the
+// period containing at, creating it if it is missing. The counter's holidays
+// must be loaded.
+func windowCountAt(ctx context.Context, q querier, counter *Counter, w *Window, at time.Time) (*Count, error) {
+	s, err := w.scheduler(counter)
+	if err != nil {
+		return nil, err
+	}
+	expiry, err := s.Next(at)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := scanWindowCount(q.QueryRow(ctx,
+		"SELECT "+windowCountColumns+" FROM window_counts WHERE window_id = $1 AND expiry > $2 ORDER BY expiry LIMIT 1",
+		w.ID, at), w)
+	if err == nil && !c.expiry.After(expiry) {
+		return c, nil
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	start, err := periodStartIn(s, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanWindowCount(q.QueryRow(ctx,
+		`INSERT INTO window_counts (window_id, period_start, expiry) VALUES ($1, $2, $3)
+		 ON CONFLICT (window_id, expiry) DO UPDATE SET value = window_counts.value
+		 RETURNING `+windowCountColumns,
+		w.ID, start, expiry), w)
+}
+
+// addToWindows adds delta to the period containing at of every window of the
+// counter. It runs in the transaction of the change, with the counter locked.
+func addToWindows(ctx context.Context, tx pgx.Tx, counter *Counter, at time.Time, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	windows, err := counterWindows(ctx, tx, counter.ID)
+	if err != nil {
+		return err
+	}
+	for i := range windows {
+		c, err := windowCountAt(ctx, tx, counter, &windows[i], at)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE window_counts SET value = value + $1 WHERE id = $2", delta, c.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// realignWindows moves the boundaries of the running period of the counter's
+// windows to its current settings, like realignCurrentCount.
+func realignWindows(ctx context.Context, q querier, c *Counter, now time.Time) error {
+	windows, err := counterWindows(ctx, q, c.ID)
+	if err != nil {
+		return err
+	}
+	for i := range windows {
+		s, err := windows[i].scheduler(c)
+		if err != nil {
+			return err
+		}
+		expiry, err := s.Next(now)
+		if err != nil {
+			return err
+		}
+		start, err := periodStartIn(s, now)
+		if err != nil {
+			return err
+		}
+		if _, err := q.Exec(ctx,
+			`UPDATE window_counts SET expiry = $1,
+			 period_start = GREATEST($2, (SELECT max(expiry) FROM window_counts WHERE window_id = $3 AND expiry <= $4))
+			 WHERE window_id = $3 AND expiry > $4`,
+			expiry, start, windows[i].ID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetWindowCounts returns a page of a window's history and the cursor of the
+// next page, like GetCounts. Filled periods have a value of zero.
+func GetWindowCounts(ctx context.Context, pool *pgxpool.Pool, counterID int64, name string, q CountQuery) ([]Count, *Cursor, error) {
+	dir, op, err := keysetOrder(q.Order, OrderDesc)
+	if err != nil {
+		return nil, nil, err
+	}
+	counter, err := getCounter(ctx, pool, counterID)
+	if err != nil {
+		return nil, nil, err
+	}
+	w, err := getWindow(ctx, pool, counterID, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if q.Fill {
+		h, err := windowHistory(ctx, pool, counter, w, time.Now().UTC())
+		if err != nil {
+			return nil, nil, err
+		}
+		periods, next, err := h.page(ctx, q)
+		if err != nil {
+			return nil, nil, err
+		}
+		blank := Count{CounterID: w.CounterID, Window: w.Name, Filled: true}
+		counts := make([]Count, 0, len(periods))
+		for _, p := range periods {
+			counts = append(counts, p.asCount(blank))
+		}
+		return counts, next, nil
+	}
+	limit := pageLimit(q.Limit)
+	from, to := optionalTime(q.From), optionalTime(q.To)
+	var cursorTime *time.Time
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorTime, cursorID = &q.Cursor.Time, q.Cursor.ID
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	rows, err := pool.Query(ctx,
+		`SELECT `+windowCountColumns+` FROM window_counts
+		 WHERE window_id = $1
+		   AND ($2::timestamptz IS NULL OR expiry > $2)
+		   AND ($3::timestamptz IS NULL OR period_start < $3)
+		   AND ($4::timestamptz IS NULL OR (expiry, id) `+op+` ($4, $5))
+		 ORDER BY expiry `+dir+`, id `+dir+`
+		 LIMIT $6`,
+		w.ID, from, to, cursorTime, cursorID, limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	counts := []Count{}
+	for rows.Next() {
+		c, err := scanWindowCount(rows, w)
+		if err != nil {
+			return nil, nil, err
+		}
+		counts = append(counts, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(counts) <= limit {
+		return counts, nil, nil
+	}
+	counts = counts[:limit]
+	last := counts[limit-1]
+	return counts, &Cursor{Time: last.expiry, ID: last.ID}, nil
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestWindows tests that windows are backfilled from the event log and follow
+// increments, clamped decrements and undo.
+func TestWindows(t *testing.T) {
+	pool, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	counter, err := CreateCounter(ctx, pool, "windows-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	if _, err := ApplyMutation(ctx, pool, counter.ID, Mutation{Delta: 2, At: time.Now().UTC().AddDate(0, 0, -1)}); err != nil {
+		t.Fatalf("failed to backdate increment: %v", err)
+	}
+	if _, err := IncrementCurrentCount(ctx, pool, counter.ID, 3); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+
+	weekly, err := CreateWindow(ctx, pool, counter.ID, "weekly", "1w")
+	if err != nil {
+		t.Fatalf("failed to create window: %v", err)
+	}
+	if weekly.Name != "weekly" || weekly.Frequency != "1w" {
+		t.Errorf("unexpected window: %+v", weekly)
+	}
+	if _, err := CreateWindow(ctx, pool, counter.ID, "weekly", "1M"); !errors.Is(err, ErrDuplicateWindowName) {
+		t.Errorf("expected ErrDuplicateWindowName, got %v", err)
+	}
+	if _, err := CreateWindow(ctx, pool, counter.ID, "bad", "7x"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for an invalid frequency, got %v", err)
+	}
+	if _, err := CreateWindow(ctx, pool, counter.ID, "monthly", "1M"); err != nil {
+		t.Fatalf("failed to create window: %v", err)
+	}
+
+	total := func(name string) int64 {
+		counts, _, err := GetWindowCounts(ctx, pool, counter.ID, name, CountQuery{})
+		if err != nil {
+			t.Fatalf("failed to get %s counts: %v", name, err)
+		}
+		var sum int64
+		for _, c := range counts {
+			if c.Window != name || c.CounterID != counter.ID {
+				t.Errorf("expected a count of window %s, got %+v", name, c)
+			}
+			sum += c.Value
+		}
+		return sum
+	}
+
+	steps := []struct {
+		name  string
+		apply func() error
+		total int64
+	}{
+		{"backfill", func() error { return nil }, 5},
+		{"increment", func() error { _, err := IncrementCurrentCount(ctx, pool, counter.ID, 4); return err }, 9},
+		{"clamped decrement", func() error { _, err := IncrementCurrentCount(ctx, pool, counter.ID, -10); return err }, 2},
+		{"undo", func() error { _, err := Undo(ctx, pool, counter.ID, time.Minute); return err }, 9},
+	}
+	for _, step := range steps {
+		if err := step.apply(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		for _, name := range []string{"weekly", "monthly"} {
+			if got := total(name); got != step.total {
+				t.Errorf("%s: expected %s total %d, got %d", step.name, name, step.total, got)
+			}
+		}
+	}
+
+	// The running period of a window holds at least today's changes
+	cnt, err := GetCurrentWindowCount(ctx, pool, counter.ID, "weekly")
+	if err != nil {
+		t.Fatalf("failed to get window count: %v", err)
+	}
+	if cnt.Value < 7 {
+		t.Errorf("expected the running week to hold today's 7, got %d", cnt.Value)
+	}
+	filled, _, err := GetWindowCounts(ctx, pool, counter.ID, "weekly", CountQuery{Fill: true})
+	if err != nil {
+		t.Fatalf("failed to get filled window counts: %v", err)
+	}
+	if len(filled) == 0 || filled[0].Expiry != cnt.Expiry {
+		t.Errorf("expected the filled history to start with the running week, got %+v", filled)
+	}
+
+	windows, err := GetWindows(ctx, pool, counter.ID)
+	if err != nil {
+		t.Fatalf("failed to list windows: %v", err)
+	}
+	if len(windows) != 2 {
+		t.Errorf("expected 2 windows, got %d", len(windows))
+	}
+	if err := DeleteWindow(ctx, pool, counter.ID, "weekly"); err != nil {
+		t.Fatalf("failed to delete window: %v", err)
+	}
+	if _, err := GetCurrentWindowCount(ctx, pool, counter.ID, "weekly"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a deleted window, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+// Package retry spaces out the tries of work that keeps failing, such as
+// webhook deliveries and alert notifications.
+package retry
+
+import "time"
+
+// Backoff is a retry policy with exponential backoff.
+type Backoff struct {
+	// MaxAttempts is how often the work is tried before it fails for good.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry; it doubles with every
+	// further retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// RetryAt returns when to try again after attempts failed tries ending at
+// now, or a zero time once MaxAttempts are used up.
+func (b Backoff) RetryAt(now time.Time, attempts int) time.Time {
+	if attempts >= b.MaxAttempts {
+		return time.Time{}
+	}
+	return now.Add(b.Delay(attempts))
+}
+
+// Delay returns the wait before the next try after attempts failed tries:
+// BaseDelay doubled for every failure after the first, capped at MaxDelay.
+func (b Backoff) Delay(attempts int) time.Duration {
+	delay := b.BaseDelay
+	for i := 1; i < attempts && delay < b.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, b.MaxDelay)
+}
@@ -0,0 +1,36 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBackoff tests that the retry delay doubles up to the maximum and that
+// no retry follows the last attempt.
+func TestBackoff(t *testing.T) {
+	b := Backoff{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := b.Delay(tt.attempts); got != tt.want {
+			t.Errorf("Delay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+
+	now := time.Date(2025, 11, 14, 12, 0, 0, 0, time.UTC)
+	if got, want := b.RetryAt(now, 4), now.Add(8*time.Second); !got.Equal(want) {
+		t.Errorf("RetryAt(4) = %v, want %v", got, want)
+	}
+	if got := b.RetryAt(now, 5); !got.IsZero() {
+		t.Errorf("expected no retry after the last attempt, got %v", got)
+	}
+}
@@ -0,0 +1,177 @@
+// Package webhooks sends the deliveries queued in the webhook outbox to their
+// webhooks, signing each payload with the webhook's secret.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iben12/counter-app/internal/models"
+	"github.com/iben12/counter-app/internal/retry"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Headers of a webhook request. The signature is "sha256=" followed by the
+// result of Sign for the timestamp header and the body.
+const (
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// leaseMargin is how much longer than the client timeout a claimed delivery is
+// held back from other dispatchers.
+const leaseMargin = time.Minute
+
+// maxResponseBody bounds how much of a response body is read before the
+// connection is reused.
+const maxResponseBody = 64 << 10
+
+// Sign returns the hex HMAC-SHA256, keyed with secret, of the timestamp (Unix
+// seconds), a dot and the body. Receivers recompute it to check that a
+// request comes from this server and was not altered or replayed.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends due deliveries and queues the counters' rollovers. Several
+// dispatchers may share a database; each delivery is claimed by one of them
+// at a time.
+type Dispatcher struct {
+	Pool *pgxpool.Pool
+	// Client sends the requests. It must have a timeout.
+	Client *http.Client
+	// PollInterval is how often the outbox is checked for due deliveries.
+	PollInterval time.Duration
+	// RolloverInterval is the longest wait between two checks for rollovers,
+	// which are otherwise checked as the next period ends. It bounds how long
+	// new counters, webhooks and schedule changes take to be noticed.
+	RolloverInterval time.Duration
+	// BatchSize is how many deliveries are claimed and sent at once.
+	BatchSize int
+	// Backoff spaces out the tries of a delivery.
+	retry.Backoff
+}
+
+// NewDispatcher returns a dispatcher for pool with the default settings: a
+// 10 second timeout, polling every second and 10 attempts over about four hours.
+func NewDispatcher(pool *pgxpool.Pool) *Dispatcher {
+	return &Dispatcher{
+		Pool:             pool,
+		Client:           &http.Client{Timeout: 10 * time.Second},
+		PollInterval:     time.Second,
+		RolloverInterval: time.Minute,
+		BatchSize:        50,
+		Backoff:          retry.Backoff{MaxAttempts: 10, BaseDelay: 30 * time.Second, MaxDelay: 6 * time.Hour},
+	}
+}
+
+// Run dispatches every PollInterval, and queues the rollovers as the counters'
+// periods end, until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	var nextRollover time.Time
+	for {
+		if now := time.Now(); !now.Before(nextRollover) {
+			next, err := models.EnqueueRollovers(ctx, d.Pool)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("webhook rollovers failed: %v", err)
+			}
+			nextRollover = now.Add(d.RolloverInterval)
+			if err == nil && !next.IsZero() && next.Before(nextRollover) {
+				nextRollover = next
+			}
+		}
+		if err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("webhook dispatch failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch sends all due deliveries.
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	for {
+		claimed, err := models.ClaimWebhookDeliveries(ctx, d.Pool, d.BatchSize, d.Client.Timeout+leaseMargin)
+		if err != nil {
+			return err
+		}
+		var wg sync.WaitGroup
+		errc := make(chan error, len(claimed))
+		for _, c := range claimed {
+			wg.Add(1)
+			go func(c models.ClaimedDelivery) {
+				defer wg.Done()
+				r := d.send(ctx, c)
+				if ctx.Err() != nil {
+					// Shutting down; the delivery is retried once its lease ends
+					return
+				}
+				errc <- models.RecordWebhookAttempt(ctx, d.Pool, c.ID, r)
+			}(c)
+		}
+		wg.Wait()
+		close(errc)
+		for err := range errc {
+			if err != nil {
+				return err
+			}
+		}
+		if len(claimed) < d.BatchSize || ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// send posts a delivery to its webhook. Any 2xx response counts as delivered.
+func (d *Dispatcher) send(ctx context.Context, c models.ClaimedDelivery) models.AttemptResult {
+	var r models.AttemptResult
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(c.Payload))
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(c.ID, 10))
+	req.Header.Set(HeaderEvent, c.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, "sha256="+Sign(c.Secret, timestamp, c.Payload))
+
+	start := time.Now()
+	resp, err := d.Client.Do(req)
+	r.Duration = time.Since(start)
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+		resp.Body.Close()
+		r.StatusCode = resp.StatusCode
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			r.Delivered = true
+			return r
+		}
+		r.Error = "unexpected status: " + resp.Status
+	}
+	r.RetryAt = d.RetryAt(time.Now(), c.Attempts+1)
+	return r
+}
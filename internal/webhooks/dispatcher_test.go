@@ -0,0 +1,123 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iben12/counter-app/internal/models"
+	"github.com/iben12/counter-app/internal/testdb"
+)
+
+// TestSign tests the signature against a known HMAC-SHA256 value and that it
+// covers the secret, the timestamp and the body.
+func TestSign(t *testing.T) {
+	// printf '1.{}' | openssl dgst -sha256 -hmac key
+	if got, want := Sign("key", 1, []byte("{}")), "1ba6b8171186efc613e8bcc0cbdab2748f24984d7c5a84faa2637afa0e40d224"; got != want {
+		t.Errorf("expected signature %s, got %s", want, got)
+	}
+	got := Sign("key", 1, []byte("{}"))
+	for _, other := range []string{Sign("other", 1, []byte("{}")), Sign("key", 2, []byte("{}")), Sign("key", 1, []byte("[]"))} {
+		if other == got {
+			t.Error("expected a different signature when the secret, timestamp or body changes")
+		}
+	}
+}
+
+// TestDispatcher tests that a failed delivery is retried and that requests
+// are signed with the webhook's secret.
+func TestDispatcher(t *testing.T) {
+	pool, cleanup := testdb.Setup(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var requests []*http.Request
+	var bodies [][]byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	counter, err := models.CreateCounter(ctx, pool, "dispatcher-test", "1d", "UTC")
+	if err != nil {
+		t.Fatalf("failed to create counter: %v", err)
+	}
+	webhook, err := models.CreateWebhook(ctx, pool, models.WebhookSettings{
+		URL:    receiver.URL,
+		Events: []string{models.WebhookCountChanged},
+		Secret: "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	if _, err := models.IncrementCurrentCount(ctx, pool, counter.ID, 2); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+
+	d := NewDispatcher(pool)
+	d.BaseDelay = time.Millisecond
+	if err := d.Dispatch(ctx); err != nil {
+		t.Fatalf("first dispatch failed: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := d.Dispatch(ctx); err != nil {
+		t.Fatalf("second dispatch failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	for i, r := range requests {
+		ts, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if err != nil {
+			t.Fatalf("invalid timestamp header: %v", err)
+		}
+		if got, want := r.Header.Get(HeaderSignature), "sha256="+Sign("s3cret", ts, bodies[i]); got != want {
+			t.Errorf("expected signature %s, got %s", want, got)
+		}
+		if r.Header.Get(HeaderEvent) != models.WebhookCountChanged {
+			t.Errorf("expected event header %s, got %s", models.WebhookCountChanged, r.Header.Get(HeaderEvent))
+		}
+	}
+	var payload models.WebhookEvent
+	if err := json.Unmarshal(bodies[1], &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if payload.CounterID != counter.ID || payload.Count == nil || payload.Count.Value != 2 {
+		t.Errorf("unexpected payload: %s", bodies[1])
+	}
+
+	deliveries, _, err := models.GetWebhookDeliveries(ctx, pool, webhook.ID, models.DeliveryQuery{})
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(deliveries))
+	}
+	got := deliveries[0]
+	if got.Status != models.DeliveryDelivered || got.Attempts != 2 || len(got.AttemptLog) != 2 {
+		t.Errorf("expected a delivery delivered on the second attempt, got %+v", got)
+	}
+	if code := got.AttemptLog[0].StatusCode; code == nil || *code != http.StatusServiceUnavailable {
+		t.Errorf("expected the first attempt to log status 503, got %v", code)
+	}
+}